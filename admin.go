@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bcspragu/fineprint/store"
+)
+
+// requireAdmin wraps an admin-only handler, which requires the admin token as
+// a bearer token. Admin endpoints are disabled entirely if there's no token.
+func (h *Handler) requireAdmin(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if h.adminToken == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// handleMessages shows what we know about inbound emails, for debugging
+// duplicate deliveries. With an id parameter, it returns the record for that
+// Message-ID, otherwise the most recent records (up to limit, default 50),
+// along with the number of emails waiting in the queue.
+func (h *Handler) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		msg, err := h.store.Message(id)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "No such message", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Failed to load message %q: %v", id, err)
+			http.Error(w, "Failed to load message", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, msg)
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Bad limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	msgs, err := h.store.RecentMessages(limit)
+	if err != nil {
+		log.Printf("Failed to load recent messages: %v", err)
+		http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		Queued   int              `json:"queued"`
+		Messages []*store.Message `json:"messages"`
+	}{
+		Queued:   h.queue.Len(),
+		Messages: msgs,
+	})
+}
+
+// handleWatches manages watched policies. GET lists them, and POST and DELETE
+// add and remove the watcher given by the email parameter for the policy given
+// by the url parameter. When adding the first watcher, the company and
+// policy_type parameters describe the policy.
+func (h *Handler) handleWatches(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		watched, err := h.store.WatchedPolicies()
+		if err != nil {
+			log.Printf("Failed to load watched policies: %v", err)
+			http.Error(w, "Failed to load watched policies", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, watched)
+	case http.MethodPost, http.MethodDelete:
+		policyURL, email := r.FormValue("url"), r.FormValue("email")
+		if policyURL == "" || email == "" {
+			http.Error(w, "url and email are required", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			if err := h.store.RemoveWatcher(policyURL, email); errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "Not watching", http.StatusNotFound)
+			} else if err != nil {
+				log.Printf("Failed to remove watcher: %v", err)
+				http.Error(w, "Failed to remove watcher", http.StatusInternalServerError)
+			}
+			return
+		}
+		wp, err := h.store.AddWatcher(&store.WatchedPolicy{
+			URL:        policyURL,
+			Company:    r.FormValue("company"),
+			PolicyType: r.FormValue("policy_type"),
+		}, email)
+		if err != nil {
+			log.Printf("Failed to add watcher: %v", err)
+			http.Error(w, "Failed to add watcher", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, wp)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUsage shows how many LLM tokens we've used, in total, per user and
+// per company.
+func (h *Handler) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	totals, err := h.store.Usage()
+	if err != nil {
+		log.Printf("Failed to load usage: %v", err)
+		http.Error(w, "Failed to load usage", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, totals)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("failed to write JSON response: %v", err)
+	}
+}
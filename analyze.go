@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bcspragu/fineprint/claude"
+	"github.com/bcspragu/fineprint/htmlutil"
+	"github.com/bcspragu/fineprint/postmark"
+	"github.com/bcspragu/fineprint/ratelimit"
+	"github.com/bcspragu/fineprint/store"
+	"github.com/bcspragu/fineprint/webarchive"
+	"github.com/peterbourgon/ff/v3"
+	"golang.org/x/net/html/charset"
+)
+
+const analyzeUsage = `Usage: fineprint analyze [flags] <input>
+
+Runs a policy change through the same analysis we'd do for an email, and prints
+the results instead of replying. The input is one of:
+
+  <policy URL>           Compare the live policy to the last snapshot from before --date
+  <old file> <new file>  Compare two saved versions of a policy, as HTML or text
+  <email.eml>            Classify and analyze an email, in RFC 822 format
+  <email.json>           Classify and analyze an email, as a Postmark inbound webhook body
+
+Flags:
+`
+
+// runAnalyze implements "fineprint analyze", for trying out changes to the
+// analysis without setting up Postmark and sending ourselves emails.
+func runAnalyze(args []string) error {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), analyzeUsage)
+		fs.PrintDefaults()
+	}
+	var (
+		anthropicAPIKey  = fs.String("anthropic-api-key", "", "Anthropic API key")
+		archiveAccessKey = fs.String("archive-access-key", "", "Internet Archive access key")
+		archiveSecretKey = fs.String("archive-secret-key", "", "Internet Archive secret key")
+
+		format     = fs.String("format", "text", "Output format, text or json")
+		company    = fs.String("company", "", "Company the policy belongs to, if not analyzing an email. Guessed from the URL if empty")
+		policyType = fs.String("policy-type", "", "Type of policy, e.g. privacy_policy, if not analyzing an email. Guessed from the URL if empty")
+		policyURL  = fs.String("policy-url", "", "URL of the policy, when comparing two files")
+		date       = fs.String("date", "", "Date of the change (YYYY-MM-DD) when analyzing a URL. Defaults to today")
+	)
+	if err := ff.Parse(fs, args, ff.WithEnvVars()); err != nil {
+		return fmt.Errorf("failed to parse flags: %w", err)
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q, expected text or json", *format)
+	}
+
+	h := &Handler{
+		claudeClient:     claude.NewClient(*anthropicAPIKey),
+		webarchiveClient: webarchive.NewClient(*archiveAccessKey, *archiveSecretKey),
+		rateLimiter:      ratelimit.NewRateLimiter(),
+		store:            store.NewMemory(),
+		// The URLs we're given here come from whoever's running us, not from
+		// emails, so there's no need to keep them to the public internet (see
+		// defaultPolicyClient).
+		policyClient: &http.Client{Timeout: defaultPolicyClient.Timeout},
+	}
+	defer h.store.Close()
+
+	analysis := &store.Analysis{}
+	var err error
+	switch inputs := fs.Args(); {
+	case len(inputs) == 1 && (strings.HasPrefix(inputs[0], "http://") || strings.HasPrefix(inputs[0], "https://")):
+		changeDate := time.Now()
+		if *date != "" {
+			if changeDate, err = time.Parse(time.DateOnly, *date); err != nil {
+				return fmt.Errorf("invalid --date: %w", err)
+			}
+		}
+		err = h.analyzeURL(inputs[0], *company, *policyType, changeDate, analysis)
+	case len(inputs) == 1:
+		err = h.analyzeEmailFile(inputs[0], analysis)
+	case len(inputs) == 2:
+		err = h.analyzeFiles(inputs[0], inputs[1], *policyURL, *company, *policyType, analysis)
+	default:
+		fs.Usage()
+		return errors.New("expected a policy URL, two policy files, or an email file")
+	}
+	if err != nil {
+		return err
+	}
+
+	res := newAnalyzeResult(analysis)
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	_, err = io.WriteString(os.Stdout, res.String())
+	return err
+}
+
+// analyzeURL analyzes the policy at rawURL as if we'd received an email on
+// changeDate saying it changed.
+func (h *Handler) analyzeURL(rawURL, company, policyType string, changeDate time.Time, analysis *store.Analysis) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid policy URL %q: %w", rawURL, err)
+	}
+	guessedCompany, guessedType := guessPolicy(u)
+	pc := &claude.PolicyClassification{
+		IsPolicyChange: true,
+		Company:        cmp.Or(company, guessedCompany),
+		PolicyType:     cmp.Or(policyType, guessedType),
+		PolicyURL:      u.String(),
+	}
+	analysis.Classification = pc
+	return h.analyzeClassified(pc, changeDate, analysis)
+}
+
+// analyzeEmailFile classifies and analyzes the email in the given file, which
+// is either a raw email (.eml) or a Postmark webhook body (.json).
+func (h *Handler) analyzeEmailFile(path string, analysis *store.Analysis) error {
+	var (
+		email *postmark.InboundEmail
+		err   error
+	)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		email, err = readPostmarkEmail(path)
+	case ".eml":
+		email, err = readEML(path)
+	default:
+		return fmt.Errorf("don't know how to read %q, expected a .eml or .json email", path)
+	}
+	if err != nil {
+		return err
+	}
+	analysis.From, analysis.Subject = email.From, email.Subject
+
+	pc, err := h.claudeClient.ClassifyPolicyChange(email.Subject, email.TextBody, email.HtmlBody)
+	analysis.Usage.Add(pc.Usage)
+	if err != nil {
+		return fmt.Errorf("failed to classify email: %w", err)
+	}
+	analysis.Classification = pc
+	if !pc.IsPolicyChange {
+		analysis.Outcome = "Not a policy change"
+		return nil
+	}
+
+	emailDate, err := parseEmailDate(email.Date)
+	if err != nil {
+		log.Printf("Failed to parse email date %q, using current date: %v", email.Date, err)
+		emailDate = time.Now()
+	}
+	return h.analyzeClassified(pc, emailDate, analysis)
+}
+
+func (h *Handler) analyzeClassified(pc *claude.PolicyClassification, changeDate time.Time, analysis *store.Analysis) error {
+	draft, err := h.analyzePolicyChange(pc, changeDate, analysis)
+	if err != nil {
+		return err
+	}
+	if draft == nil {
+		return errors.New("couldn't find the policy, check the logs for what we tried")
+	}
+	return nil
+}
+
+// analyzeFiles compares two versions of a policy saved to disk.
+func (h *Handler) analyzeFiles(previousPath, currentPath, policyURL, company, policyType string, analysis *store.Analysis) error {
+	previous, err := readPolicyFile(previousPath)
+	if err != nil {
+		return err
+	}
+	current, err := readPolicyFile(currentPath)
+	if err != nil {
+		return err
+	}
+
+	pc := &claude.PolicyClassification{
+		IsPolicyChange: true,
+		Company:        company,
+		PolicyType:     policyType,
+		PolicyURL:      policyURL,
+	}
+	if u, err := url.Parse(policyURL); err == nil && u.Host != "" {
+		guessedCompany, guessedType := guessPolicy(u)
+		pc.Company, pc.PolicyType = cmp.Or(company, guessedCompany), cmp.Or(policyType, guessedType)
+	}
+	pc.PolicyType = cmp.Or(pc.PolicyType, "other")
+	analysis.Classification = pc
+	analysis.PolicyURL = cmp.Or(policyURL, currentPath)
+	analysis.PreviousHash, analysis.CurrentHash = store.HashText(previous), store.HashText(current)
+	analysis.PreviousURL = previousPath
+
+	if _, err := h.reportChanges(pc, analysis, previous, current); err != nil {
+		return fmt.Errorf("failed to generate diff report: %w", err)
+	}
+	if analysis.Changes == "" {
+		analysis.Outcome = "No changes"
+	}
+	return nil
+}
+
+// readPolicyFile reads a saved policy, converting it to Markdown like we do
+// for live pages if it's HTML.
+func readPolicyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read policy: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		text, err := htmlutil.ExtractMainMarkdown(bytes.NewReader(data), nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to convert %q to Markdown: %w", path, err)
+		}
+		return text, nil
+	default:
+		return string(data), nil
+	}
+}
+
+func readPostmarkEmail(path string) (*postmark.InboundEmail, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email: %w", err)
+	}
+	var email postmark.InboundEmail
+	if err := json.Unmarshal(data, &email); err != nil {
+		return nil, fmt.Errorf("failed to parse Postmark email %q: %w", path, err)
+	}
+	return &email, nil
+}
+
+// readEML reads a raw email into the parts of a Postmark inbound email that
+// we use for analysis.
+func readEML(path string) (*postmark.InboundEmail, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open email: %w", err)
+	}
+	defer f.Close()
+
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email %q: %w", path, err)
+	}
+	dec := &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	email := &postmark.InboundEmail{
+		From:    msg.Header.Get("From"),
+		Subject: subject,
+		Date:    msg.Header.Get("Date"),
+	}
+	if addr, err := mail.ParseAddress(email.From); err == nil {
+		email.From, email.FromName = addr.Address, addr.Name
+	}
+	if err := readEMLPart(email, msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body); err != nil {
+		return nil, fmt.Errorf("failed to read body of email %q: %w", path, err)
+	}
+	return email, nil
+}
+
+// readEMLPart fills in the text and HTML bodies of email from a (possibly
+// multipart) MIME part, keeping the first of each that it finds. Bodies are
+// decoded from the part's charset to UTF-8.
+func readEMLPart(email *postmark.InboundEmail, contentType, encoding string, r io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// No (or a broken) Content-Type means plain text.
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := readEMLPart(email, p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+	if cs := params["charset"]; cs != "" {
+		if cr, err := charset.NewReaderLabel(cs, r); err != nil {
+			log.Printf("Not decoding %s part with unknown charset %q: %v", mediaType, cs, err)
+		} else {
+			r = cr
+		}
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	switch {
+	case mediaType == "text/plain" && email.TextBody == "":
+		email.TextBody = string(body)
+	case mediaType == "text/html" && email.HtmlBody == "":
+		email.HtmlBody = string(body)
+	}
+	return nil
+}
+
+// analyzeResult is what "fineprint analyze" prints.
+type analyzeResult struct {
+	Classification *claude.PolicyClassification `json:"classification"`
+	PolicyURL      string                       `json:"policy_url,omitempty"`
+	// Snapshot is the previous version of the policy we compared against.
+	Snapshot          *analyzeSnapshot         `json:"snapshot,omitempty"`
+	Diff              string                   `json:"diff,omitempty"`
+	DiffHighlights    []claude.DiffHighlight   `json:"diff_highlights,omitempty"`
+	SummaryHighlights []claude.PolicyHighlight `json:"summary_highlights,omitempty"`
+	Trimmed           bool                     `json:"trimmed,omitempty"`
+	AnalyzedPercent   int                      `json:"analyzed_percent,omitempty"`
+	Usage             claude.Usage             `json:"usage,omitzero"`
+	Outcome           string                   `json:"outcome,omitempty"`
+}
+
+type analyzeSnapshot struct {
+	URL  string    `json:"url"`
+	Date time.Time `json:"date,omitzero"`
+}
+
+func newAnalyzeResult(a *store.Analysis) *analyzeResult {
+	res := &analyzeResult{
+		Classification:    a.Classification,
+		PolicyURL:         a.PolicyURL,
+		Diff:              a.Changes,
+		DiffHighlights:    a.DiffHighlights,
+		SummaryHighlights: a.SummaryHighlights,
+		Trimmed:           a.Trimmed,
+		AnalyzedPercent:   a.AnalyzedPercent,
+		Usage:             a.Usage,
+		Outcome:           a.Outcome,
+	}
+	if a.PreviousURL != "" {
+		res.Snapshot = &analyzeSnapshot{URL: a.PreviousURL, Date: a.PreviousDate}
+	}
+	return res
+}
+
+func (r *analyzeResult) String() string {
+	var sb strings.Builder
+	if pc := r.Classification; pc != nil {
+		fmt.Fprintf(&sb, "Classification: policy change=%t, type=%s, company=%s, confidence=%s\n",
+			pc.IsPolicyChange, pc.PolicyType, pc.Company, cmp.Or(pc.Confidence, "n/a"))
+	}
+	if r.PolicyURL != "" {
+		fmt.Fprintf(&sb, "Policy: %s\n", r.PolicyURL)
+	}
+	if r.Snapshot != nil {
+		fmt.Fprintf(&sb, "Compared against: %s", r.Snapshot.URL)
+		if !r.Snapshot.Date.IsZero() {
+			fmt.Fprintf(&sb, " (%s)", r.Snapshot.Date.Format(time.DateOnly))
+		}
+		sb.WriteString("\n")
+	}
+	if r.Outcome != "" {
+		fmt.Fprintf(&sb, "Outcome: %s\n", r.Outcome)
+	}
+	if r.Diff != "" {
+		fmt.Fprintf(&sb, "\nChanges:\n\n%s\n", strings.TrimRight(r.Diff, "\n"))
+	}
+	if len(r.DiffHighlights) > 0 {
+		sb.WriteString("\nWhat changed:\n\n")
+		for _, hl := range r.DiffHighlights {
+			fmt.Fprintf(&sb, "- [%s] %s\n", hl.Classification, hl.Description)
+		}
+	}
+	if len(r.SummaryHighlights) > 0 {
+		sb.WriteString("\nNo previous version found, so here's a summary of the policy:\n\n")
+		for _, hl := range r.SummaryHighlights {
+			fmt.Fprintf(&sb, "- [%s] %s\n", hl.Classification, hl.Description)
+		}
+	}
+	if u := r.Usage; u != (claude.Usage{}) {
+		fmt.Fprintf(&sb, "\nTokens used: %d input, %d output, %d written to and %d read from the cache\n",
+			u.InputTokens, u.OutputTokens, u.CacheCreationInputTokens, u.CacheReadInputTokens)
+	}
+	if r.Trimmed {
+		if r.AnalyzedPercent > 0 {
+			fmt.Fprintf(&sb, "\nNote: the policy was too long to analyze in full, so only the first %d%% was analyzed.\n", r.AnalyzedPercent)
+		} else {
+			sb.WriteString("\nNote: the policy was too long to analyze in full, so it was cut short.\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bcspragu/fineprint/claude"
+	"github.com/bcspragu/fineprint/claude/claudetest"
+	"github.com/bcspragu/fineprint/postmark"
+	"github.com/bcspragu/fineprint/store"
+)
+
+func TestReadEML(t *testing.T) {
+	tests := []struct {
+		file string
+		want *postmark.InboundEmail
+	}{
+		{
+			file: "latin1.eml",
+			want: &postmark.InboundEmail{
+				From:     "privacy@acme.example",
+				FromName: "Acme Privacy",
+				Subject:  "Mise à jour de notre politique",
+				Date:     "Mon, 3 Mar 2025 10:00:00 +0000",
+				TextBody: "Nous avons mis à jour notre politique de confidentialité.\r\n",
+			},
+		},
+		{
+			file: "alternative.eml",
+			want: &postmark.InboundEmail{
+				From:     "noreply@acme.example",
+				FromName: "Acme",
+				Subject:  "We've updated our Privacy Policy",
+				Date:     "Tue, 4 Mar 2025 09:30:00 -0500",
+				TextBody: "We’ve updated our Privacy Policy: https://acme.example/privacy",
+				HtmlBody: `<p>We’ve updated our <a href="https://acme.example/privacy">Privacy Policy</a>.</p>`,
+			},
+		},
+		{
+			// Nested multiparts, an attachment to skip, and a charset we don't know,
+			// which we pass through as is.
+			file: "mixed.eml",
+			want: &postmark.InboundEmail{
+				From:     "legal@acme.example",
+				Subject:  "Updated Terms",
+				TextBody: "Our Terms have changed.",
+				HtmlBody: "<p>Our <b>Terms</b> have changed.</p>",
+			},
+		},
+		{
+			file: "no_content_type.eml",
+			want: &postmark.InboundEmail{
+				From:     "privacy@acme.example",
+				Subject:  "Policy update",
+				TextBody: "Our policy changed.\r\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := readEML(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatalf("readEML: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readEML = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadEMLPart(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        string
+		wantText    string
+		wantHTML    string
+	}{
+		{
+			name:        "plain text",
+			contentType: "text/plain",
+			body:        "Hello",
+			wantText:    "Hello",
+		},
+		{
+			name:        "quoted-printable Latin-1",
+			contentType: `text/plain; charset="iso-8859-1"`,
+			encoding:    "Quoted-Printable",
+			body:        "Caf=E9 =\r\nterms",
+			wantText:    "Café terms",
+		},
+		{
+			name:        "base64 HTML",
+			contentType: "text/html; charset=UTF-8",
+			encoding:    "base64",
+			body:        "PHA+SGk8L3A+",
+			wantHTML:    "<p>Hi</p>",
+		},
+		{
+			name:        "not text",
+			contentType: "image/png",
+			encoding:    "base64",
+			body:        "iVBORw0KGgo=",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var email postmark.InboundEmail
+			if err := readEMLPart(&email, tt.contentType, tt.encoding, strings.NewReader(tt.body)); err != nil {
+				t.Fatalf("readEMLPart: %v", err)
+			}
+			if email.TextBody != tt.wantText || email.HtmlBody != tt.wantHTML {
+				t.Errorf("readEMLPart gave text %q and HTML %q, want %q and %q", email.TextBody, email.HtmlBody, tt.wantText, tt.wantHTML)
+			}
+		})
+	}
+}
+
+func TestAnalyzeFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"previous.html": previousPolicy,
+		"current.html":  currentPolicy,
+		"previous.txt":  "We never sell your data.",
+		"current.txt":   "We sell your data to advertisers.",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name              string
+		previous, current string
+		policyURL         string
+		want              *claude.PolicyClassification
+		wantChanges       bool
+		wantOutcome       string
+	}{
+		{
+			name:        "HTML",
+			previous:    "previous.html",
+			current:     "current.html",
+			policyURL:   "https://www.acme.com/legal/privacy",
+			want:        &claude.PolicyClassification{IsPolicyChange: true, Company: "acme.com", PolicyType: "privacy_policy", PolicyURL: "https://www.acme.com/legal/privacy"},
+			wantChanges: true,
+		},
+		{
+			name:        "text without a URL",
+			previous:    "previous.txt",
+			current:     "current.txt",
+			want:        &claude.PolicyClassification{IsPolicyChange: true, PolicyType: "other"},
+			wantChanges: true,
+		},
+		{
+			name:        "no changes",
+			previous:    "current.html",
+			current:     "current.html",
+			want:        &claude.PolicyClassification{IsPolicyChange: true, PolicyType: "other"},
+			wantOutcome: "No changes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := claudetest.NewServer()
+			defer llm.Close()
+			llm.Respond("extract_highlights", &claude.DiffSummary{Highlights: []claude.DiffHighlight{
+				{Description: "The service now sells your data", Classification: "bad"},
+			}})
+			h := &Handler{
+				claudeClient: llm.Client(),
+				store:        store.NewMemory(),
+				reportTTL:    time.Hour,
+			}
+
+			analysis := &store.Analysis{}
+			err := h.analyzeFiles(filepath.Join(dir, tt.previous), filepath.Join(dir, tt.current), tt.policyURL, "", "", analysis)
+			if err != nil {
+				t.Fatalf("analyzeFiles: %v", err)
+			}
+			if !reflect.DeepEqual(analysis.Classification, tt.want) {
+				t.Errorf("classification = %+v, want %+v", analysis.Classification, tt.want)
+			}
+			if tt.wantChanges {
+				if !strings.Contains(analysis.Changes, "advertisers") || len(analysis.DiffHighlights) != 1 {
+					t.Errorf("analysis = %+v, want the changes and their highlights", analysis)
+				}
+			} else if analysis.Changes != "" || len(llm.Requests()) != 0 {
+				t.Errorf("analysis.Changes = %q with %d LLM requests, want no changes and no requests", analysis.Changes, len(llm.Requests()))
+			}
+			if analysis.Outcome != tt.wantOutcome {
+				t.Errorf("analysis.Outcome = %q, want %q", analysis.Outcome, tt.wantOutcome)
+			}
+		})
+	}
+}
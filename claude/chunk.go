@@ -0,0 +1,207 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// isDiffBoundary reports whether line starts a new part of a change
+// description, which is either a section in a sectiondiff change list, or a
+// hunk in a unified diff.
+func isDiffBoundary(line string) bool {
+	return strings.HasPrefix(line, "=== Section ") || strings.HasPrefix(line, "@@")
+}
+
+// isDocumentBoundary reports whether line starts a new section of a Markdown
+// document.
+func isDocumentBoundary(line string) bool {
+	return strings.HasPrefix(line, "#")
+}
+
+// noBoundary is for text without any particular structure, which is split
+// between lines.
+func noBoundary(string) bool {
+	return false
+}
+
+// splitChunks splits text into chunks of at most limit bytes, so that each
+// can be analyzed separately. Chunks break before lines where isBoundary is
+// true, packing as many whole parts into each chunk as fit. Parts that are too
+// big on their own are broken between lines, and lines that are too big on
+// their own between runes.
+func splitChunks(text string, limit int, isBoundary func(line string) bool) []string {
+	if len(text) <= limit {
+		return []string{text}
+	}
+
+	// Break the text into parts that each fit in a chunk.
+	var (
+		parts []string
+		part  strings.Builder
+	)
+	flush := func() {
+		if part.Len() > 0 {
+			parts = append(parts, part.String())
+			part.Reset()
+		}
+	}
+	for line := range strings.SplitAfterSeq(text, "\n") {
+		if isBoundary(strings.TrimSpace(line)) {
+			flush()
+		}
+		if part.Len()+len(line) > limit {
+			flush()
+		}
+		for len(line) > limit {
+			cut := limit
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				// The limit is smaller than the first rune, which gets a part
+				// to itself rather than being split.
+				_, cut = utf8.DecodeRuneInString(line)
+			}
+			parts = append(parts, line[:cut])
+			line = line[cut:]
+		}
+		part.WriteString(line)
+	}
+	flush()
+
+	// Then pack the parts back together.
+	var chunks []string
+	var chunk strings.Builder
+	for _, p := range parts {
+		if chunk.Len() > 0 && chunk.Len()+len(p) > limit {
+			chunks = append(chunks, chunk.String())
+			chunk.Reset()
+		}
+		chunk.WriteString(p)
+	}
+	if chunk.Len() > 0 {
+		chunks = append(chunks, chunk.String())
+	}
+	return chunks
+}
+
+// totalLen returns the combined length of chunks.
+func totalLen(chunks []string) int {
+	n := 0
+	for _, c := range chunks {
+		n += len(c)
+	}
+	return n
+}
+
+// partNote tells the LLM which chunk of the input it's looking at, if there's
+// more than one.
+func partNote(what string, i, n int) string {
+	if n <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("The %s are too long to analyze at once, so they've been split into %d parts, and this is part %d. Only describe what's in this part; the highlights from all of the parts will be combined afterwards.\n\n", what, n, i+1)
+}
+
+// analyzeChunks makes a report on chunks of a policy (or its changes), with
+// one request per chunk (see request), consolidating the highlights of all
+// the chunks if there's more than one. A failure past the first chunk doesn't
+// waste the chunks before it: those are kept and the rest left out, as if
+// they'd been trimmed. If consolidation fails, the highlights are kept as they
+// are, in the order of the chunks they came from. It returns the highlights,
+// how many chunks they cover, and the tokens used, even on failure.
+func analyzeChunks[T any, H PolicyHighlight | DiffHighlight](c *Client, pc *PolicyClassification, what string, chunks []string, request func(i int) *Request, highlightsOf func(*T) []H) ([]H, int, Usage, error) {
+	var (
+		highlights []H
+		usage      Usage
+	)
+	analyzed := 0
+	for i := range chunks {
+		res, u, err := issueRequest[T](c, request(i))
+		usage.Add(u)
+		if err != nil && i == 0 {
+			return nil, 0, usage, err
+		} else if err != nil {
+			log.Printf("Failed to analyze part %d of %d of %s the %s policy, leaving out the rest: %v", i+1, len(chunks), what, pc.Company, err)
+			break
+		}
+		highlights = append(highlights, highlightsOf(res)...)
+		analyzed++
+	}
+	if analyzed > 1 {
+		consolidated, err := consolidateHighlights(c, pc, what, highlights, &usage)
+		if err != nil {
+			log.Printf("Failed to consolidate highlights, using them as they are: %v", err)
+		} else {
+			highlights = consolidated
+		}
+	}
+	return highlights, analyzed, usage, nil
+}
+
+type consolidatedHighlights[H PolicyHighlight | DiffHighlight] struct {
+	Highlights []H `json:"highlights" jsonschema:"required" description:"The combined highlights, most important first"`
+}
+
+// consolidateHighlights combines highlights from separately analyzed chunks
+// of a policy (or its changes) into one list, without duplicates and with
+// the most important highlights first. The tokens it uses are added to usage.
+func consolidateHighlights[H PolicyHighlight | DiffHighlight](c *Client, pc *PolicyClassification, what string, highlights []H, usage *Usage) ([]H, error) {
+	if len(highlights) == 0 {
+		return highlights, nil
+	}
+	highlightsJSON, err := json.MarshalIndent(highlights, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling highlights: %v", err)
+	}
+
+	prompt := fmt.Sprintf(`The highlights below were extracted from separate parts of %s a company document, so they may overlap or repeat each other. Combine them into a single list for an end-user:
+
+- Merge highlights that describe the same thing into one, keeping the most specific details (and any section references) from each
+- Keep every distinct highlight, don't drop any just to shorten the list
+- Don't add anything that isn't in the highlights
+- Order them from most to least important to a user, where changes that take away rights or share data (usually classified 'blocker' or 'bad') matter most
+
+<company>%s</company>
+
+<policy_url>%s</policy_url>
+
+<policy_type>%s</policy_type>
+
+<highlights>
+%s
+</highlights>
+`, what, pc.Company, pc.PolicyURL, pc.PolicyType, highlightsJSON)
+
+	reqBody := &Request{
+		Model:     c.ReportModel,
+		MaxTokens: 10000,
+		Tools: []Tool{
+			{
+				Name:        "consolidate_highlights",
+				Description: "Record the combined, deduplicated and ranked list of highlights",
+				InputSchema: *SchemaFor[consolidatedHighlights[H]](),
+			},
+		},
+		ToolChoice: &ToolChoice{
+			Type: "tool",
+			Name: "consolidate_highlights",
+		},
+		Messages: []Message{
+			{
+				Role:    "user",
+				Content: prompt,
+			},
+		},
+	}
+
+	res, u, err := issueRequest[consolidatedHighlights[H]](c, reqBody)
+	usage.Add(u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consolidate highlights: %w", err)
+	}
+	return res.Highlights, nil
+}
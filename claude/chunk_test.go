@@ -0,0 +1,84 @@
+package claude
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitChunks(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		limit      int
+		isBoundary func(string) bool
+		want       []string
+	}{
+		{
+			name:       "fits",
+			text:       "# One\nshort\n",
+			limit:      100,
+			isBoundary: isDocumentBoundary,
+			want:       []string{"# One\nshort\n"},
+		},
+		{
+			name:       "packs sections",
+			text:       "# One\naaaa\n# Two\nbbbb\n# Three\ncccc\n",
+			limit:      25,
+			isBoundary: isDocumentBoundary,
+			want:       []string{"# One\naaaa\n# Two\nbbbb\n", "# Three\ncccc\n"},
+		},
+		{
+			name:       "diff hunks",
+			text:       "@@ -1 +1 @@\n-a\n+b\n@@ -9 +9 @@\n-c\n+d\n",
+			limit:      20,
+			isBoundary: isDiffBoundary,
+			want:       []string{"@@ -1 +1 @@\n-a\n+b\n", "@@ -9 +9 @@\n-c\n+d\n"},
+		},
+		{
+			name:       "section change list",
+			text:       "=== Section \"1 Data\": modified\nx\n\n=== Section \"2 Sharing\": added\ny\n",
+			limit:      35,
+			isBoundary: isDiffBoundary,
+			want:       []string{"=== Section \"1 Data\": modified\nx\n\n", "=== Section \"2 Sharing\": added\ny\n"},
+		},
+		{
+			name:       "oversized section splits between lines",
+			text:       "# One\naaaaaaaa\nbbbbbbbb\ncccccccc\n",
+			limit:      20,
+			isBoundary: isDocumentBoundary,
+			want:       []string{"# One\naaaaaaaa\n", "bbbbbbbb\ncccccccc\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitChunks(tt.text, tt.limit, tt.isBoundary)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("splitChunks = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitChunks_LongLine(t *testing.T) {
+	// No line breaks at all, and multi-byte runes that don't line up with the
+	// limit.
+	text := strings.Repeat("é", 50)
+	chunks := splitChunks(text, 15, isDocumentBoundary)
+	if strings.Join(chunks, "") != text {
+		t.Fatalf("chunks don't add back up to the text: %q", chunks)
+	}
+	for _, c := range chunks {
+		if len(c) > 15 || !utf8.ValidString(c) {
+			t.Errorf("chunk %q is over the limit or splits a rune", c)
+		}
+	}
+}
+
+func TestSplitChunks_LimitUnderRune(t *testing.T) {
+	// Runes bigger than the limit can't be split, so each gets a chunk.
+	chunks := splitChunks("é€", 1, isDocumentBoundary)
+	if want := []string{"é", "€"}; strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Errorf("splitChunks = %q, want %q", chunks, want)
+	}
+}
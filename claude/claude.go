@@ -5,18 +5,137 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
-// InputByteLimit is the limit of how many characters we send to an LLM, to
-// avoid blowing out the context window (and our costs).
-const InputByteLimit = 150000
+// DefaultBaseURL is where the Anthropic API lives.
+const DefaultBaseURL = "https://api.anthropic.com"
+
+// Client talks to the Anthropic Messages API.
+type Client struct {
+	// BaseURL is the root of the API, DefaultBaseURL outside of tests.
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+
+	// ClassifyModel classifies emails, which is quick and happens for every
+	// email, so it should be a fast, cheap model. ReportModel writes the
+	// summaries we send to people.
+	ClassifyModel string
+	ReportModel   string
+
+	// MaxInputTokens caps how many input tokens we send in one request, which
+	// is also limited by the model's context window. Inputs that don't fit are
+	// trimmed, or for reports, analyzed in chunks. Zero means only the context
+	// window limits requests.
+	MaxInputTokens int
+
+	// MaxChunks caps the cost of a report. Inputs over MaxInputTokens are
+	// analyzed in chunks, plus a request to consolidate the results, and past
+	// MaxChunks chunks, the rest of the input is left out and the report is
+	// marked as trimmed. Zero means no limit.
+	MaxChunks int
+
+	// Requests that fail in ways that might be temporary, like the API being
+	// overloaded, are retried up to MaxRetries times. The wait between
+	// attempts starts around RetryBackoff and doubles each time, or is however
+	// long the API asks for, up to MaxRetryWait. If the API asks us to wait
+	// longer than that, we give up and return the error.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	MaxRetryWait time.Duration
+
+	// mu guards cacheable, which records whether each system prompt (and the
+	// tools before it) is long enough to cache, see cacheSystemPrompt.
+	mu        sync.Mutex
+	cacheable map[string]bool
+}
+
+func NewClient(apiKey string) *Client {
+	return &Client{
+		BaseURL: DefaultBaseURL,
+		APIKey:  apiKey,
+		HTTPClient: &http.Client{
+			// Reports on long policies can take a while to write.
+			Timeout: 5 * time.Minute,
+		},
+		ClassifyModel: "claude-haiku-4-5-20251001",
+		ReportModel:   "claude-sonnet-4-6",
+		// Well under the context window, since long prompts are slower,
+		// pricier and get less attention per detail.
+		MaxInputTokens: 50000,
+		MaxChunks:      8,
+		MaxRetries:     3,
+		RetryBackoff:   2 * time.Second,
+		MaxRetryWait:   time.Minute,
+	}
+}
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role string
+	// Content is the text of the message. Messages with more than text, like
+	// tool calls and their results, have Blocks instead.
+	Content string
+	Blocks  []ContentBlock
+}
+
+// message is how a Message looks on the wire, where the content is either a
+// string or a list of blocks.
+type message struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	var content any = m.Content
+	if m.Blocks != nil {
+		content = m.Blocks
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(message{Role: m.Role, Content: data})
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	*m = Message{Role: msg.Role}
+	if len(msg.Content) > 0 && msg.Content[0] == '"' {
+		return json.Unmarshal(msg.Content, &m.Content)
+	}
+	return json.Unmarshal(msg.Content, &m.Blocks)
+}
+
+// ContentBlock is a part of a message, like some text, a tool call ("tool_use")
+// or its result ("tool_result").
+type ContentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+
+	// For tool_use blocks.
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// For tool_result blocks.
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+	IsError   bool   `json:"is_error,omitempty"`
+
+	// CacheControl marks the end of a prefix of the prompt to cache.
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
 }
 
 type Tool struct {
@@ -31,58 +150,106 @@ type ToolChoice struct {
 }
 
 type Request struct {
-	Model      string      `json:"model"`
-	MaxTokens  int         `json:"max_tokens,omitempty"`
-	Messages   []Message   `json:"messages"`
-	Tools      []Tool      `json:"tools"`
-	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+	Model      string         `json:"model"`
+	MaxTokens  int            `json:"max_tokens,omitempty"`
+	System     []ContentBlock `json:"system,omitempty"`
+	Messages   []Message      `json:"messages"`
+	Tools      []Tool         `json:"tools"`
+	ToolChoice *ToolChoice    `json:"tool_choice,omitempty"`
 }
 
 type Response struct {
-	Content []struct {
-		Type  string          `json:"type"`
-		Input json.RawMessage `json:"input"`
-	} `json:"content"`
+	Content []ContentBlock `json:"content"`
+	Usage   Usage          `json:"usage"`
 }
 
+// The types below are what the LLM's tools give us, and their tool schemas are
+// generated from them with SchemaFor, so the description and jsonschema tags
+// are part of the prompt. Fields tagged jsonschema:"-" are filled in by us.
+
 type PolicyClassification struct {
-	IsPolicyChange bool   `json:"is_policy_change"`
-	PolicyType     string `json:"policy_type"`
-	Company        string `json:"company"`
-	Confidence     string `json:"confidence"`
-	PolicyURL      string `json:"policy_url"`
-	Trimmed        bool
+	IsPolicyChange bool   `json:"is_policy_change" jsonschema:"required" description:"True if this is indeed a company notifying about some policy or legal agreement change"`
+	PolicyType     string `json:"policy_type" jsonschema:"required,enum=terms_of_service|privacy_policy|user_agreement|other|" description:"The high-level type of the policy that has been updated"`
+	Company        string `json:"company" jsonschema:"required" description:"The name of the company who's policy has changed"`
+	Confidence     string `json:"confidence" jsonschema:"required,enum=high|medium|low" description:"Level of confidence that this email does indeed indicate that some agreement/policy is changing"`
+	PolicyURL      string `json:"policy_url" jsonschema:"required,pattern=^(https?://\\S+)?$" description:"Valid HTTP(S) URL where the policy can be accessed, leave blank if none is found in the email"`
+	Trimmed        bool   `json:"trimmed,omitempty" jsonschema:"-"`
+	Usage          Usage  `json:"usage,omitzero" jsonschema:"-"`
 }
 
 type PolicyHighlight struct {
-	Description    string `json:"description"`
-	Classification string `json:"classification"`
+	Description    string `json:"description" jsonschema:"required" description:"A description of the highlight, ex 'The service collects many different types of personal data'"`
+	Classification string `json:"classification" jsonschema:"required,enum=good|neutral|bad|blocker" description:"How this policy decision affects users."`
 }
 
 type PolicySummary struct {
-	Highlights []PolicyHighlight `json:"highlights"`
-	Trimmed    bool
+	Highlights []PolicyHighlight `json:"highlights" jsonschema:"required" description:"Individual highlights to show to a user, ex '[neutral] The service collects many different types of personal data'"`
+	Trimmed    bool              `json:"trimmed,omitempty" jsonschema:"-"`
+	// AnalyzedBytes is how much of the TotalBytes of the document was
+	// analyzed, which is less than all of it when the report is Trimmed.
+	AnalyzedBytes int `json:"analyzed_bytes,omitempty" jsonschema:"-"`
+	TotalBytes    int `json:"total_bytes,omitempty" jsonschema:"-"`
+	// Usage is what all of the requests for the report used.
+	Usage Usage `json:"usage,omitzero" jsonschema:"-"`
 }
 
 type DiffHighlight struct {
-	Description    string `json:"description"`
-	Classification string `json:"classification"`
+	Description    string `json:"description" jsonschema:"required" description:"A description of the highlight, ex 'The service is now available via Tor'"`
+	Classification string `json:"classification" jsonschema:"required,enum=good|neutral|bad|blocker" description:"How this change in policy affects users."`
 }
 
 type DiffSummary struct {
-	Highlights []DiffHighlight `json:"highlights"`
-	Trimmed    bool
+	Highlights []DiffHighlight `json:"highlights" jsonschema:"required" description:"Individual changes to show to a user, ex '[good] The service no longer requires registration to use'"`
+	Trimmed    bool            `json:"trimmed,omitempty" jsonschema:"-"`
+	// AnalyzedBytes is how much of the TotalBytes of the changes was analyzed,
+	// which is less than all of them when the report is Trimmed.
+	AnalyzedBytes int `json:"analyzed_bytes,omitempty" jsonschema:"-"`
+	TotalBytes    int `json:"total_bytes,omitempty" jsonschema:"-"`
+	// Usage is what all of the requests for the report used.
+	Usage Usage `json:"usage,omitzero" jsonschema:"-"`
 }
 
-func GenerateSummaryReport(apiKey string, pc *PolicyClassification, textBody string) (*PolicySummary, error) {
-	trimmed := false
-	if len(textBody) > InputByteLimit {
-		log.Printf("Trimming text body for summary, which is %d bytes long", len(textBody))
-		textBody = textBody[:InputByteLimit]
-		trimmed = true
+// Tool schemas, which don't change, so are only generated once.
+var (
+	classificationSchema = SchemaFor[PolicyClassification]()
+	policySummarySchema  = SchemaFor[PolicySummary]()
+	diffSummarySchema    = SchemaFor[DiffSummary]()
+)
+
+// GenerateSummaryReport asks Claude to highlight the parts of a policy that
+// matter to users. Policies too long to analyze at once are analyzed in chunks
+// (see Client.MaxChunks), and the highlights from each chunk consolidated. If
+// a later chunk fails, the report covers the chunks before it, and is marked
+// as trimmed. On error, the summary only has Usage set, so the tokens spent
+// before the failure can still be accounted for.
+func (c *Client) GenerateSummaryReport(pc *PolicyClassification, textBody string) (*PolicySummary, error) {
+	// Budget for a part note in every chunk, since we don't know yet whether
+	// there will be more than one.
+	chunks, trimmed, err := c.fitChunks(textBody, c.MaxChunks, isDocumentBoundary, func(chunk string) *Request {
+		return c.summaryReportRequest(pc, chunk, partNote("document", 1, 2))
+	})
+	if err != nil {
+		return &PolicySummary{}, err
 	}
+	highlights, analyzed, usage, err := analyzeChunks(c, pc, "the text of", chunks, func(i int) *Request {
+		return c.summaryReportRequest(pc, chunks[i], partNote("document", i, len(chunks)))
+	}, func(ps *PolicySummary) []PolicyHighlight { return ps.Highlights })
+	if err != nil {
+		return &PolicySummary{Usage: usage}, err
+	}
+	return &PolicySummary{
+		Highlights:    highlights,
+		Trimmed:       trimmed || analyzed < len(chunks),
+		AnalyzedBytes: totalLen(chunks[:analyzed]),
+		TotalBytes:    len(textBody),
+		Usage:         usage,
+	}, nil
+}
 
-	prompt := fmt.Sprintf(`Analyze the text of the provided company document and highlight the details that are important to an end-user as a series of points, here are some examples from the ToS;DR service describing PayPal's various user agreements:
+// summaryInstructions tell the LLM how to summarize a policy. They're the same
+// for every summary, so they go in the system prompt, where they can be
+// cached (see Client.cacheSystemPrompt).
+const summaryInstructions = `Analyze the text of the provided company document, formatted as Markdown, and highlight the details that are important to an end-user as a series of points, here are some examples from the ToS;DR service describing PayPal's various user agreements:
 
 <examples>
 - "This service allows you to retrieve an archive of your data"
@@ -97,49 +264,29 @@ func GenerateSummaryReport(apiKey string, pc *PolicyClassification, textBody str
 - "This service may use your personal information for marketing purposes"
 - "The service uses social media cookies/pixels"
 - "Blocking first party cookies may limit your ability to use the service"
-</examples>
+</examples>`
 
-<company>%s</company>
+func (c *Client) summaryReportRequest(pc *PolicyClassification, textBody, part string) *Request {
+	prompt := fmt.Sprintf(`<company>%s</company>
 
 <policy_url>%s</policy_url>
 
 <policy_type>%s</policy_type>
 
-<document_to_analyze>
+%s<document_to_analyze>
 %s
 </document_to_analyze>
-`, pc.Company, pc.PolicyURL, pc.PolicyType, textBody)
+`, pc.Company, pc.PolicyURL, pc.PolicyType, part, textBody)
 
 	reqBody := &Request{
-		Model:     "claude-sonnet-4-6",
+		Model:     c.ReportModel,
 		MaxTokens: 10000,
+		System:    systemPrompt(summaryInstructions),
 		Tools: []Tool{
 			{
 				Name:        "extract_highlights",
 				Description: "Analyze the text of a company's user-facing legal documents and extract relevant details that will be important to users",
-				InputSchema: JSONSchema{
-					Type: ObjectType,
-					Properties: map[string]*JSONSchema{
-						"highlights": {
-							Type: ArrayType,
-							Items: &JSONSchema{
-								Type:        ObjectType,
-								Description: "An individual highlight to show to a user, ex '[neutral] The service collects many different types of personal data'",
-								Properties: map[string]*JSONSchema{
-									"description": {
-										Type:        StringType,
-										Description: "A description of the highlight, ex 'The service collects many different types of personal data'",
-									},
-									"classification": {
-										Type:        StringType,
-										Description: "How this policy decision affects users.",
-										Enum:        []any{"good", "neutral", "bad", "blocker"},
-									},
-								},
-							},
-						},
-					},
-				},
+				InputSchema: *policySummarySchema,
 			},
 		},
 		ToolChoice: &ToolChoice{
@@ -154,72 +301,78 @@ func GenerateSummaryReport(apiKey string, pc *PolicyClassification, textBody str
 		},
 	}
 
-	ps, err := issueRequest[PolicySummary](apiKey, reqBody)
+	return reqBody
+}
+
+// GenerateDiffReport asks Claude to explain the changes between two versions
+// of a policy. changes is either a section-by-section change list (see the
+// sectiondiff package) or, for documents without sections, a unified diff.
+// Changes too long to analyze at once are analyzed in chunks of whole
+// sections or hunks (see Client.MaxChunks), and the highlights from each chunk
+// consolidated. If a later chunk fails, the report covers the chunks before
+// it, and is marked as trimmed. Like GenerateSummaryReport, it returns a
+// summary with just the Usage on error.
+func (c *Client) GenerateDiffReport(pc *PolicyClassification, changes string) (*DiffSummary, error) {
+	chunks, trimmed, err := c.fitChunks(changes, c.MaxChunks, isDiffBoundary, func(chunk string) *Request {
+		return c.diffReportRequest(pc, chunk, partNote("changes", 1, 2))
+	})
 	if err != nil {
-		return nil, err
+		return &DiffSummary{}, err
 	}
-	ps.Trimmed = trimmed
-	return ps, nil
+	highlights, analyzed, usage, err := analyzeChunks(c, pc, "the changes to", chunks, func(i int) *Request {
+		return c.diffReportRequest(pc, chunks[i], partNote("changes", i, len(chunks)))
+	}, func(ds *DiffSummary) []DiffHighlight { return ds.Highlights })
+	if err != nil {
+		return &DiffSummary{Usage: usage}, err
+	}
+	return &DiffSummary{
+		Highlights:    highlights,
+		Trimmed:       trimmed || analyzed < len(chunks),
+		AnalyzedBytes: totalLen(chunks[:analyzed]),
+		TotalBytes:    len(changes),
+		Usage:         usage,
+	}, nil
 }
 
-func GenerateDiffReport(apiKey string, pc *PolicyClassification, unifiedDiff string) (*DiffSummary, error) {
-	trimmed := false
-	if len(unifiedDiff) > InputByteLimit {
-		log.Printf("Trimming unified diff, which is %d bytes long", len(unifiedDiff))
-		unifiedDiff = unifiedDiff[:InputByteLimit]
-		trimmed = true
-	}
+// diffInstructions tell the LLM how to explain the changes to a policy.
+// They're the same for every report, so they go in the system prompt.
+const diffInstructions = `Analyze the changes between the previous and current versions of the company document and explain them as a series of points. The changes are given in one of two formats:
 
-	prompt := fmt.Sprintf(`Analyze the unified diff of previous and current versions of the company document and explain the changes as a series of points. Some guidelines:
+- A section-by-section change list, where each entry starts with a line like '=== Section "4.2 Data Sharing": modified' and says whether the section was added, removed, renamed, moved and/or modified. For modified sections, removed text is marked [-like this-] and added text {+like this+}, with "..." standing in for unchanged text.
+- A unified diff, for documents that aren't broken into sections. Besides the usual '-' and '+' lines, lines starting with '<' were moved away from that spot and lines starting with '>' were moved there, with their text unchanged.
+
+Some guidelines:
 
 - Focus on changes that are important to an end-user, e.g. changes to data collection and tracking
 - Don't mention things that aren't changing, where the policy is the functionally the same, even if the wording is different
+- A section that was only moved or renamed, or text that was moved, has the same wording as before; don't describe its contents as new
 - DO NOT mention any diffs that involve links changing from Web Archive to the company's site
 	- That's an artifact of our analysis pipeline and SHOULD NOT be mentioned to the user.
 - Write in a clear and accessible way, avoiding legal jargon
 - If it makes sense to reference a section when talking about a change, reference it at the end
+	- The documents are formatted as Markdown, so section headings start with '#'. Cite sections by their number and title as written, e.g. "(Section 4.2 Data Sharing)"`
 
-<company>%s</company>
+func (c *Client) diffReportRequest(pc *PolicyClassification, changes, part string) *Request {
+	prompt := fmt.Sprintf(`<company>%s</company>
 
 <policy_url>%s</policy_url>
 
 <policy_type>%s</policy_type>
 
-<diff_to_analyze>
+%s<changes_to_analyze>
 %s
-</diff_to_analyze>
-`, pc.Company, pc.PolicyURL, pc.PolicyType, unifiedDiff)
+</changes_to_analyze>
+`, pc.Company, pc.PolicyURL, pc.PolicyType, part, changes)
 
 	reqBody := &Request{
-		Model:     "claude-sonnet-4-6",
+		Model:     c.ReportModel,
 		MaxTokens: 10000,
+		System:    systemPrompt(diffInstructions),
 		Tools: []Tool{
 			{
 				Name:        "extract_highlights",
-				Description: "Analyze the unified diff between two versions of a company's user-facing legal documents and extract highlights that will be important to users",
-				InputSchema: JSONSchema{
-					Type: ObjectType,
-					Properties: map[string]*JSONSchema{
-						"highlights": {
-							Type: ArrayType,
-							Items: &JSONSchema{
-								Type:        ObjectType,
-								Description: "An individual change to show to a user, ex '[good] The service no longer requires registration to use'",
-								Properties: map[string]*JSONSchema{
-									"description": {
-										Type:        StringType,
-										Description: "A description of the highlight, ex 'The service is now available via Tor'",
-									},
-									"classification": {
-										Type:        StringType,
-										Description: "How this change in policy affects users.",
-										Enum:        []any{"good", "neutral", "bad", "blocker"},
-									},
-								},
-							},
-						},
-					},
-				},
+				Description: "Analyze the changes between two versions of a company's user-facing legal documents and extract highlights that will be important to users",
+				InputSchema: *diffSummarySchema,
 			},
 		},
 		ToolChoice: &ToolChoice{
@@ -234,22 +387,16 @@ func GenerateDiffReport(apiKey string, pc *PolicyClassification, unifiedDiff str
 		},
 	}
 
-	ds, err := issueRequest[DiffSummary](apiKey, reqBody)
-	if err != nil {
-		return nil, err
-	}
-	ds.Trimmed = trimmed
-	return ds, nil
+	return reqBody
 }
 
-func ClassifyPolicyChange(apiKey, subject, textBody, htmlBody string) (*PolicyClassification, error) {
-	if apiKey == "" {
-		return nil, fmt.Errorf("ANTHROPIC_API_KEY not provided")
-	}
-
+// ClassifyPolicyChange asks Claude whether an email is a company telling
+// people about a change to one of its policies, and if so, which one. On
+// error, the classification only has Usage set, like the reports.
+func (c *Client) ClassifyPolicyChange(subject, textBody, htmlBody string) (*PolicyClassification, error) {
 	textBody, htmlBody = strings.TrimSpace(textBody), strings.TrimSpace(htmlBody)
 	if textBody == "" && htmlBody == "" {
-		return nil, errors.New("no email content provided")
+		return &PolicyClassification{}, errors.New("no email content provided")
 	}
 
 	var emailContent strings.Builder
@@ -264,14 +411,29 @@ func ClassifyPolicyChange(apiKey, subject, textBody, htmlBody string) (*PolicyCl
 		emailContent.WriteString("</html_body>")
 	}
 
-	trimmed := false
+	// Emails that don't fit are trimmed on a line boundary, the subject and
+	// the start of the email are plenty to classify it.
 	content := emailContent.String()
-	if len(content) > InputByteLimit {
-		log.Printf("Trimming email content, which is %d bytes long", len(content))
-		content = content[:InputByteLimit]
-		trimmed = true
+	chunks, trimmed, err := c.fitChunks(content, 1, noBoundary, func(chunk string) *Request {
+		return c.classifyRequest(subject, chunk)
+	})
+	if err != nil {
+		return &PolicyClassification{}, err
+	}
+	if trimmed {
+		log.Printf("Trimmed email content from %d to %d bytes", len(content), len(chunks[0]))
 	}
 
+	pc, usage, err := issueRequest[PolicyClassification](c, c.classifyRequest(subject, chunks[0]))
+	if err != nil {
+		return &PolicyClassification{Usage: usage}, err
+	}
+	pc.Trimmed = trimmed
+	pc.Usage = usage
+	return pc, nil
+}
+
+func (c *Client) classifyRequest(subject, content string) *Request {
 	prompt := fmt.Sprintf(`Analyze this email to determine if it's a company notifying about policy changes (Terms of Service, Privacy Policy, User Agreement, etc.).
 
 <subject>%s</subject>
@@ -279,42 +441,13 @@ func ClassifyPolicyChange(apiKey, subject, textBody, htmlBody string) (*PolicyCl
 %s`, subject, content)
 
 	reqBody := &Request{
-		Model:     "claude-haiku-4-5-20251001",
+		Model:     c.ClassifyModel,
 		MaxTokens: 600,
 		Tools: []Tool{
 			{
 				Name:        "classify_email",
 				Description: "Analyze the body of a given email to determine if it's a company notifying about a policy or legal agreement change",
-				InputSchema: JSONSchema{
-					Type: ObjectType,
-					Properties: map[string]*JSONSchema{
-						"is_policy_change": {
-							Type:        BooleanType,
-							Description: "True if this is indeed a company notifying about some policy or legal agreement change",
-						},
-						"policy_type": {
-							Type:        StringType,
-							Description: "The high-level type of the policy that has been updated",
-							Enum:        []any{"terms_of_service", "privacy_policy", "user_agreement", "other", ""},
-						},
-						"company": {
-							Type:        StringType,
-							Description: "The name of the company who's policy has changed",
-						},
-						"confidence": {
-							Type:        StringType,
-							Description: "Level of confidence that this email does indeed indicate that some agreement/policy is changing",
-							Enum:        []any{"high", "medium", "low"},
-						},
-						"policy_url": {
-							Type:        StringType,
-							Description: "Valid HTTP(S) URL where the policy can be accessed, leave blank if none is found in the email",
-						},
-					},
-					Required: []string{
-						"is_policy_change", "policy_type", "company", "confidence", "policy_url",
-					},
-				},
+				InputSchema: *classificationSchema,
 			},
 		},
 		ToolChoice: &ToolChoice{
@@ -329,33 +462,146 @@ func ClassifyPolicyChange(apiKey, subject, textBody, htmlBody string) (*PolicyCl
 		},
 	}
 
-	pc, err := issueRequest[PolicyClassification](apiKey, reqBody)
+	return reqBody
+}
+
+// issueRequest sends apiReq, which forces a tool call, and decodes the tool's
+// input into a T. If the input doesn't match the tool's schema, we tell the
+// model what's wrong with it and give it one more try to get it right. It also
+// returns the tokens used by every request it made.
+func issueRequest[T any](c *Client, apiReq *Request) (*T, Usage, error) {
+	tool := apiReq.ToolChoice.Name
+	var schema *JSONSchema
+	for _, t := range apiReq.Tools {
+		if t.Name == tool {
+			schema = &t.InputSchema
+		}
+	}
+	if schema == nil {
+		return nil, Usage{}, fmt.Errorf("request forces tool %q, which it doesn't include", tool)
+	}
+
+	c.cacheSystemPrompt(apiReq)
+	claudeResp, err := c.send(apiReq)
 	if err != nil {
-		return nil, err
+		return nil, Usage{}, err
 	}
-	pc.Trimmed = trimmed
-	return pc, nil
+	usage := claudeResp.Usage
+	result, toolUse, problems := decodeToolInput[T](claudeResp, tool, schema)
+	if len(problems) == 0 {
+		return result, usage, nil
+	}
+	log.Printf("Input to tool %q was invalid, asking for a correction: %s", tool, strings.Join(problems, "; "))
+
+	followUp := *apiReq
+	followUp.Messages = append(slices.Clip(apiReq.Messages), correction(claudeResp, toolUse, tool, problems)...)
+	if claudeResp, err = c.send(&followUp); err != nil {
+		return nil, usage, err
+	}
+	usage.Add(claudeResp.Usage)
+	if result, _, problems = decodeToolInput[T](claudeResp, tool, schema); len(problems) > 0 {
+		return nil, usage, &InvalidOutputError{Tool: tool, Problems: problems}
+	}
+	return result, usage, nil
 }
 
-func issueRequest[T any](apiKey string, apiReq *Request) (*T, error) {
+// decodeToolInput finds the call to tool in resp, and decodes its input into a
+// T, if it matches schema. Otherwise, it returns the problems with it.
+func decodeToolInput[T any](resp *Response, tool string, schema *JSONSchema) (*T, *ContentBlock, []string) {
+	var toolUse *ContentBlock
+	for i, block := range resp.Content {
+		if block.Type == "tool_use" && block.Name == tool {
+			toolUse = &resp.Content[i]
+			break
+		}
+	}
+	if toolUse == nil {
+		return nil, nil, []string{fmt.Sprintf("the response didn't call the %s tool", tool)}
+	}
+
+	var input any
+	if err := json.Unmarshal(toolUse.Input, &input); err != nil {
+		return nil, toolUse, []string{fmt.Sprintf("the input isn't valid JSON: %v", err)}
+	}
+	if problems := schema.Validate(input); len(problems) > 0 {
+		return nil, toolUse, problems
+	}
+	var result T
+	if err := json.Unmarshal(toolUse.Input, &result); err != nil {
+		return nil, toolUse, []string{fmt.Sprintf("the input doesn't match the schema: %v", err)}
+	}
+	return &result, toolUse, nil
+}
+
+// correction returns the messages that continue a conversation where the
+// model's response didn't call tool correctly, telling it what was wrong.
+func correction(resp *Response, toolUse *ContentBlock, tool string, problems []string) []Message {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "The input to the %s tool has these problems:\n\n", tool)
+	for _, p := range problems {
+		fmt.Fprintf(&sb, "- %s\n", p)
+	}
+	fmt.Fprintf(&sb, "\nCall the %s tool again, with all of them fixed.", tool)
+
+	var msgs []Message
+	if len(resp.Content) > 0 {
+		msgs = append(msgs, Message{Role: "assistant", Blocks: resp.Content})
+	}
+	if toolUse == nil {
+		return append(msgs, Message{Role: "user", Content: sb.String()})
+	}
+	// A tool call has to be answered with its result, which in this case is
+	// an error.
+	return append(msgs, Message{Role: "user", Blocks: []ContentBlock{{
+		Type:      "tool_result",
+		ToolUseID: toolUse.ID,
+		Content:   sb.String(),
+		IsError:   true,
+	}}})
+}
+
+// send sends a request to the Messages API, retrying it if it fails in a way
+// that might be temporary.
+func (c *Client) send(apiReq *Request) (*Response, error) {
+	if c.APIKey == "" {
+		return nil, fmt.Errorf("ANTHROPIC_API_KEY not provided")
+	}
+
 	jsonData, err := json.Marshal(apiReq)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling request: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(jsonData))
+	for attempt := 0; ; attempt++ {
+		claudeResp := new(Response)
+		err = c.post("/v1/messages", jsonData, claudeResp)
+		if err == nil {
+			return claudeResp, nil
+		}
+		wait, ok := c.retryWait(err, attempt)
+		if !ok {
+			return nil, err
+		}
+		log.Printf("Anthropic API request failed (attempt %d of %d), retrying in %s: %v", attempt+1, c.MaxRetries+1, wait.Round(time.Millisecond), err)
+		time.Sleep(wait)
+	}
+}
+
+// post makes a single request to an API endpoint, decoding the response into
+// out. Error responses are returned as *APIError.
+func (c *Client) post(path string, body []byte, out any) error {
+	req, err := http.NewRequest("POST", strings.TrimSuffix(c.BaseURL, "/")+path, bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+		return fmt.Errorf("error creating request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("x-api-key", c.APIKey)
 	req.Header.Set("anthropic-version", "2023-06-01")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error making request: %v", err)
+		return fmt.Errorf("error making request: %w", err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -364,22 +610,42 @@ func issueRequest[T any](apiKey string, apiReq *Request) (*T, error) {
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("claude API returned status: %d", resp.StatusCode)
+		respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if err != nil {
+			return fmt.Errorf("claude API returned status %d, and reading the error failed: %w", resp.StatusCode, err)
+		}
+		return newAPIError(resp, respBody)
 	}
 
-	var claudeResp Response
-	if err := json.NewDecoder(resp.Body).Decode(&claudeResp); err != nil {
-		return nil, fmt.Errorf("error decoding Claude response: %v", err)
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding Claude response: %v", err)
 	}
+	return nil
+}
 
-	if len(claudeResp.Content) == 0 {
-		return nil, fmt.Errorf("empty response from Claude")
+// retryWait returns how long to wait before retrying a request that failed
+// with err, or false if it shouldn't be retried.
+func (c *Client) retryWait(err error, attempt int) (time.Duration, bool) {
+	if attempt >= c.MaxRetries || !IsTemporary(err) {
+		return 0, false
 	}
-
-	var result T
-	if err := json.Unmarshal(claudeResp.Content[0].Input, &result); err != nil {
-		return nil, fmt.Errorf("error parsing JSON: %v", err)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && urlErr.Timeout() {
+		// We already waited out the whole client timeout, and doing that a few
+		// more times would hold up the caller for far too long. Let it try again
+		// later instead.
+		return 0, false
 	}
-
-	return &result, nil
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > c.MaxRetryWait {
+			// Not worth holding things up for, let the caller try again later.
+			return 0, false
+		}
+		return apiErr.RetryAfter, true
+	}
+	backoff := min(c.RetryBackoff<<attempt, c.MaxRetryWait)
+	// Spread retries out, so that requests that failed together (e.g. when the
+	// API is overloaded) don't all retry together.
+	return backoff/2 + rand.N(backoff/2+1), true
 }
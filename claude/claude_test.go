@@ -0,0 +1,565 @@
+package claude_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bcspragu/fineprint/claude"
+	"github.com/bcspragu/fineprint/claude/claudetest"
+)
+
+func TestClient_ClassifyPolicyChange(t *testing.T) {
+	srv := claudetest.NewServer()
+	defer srv.Close()
+	srv.Respond("classify_email", &claude.PolicyClassification{
+		IsPolicyChange: true,
+		PolicyType:     "privacy_policy",
+		Company:        "Example",
+		Confidence:     "high",
+		PolicyURL:      "https://example.com/privacy",
+	})
+
+	c := srv.Client()
+	c.ClassifyModel = "test-classify-model"
+	pc, err := c.ClassifyPolicyChange("We're updating our Privacy Policy", "Read it at https://example.com/privacy", "")
+	if err != nil {
+		t.Fatalf("ClassifyPolicyChange: %v", err)
+	}
+	if !pc.IsPolicyChange || pc.Company != "Example" || pc.PolicyURL != "https://example.com/privacy" || pc.Trimmed {
+		t.Errorf("ClassifyPolicyChange = %+v, want the canned classification", pc)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(reqs))
+	}
+	if reqs[0].Model != "test-classify-model" {
+		t.Errorf("request used model %q, want test-classify-model", reqs[0].Model)
+	}
+	if !strings.Contains(reqs[0].Messages[0].Content, "We're updating our Privacy Policy") {
+		t.Errorf("prompt doesn't include the email subject: %q", reqs[0].Messages[0].Content)
+	}
+}
+
+func TestClient_Reports(t *testing.T) {
+	srv := claudetest.NewServer()
+	defer srv.Close()
+	srv.RespondFunc("extract_highlights", func(req *claude.Request) any {
+		desc := "The service now sells your data"
+		if strings.Contains(req.Messages[0].Content, "<document_to_analyze>") {
+			desc = "The service collects your data"
+		}
+		return map[string]any{
+			"highlights": []map[string]string{{"description": desc, "classification": "bad"}},
+		}
+	})
+
+	c := srv.Client()
+	pc := &claude.PolicyClassification{Company: "Example", PolicyType: "privacy_policy"}
+
+	ds, err := c.GenerateDiffReport(pc, "-We don't sell your data\n+We sell your data")
+	if err != nil {
+		t.Fatalf("GenerateDiffReport: %v", err)
+	}
+	if len(ds.Highlights) != 1 || ds.Highlights[0].Description != "The service now sells your data" {
+		t.Errorf("GenerateDiffReport = %+v, want the canned diff highlight", ds)
+	}
+
+	ps, err := c.GenerateSummaryReport(pc, "# Data\nWe collect your data.\n")
+	if err != nil {
+		t.Fatalf("GenerateSummaryReport: %v", err)
+	}
+	if len(ps.Highlights) != 1 || ps.Highlights[0].Description != "The service collects your data" || ps.Trimmed {
+		t.Errorf("GenerateSummaryReport = %+v, want the canned summary highlight", ps)
+	}
+}
+
+func TestClient_ChunkedReports(t *testing.T) {
+	// Three sections, each of which only fits in a request on its own. The
+	// fake API counts three bytes of x's as a token, so each section is 10000
+	// tokens, and the rest of the prompt is well under that.
+	const maxInputTokens = 20000
+	section := strings.Repeat("x", 30000)
+	doc := "# One\n" + section + "\n# Two\n" + section + "\n# Three\n" + section + "\n"
+
+	tests := []struct {
+		name         string
+		maxChunks    int
+		wantRequests int
+		wantTrimmed  bool
+		wantAnalyzed int
+	}{
+		{name: "map and reduce", maxChunks: 8, wantRequests: 4, wantAnalyzed: len(doc)},
+		{name: "cost ceiling", maxChunks: 2, wantRequests: 3, wantTrimmed: true, wantAnalyzed: 2 * (len(section) + 7)},
+		{name: "single chunk", maxChunks: 1, wantRequests: 1, wantTrimmed: true, wantAnalyzed: len(section) + 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := claudetest.NewServer()
+			defer srv.Close()
+			srv.RespondFunc("extract_highlights", func(req *claude.Request) any {
+				if n := claude.EstimateTokens(req); n > maxInputTokens {
+					t.Errorf("request is %d tokens, want at most %d", n, maxInputTokens)
+				}
+				if tt.maxChunks > 1 && !strings.Contains(req.Messages[0].Content, "this is part") {
+					t.Errorf("chunk prompt doesn't say which part it is")
+				}
+				return map[string]any{
+					"highlights": []map[string]string{{"description": "The service collects your data", "classification": "neutral"}},
+				}
+			})
+			srv.RespondFunc("consolidate_highlights", func(req *claude.Request) any {
+				if n := strings.Count(req.Messages[0].Content, "The service collects your data"); n < 2 {
+					t.Errorf("consolidation prompt has %d highlights, want one per chunk", n)
+				}
+				return map[string]any{
+					"highlights": []map[string]string{{"description": "The service collects your data (combined)", "classification": "neutral"}},
+				}
+			})
+
+			c := srv.Client()
+			c.MaxInputTokens = maxInputTokens
+			c.MaxChunks = tt.maxChunks
+			ps, err := c.GenerateSummaryReport(&claude.PolicyClassification{Company: "Example"}, doc)
+			if err != nil {
+				t.Fatalf("GenerateSummaryReport: %v", err)
+			}
+			if ps.Trimmed != tt.wantTrimmed {
+				t.Errorf("Trimmed = %t, want %t", ps.Trimmed, tt.wantTrimmed)
+			}
+			if ps.AnalyzedBytes != tt.wantAnalyzed || ps.TotalBytes != len(doc) {
+				t.Errorf("analyzed %d of %d bytes, want %d of %d", ps.AnalyzedBytes, ps.TotalBytes, tt.wantAnalyzed, len(doc))
+			}
+			if len(ps.Highlights) != 1 {
+				t.Errorf("got %d highlights, want 1", len(ps.Highlights))
+			}
+			if n := len(srv.Requests()); n != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", n, tt.wantRequests)
+			}
+			if srv.TokenCounts() == 0 {
+				t.Error("didn't count tokens for a document over the estimated budget")
+			}
+		})
+	}
+}
+
+func TestClient_ChunkedReportFailures(t *testing.T) {
+	// The same three chunks as TestClient_ChunkedReports.
+	section := strings.Repeat("x", 30000)
+	doc := "# One\n" + section + "\n# Two\n" + section + "\n# Three\n" + section + "\n"
+	partRE := regexp.MustCompile(`this is part (\d)`)
+
+	tests := []struct {
+		name         string
+		failPart     string
+		consolidate  bool
+		want         []string
+		wantTrimmed  bool
+		wantAnalyzed int
+	}{
+		{
+			name:         "later chunk fails",
+			failPart:     "2",
+			consolidate:  true,
+			want:         []string{"Part 1"},
+			wantTrimmed:  true,
+			wantAnalyzed: len(section) + 7,
+		},
+		{
+			name:        "first chunk fails",
+			failPart:    "1",
+			consolidate: true,
+		},
+		{
+			name:         "consolidation fails",
+			want:         []string{"Part 1", "Part 2", "Part 3"},
+			wantAnalyzed: len(doc),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := claudetest.NewServer()
+			defer srv.Close()
+			srv.RespondFunc("extract_highlights", func(req *claude.Request) any {
+				part := partRE.FindStringSubmatch(req.Messages[0].Content)[1]
+				if part == tt.failPart {
+					return map[string]any{"highlights": "none"}
+				}
+				return map[string]any{
+					"highlights": []map[string]string{{"description": "Part " + part, "classification": "neutral"}},
+				}
+			})
+			if tt.consolidate {
+				srv.Respond("consolidate_highlights", map[string]any{
+					"highlights": []map[string]string{{"description": "Combined", "classification": "neutral"}},
+				})
+			}
+
+			c := srv.Client()
+			c.MaxInputTokens = 20000
+			ps, err := c.GenerateSummaryReport(&claude.PolicyClassification{Company: "Example"}, doc)
+			if tt.want == nil {
+				if !errors.Is(err, claude.ErrInvalidOutput) {
+					t.Errorf("GenerateSummaryReport error = %v, want %v", err, claude.ErrInvalidOutput)
+				}
+				// The failed request and its correction still cost something.
+				if ps == nil || ps.Usage.OutputTokens == 0 {
+					t.Errorf("GenerateSummaryReport = %+v, want the usage of the failed requests", ps)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSummaryReport: %v", err)
+			}
+			var got []string
+			for _, h := range ps.Highlights {
+				got = append(got, h.Description)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("highlights = %q, want %q", got, tt.want)
+			}
+			if ps.Trimmed != tt.wantTrimmed || ps.AnalyzedBytes != tt.wantAnalyzed {
+				t.Errorf("Trimmed = %t with %d bytes analyzed, want %t with %d", ps.Trimmed, ps.AnalyzedBytes, tt.wantTrimmed, tt.wantAnalyzed)
+			}
+		})
+	}
+}
+
+func TestClient_ClassifyTrimsLongEmails(t *testing.T) {
+	srv := claudetest.NewServer()
+	defer srv.Close()
+	srv.Respond("classify_email", &claude.PolicyClassification{IsPolicyChange: true, Confidence: "high"})
+
+	c := srv.Client()
+	c.MaxInputTokens = 3000
+	body := strings.Repeat("We've updated our Terms of Service.\n", 1000)
+	pc, err := c.ClassifyPolicyChange("Updates to our Terms", body, "")
+	if err != nil {
+		t.Fatalf("ClassifyPolicyChange: %v", err)
+	}
+	if !pc.Trimmed {
+		t.Error("Trimmed = false for an email over the budget, want true")
+	}
+
+	req := srv.Requests()[0]
+	if n := claude.EstimateTokens(req); n > c.MaxInputTokens {
+		t.Errorf("request is %d tokens, want at most %d", n, c.MaxInputTokens)
+	}
+	prompt := req.Messages[0].Content
+	if !strings.Contains(prompt, "Updates to our Terms") {
+		t.Error("trimmed prompt lost the subject")
+	}
+	if !strings.HasSuffix(prompt, "Terms of Service.\n") {
+		t.Errorf("prompt wasn't trimmed on a line boundary, it ends with %q", prompt[len(prompt)-20:])
+	}
+}
+
+func TestClient_PromptOverBudget(t *testing.T) {
+	srv := claudetest.NewServer()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.MaxInputTokens = 10
+	if _, err := c.ClassifyPolicyChange("Updates to our Terms", "We've updated our Terms of Service.", ""); !errors.Is(err, claude.ErrContextTooLong) {
+		t.Errorf("ClassifyPolicyChange = %v, want %v", err, claude.ErrContextTooLong)
+	}
+	if n := len(srv.Requests()); n != 0 {
+		t.Errorf("sent %d requests, want none", n)
+	}
+}
+
+func TestClient_CountTokens(t *testing.T) {
+	req := &claude.Request{
+		Model:    "test-model",
+		Messages: []claude.Message{{Role: "user", Content: strings.Repeat("word ", 1000)}},
+	}
+
+	srv := claudetest.NewServer()
+	defer srv.Close()
+	if got, want := srv.Client().CountTokens(req), claude.EstimateTokens(req); got != want {
+		t.Errorf("CountTokens = %d, want %d from the fake API", got, want)
+	}
+	if n := srv.TokenCounts(); n != 1 {
+		t.Errorf("got %d token counting requests, want 1", n)
+	}
+
+	// If the API can't count them, we estimate them ourselves.
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	c := claude.NewClient("test-api-key")
+	c.BaseURL = broken.URL
+	if got, want := c.CountTokens(req), claude.EstimateTokens(req); got != want {
+		t.Errorf("CountTokens = %d, want the estimate %d", got, want)
+	}
+}
+
+func TestClient_Usage(t *testing.T) {
+	srv := claudetest.NewServer()
+	defer srv.Close()
+	srv.Respond("classify_email", &claude.PolicyClassification{IsPolicyChange: true, Confidence: "high"})
+	srv.Respond("extract_highlights", map[string]any{
+		"highlights": []map[string]string{{"description": "The service sells your data", "classification": "bad"}},
+	})
+
+	c := srv.Client()
+	pc, err := c.ClassifyPolicyChange("Subject", "Body", "")
+	if err != nil {
+		t.Fatalf("ClassifyPolicyChange: %v", err)
+	}
+	if pc.Usage.InputTokens == 0 || pc.Usage.OutputTokens == 0 {
+		t.Errorf("classification usage = %+v, want some tokens", pc.Usage)
+	}
+
+	// The instructions are too short for the report model to cache, so they
+	// aren't marked for caching, and nothing is written to the cache.
+	ds, err := c.GenerateDiffReport(pc, "-We don't sell your data\n+We sell your data")
+	if err != nil {
+		t.Fatalf("GenerateDiffReport: %v", err)
+	}
+	if u := ds.Usage; u.InputTokens == 0 || u.OutputTokens == 0 || u.CacheCreationInputTokens != 0 || u.CacheReadInputTokens != 0 {
+		t.Errorf("report usage = %+v, want some tokens and no caching", u)
+	}
+
+	req := srv.Requests()[1]
+	if len(req.System) != 1 || req.System[0].CacheControl != nil || !strings.Contains(req.System[0].Text, "Some guidelines") {
+		t.Errorf("report request system prompt = %+v, want the instructions, not marked for caching", req.System)
+	}
+	if strings.Contains(req.Messages[0].Content, "Some guidelines") {
+		t.Error("report prompt repeats the instructions outside the system prompt")
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	srv := claudetest.NewServer()
+	defer srv.Close()
+
+	// Nothing registered for the tool, so the fake API returns an error.
+	if _, err := srv.Client().ClassifyPolicyChange("Subject", "Body", ""); err == nil {
+		t.Error("ClassifyPolicyChange succeeded without a canned response, want an error")
+	}
+
+	srv.Respond("classify_email", &claude.PolicyClassification{})
+	c := srv.Client()
+	c.APIKey = ""
+	if _, err := c.ClassifyPolicyChange("Subject", "Body", ""); err == nil {
+		t.Error("ClassifyPolicyChange succeeded without an API key, want an error")
+	}
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("got %d requests, want 1, requests without an API key shouldn't be sent", n)
+	}
+}
+
+func TestClient_Retries(t *testing.T) {
+	overloaded := claudetest.Error{Status: 529, Type: "overloaded_error", Message: "Overloaded"}
+	tests := []struct {
+		name         string
+		failures     int
+		failure      claudetest.Error
+		wantErr      error
+		wantTemp     bool
+		wantRequests int
+	}{
+		{
+			name:         "recovers after overloads",
+			failures:     2,
+			failure:      overloaded,
+			wantRequests: 3,
+		},
+		{
+			name:         "gives up after max retries",
+			failures:     10,
+			failure:      claudetest.Error{Status: 429, Type: "rate_limit_error", Message: "Slow down"},
+			wantErr:      claude.ErrRateLimited,
+			wantTemp:     true,
+			wantRequests: 4,
+		},
+		{
+			name:         "doesn't wait longer than the max",
+			failures:     1,
+			failure:      claudetest.Error{Status: 529, Type: "overloaded_error", Message: "Overloaded", RetryAfter: time.Hour},
+			wantErr:      claude.ErrOverloaded,
+			wantTemp:     true,
+			wantRequests: 1,
+		},
+		{
+			name:         "invalid requests aren't retried",
+			failures:     1,
+			failure:      claudetest.Error{Status: 400, Type: "invalid_request_error", Message: "max_tokens: Field required"},
+			wantErr:      claude.ErrInvalidRequest,
+			wantRequests: 1,
+		},
+		{
+			name:         "prompt too long",
+			failures:     1,
+			failure:      claudetest.Error{Status: 400, Type: "invalid_request_error", Message: "prompt is too long: 215000 tokens > 200000 maximum"},
+			wantErr:      claude.ErrContextTooLong,
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := claudetest.NewServer()
+			defer srv.Close()
+			srv.Respond("classify_email", &claude.PolicyClassification{IsPolicyChange: true, Confidence: "high"})
+			srv.FailNext(tt.failures, tt.failure)
+
+			c := srv.Client()
+			c.RetryBackoff = time.Millisecond
+			pc, err := c.ClassifyPolicyChange("Subject", "Body", "")
+			if tt.wantErr == nil {
+				if err != nil || !pc.IsPolicyChange {
+					t.Errorf("ClassifyPolicyChange = %+v, %v, want the canned classification", pc, err)
+				}
+			} else {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ClassifyPolicyChange error = %v, want %v", err, tt.wantErr)
+				}
+				if got := claude.IsTemporary(err); got != tt.wantTemp {
+					t.Errorf("IsTemporary(%v) = %t, want %t", err, got, tt.wantTemp)
+				}
+			}
+			if n := len(srv.Requests()); n != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestClient_DoesntRetryTimeouts(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	c := claude.NewClient("key")
+	c.BaseURL = srv.URL
+	c.HTTPClient = &http.Client{Timeout: 10 * time.Millisecond}
+	c.RetryBackoff = time.Millisecond
+	_, err := c.ClassifyPolicyChange("Subject", "Body", "")
+	if !claude.IsTemporary(err) {
+		t.Errorf("ClassifyPolicyChange error = %v, want a temporary error", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestClient_CorrectsInvalidOutput(t *testing.T) {
+	valid := map[string]any{"highlights": []map[string]string{{"description": "The service sells your data", "classification": "bad"}}}
+	invalid := map[string]any{"highlights": []map[string]string{{"description": "The service sells your data", "classification": "terrible"}}}
+
+	tests := []struct {
+		name      string
+		responses []any
+		wantErr   error
+	}{
+		{name: "valid", responses: []any{valid}},
+		{name: "corrected", responses: []any{invalid, valid}},
+		{name: "still invalid", responses: []any{invalid, invalid}, wantErr: claude.ErrInvalidOutput},
+		{name: "missing highlights", responses: []any{map[string]any{}, valid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := claudetest.NewServer()
+			defer srv.Close()
+			srv.RespondFunc("extract_highlights", func(req *claude.Request) any {
+				// Each correction adds two messages to the conversation.
+				return tt.responses[(len(req.Messages)-1)/2]
+			})
+
+			ds, err := srv.Client().GenerateDiffReport(&claude.PolicyClassification{Company: "Example"}, "-We don't sell your data\n+We sell your data")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GenerateDiffReport error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (len(ds.Highlights) != 1 || ds.Highlights[0].Classification != "bad") {
+				t.Errorf("GenerateDiffReport = %+v, want the valid highlight", ds)
+			}
+
+			reqs := srv.Requests()
+			if len(reqs) != len(tt.responses) {
+				t.Fatalf("got %d requests, want %d", len(reqs), len(tt.responses))
+			}
+			if len(reqs) == 1 {
+				return
+			}
+			msgs := reqs[1].Messages
+			if len(msgs) != 3 || msgs[0].Content != reqs[0].Messages[0].Content {
+				t.Fatalf("correction has messages %+v, want the original prompt, the response and the tool result", msgs)
+			}
+			if b := msgs[1].Blocks; msgs[1].Role != "assistant" || len(b) != 1 || b[0].Type != "tool_use" {
+				t.Errorf("second message is %+v, want the original tool call", msgs[1])
+			}
+			result := msgs[2].Blocks
+			if len(result) != 1 || result[0].Type != "tool_result" || !result[0].IsError || result[0].ToolUseID != msgs[1].Blocks[0].ID {
+				t.Fatalf("third message is %+v, want an error result for the tool call", msgs[2])
+			}
+			if !strings.Contains(result[0].Content, "- input") {
+				t.Errorf("tool result %q doesn't say what was wrong", result[0].Content)
+			}
+		})
+	}
+}
+
+func TestClient_FindsToolUseBlock(t *testing.T) {
+	// The tool call isn't always the first block in the response.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"content": [
+			{"type": "text", "text": "Let me classify this email."},
+			{"type": "tool_use", "id": "toolu_1", "name": "classify_email", "input": {
+				"is_policy_change": true,
+				"policy_type": "terms_of_service",
+				"company": "Example",
+				"confidence": "medium",
+				"policy_url": "https://example.com/terms"
+			}}
+		]}`))
+	}))
+	defer srv.Close()
+
+	c := claude.NewClient("test-api-key")
+	c.BaseURL = srv.URL
+	pc, err := c.ClassifyPolicyChange("Subject", "Body", "")
+	if err != nil {
+		t.Fatalf("ClassifyPolicyChange: %v", err)
+	}
+	if !pc.IsPolicyChange || pc.Company != "Example" || pc.Confidence != "medium" {
+		t.Errorf("ClassifyPolicyChange = %+v, want the classification from the tool_use block", pc)
+	}
+}
+
+func TestClient_UnexpectedErrorBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("request-id", "req_123")
+		w.Header().Set("x-should-retry", "false")
+		http.Error(w, "<html>Bad Gateway</html>", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := claude.NewClient("test-api-key")
+	c.BaseURL = srv.URL
+	_, err := c.ClassifyPolicyChange("Subject", "Body", "")
+
+	var apiErr *claude.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("ClassifyPolicyChange error = %v, want an *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusBadGateway || apiErr.Type != "api_error" || apiErr.RequestID != "req_123" || !strings.Contains(apiErr.Message, "Bad Gateway") {
+		t.Errorf("APIError = %+v, want the status, request ID and body", apiErr)
+	}
+	// The API said not to retry, even though 5xx errors normally would be.
+	if claude.IsTemporary(err) {
+		t.Errorf("IsTemporary(%v) = true, want false", err)
+	}
+}
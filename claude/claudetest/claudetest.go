@@ -0,0 +1,254 @@
+// Package claudetest provides a fake Anthropic Messages API, for testing code
+// that uses the claude package without a network connection or API key.
+package claudetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bcspragu/fineprint/claude"
+)
+
+// A ToolFunc returns the input the fake model gives to a tool in response to
+// req, which is marshaled to JSON for the tool_use block.
+type ToolFunc func(req *claude.Request) any
+
+// Server is a fake Messages API. Every request has to force a tool (like all
+// of our requests do), and gets back a single tool_use block for that tool,
+// with whatever input was registered for it. Requests for tools without a
+// response get a 400, like a real API error.
+//
+// The token counting endpoint is served too, with claude.EstimateTokens
+// standing in for the real tokenizer, which also decides the input tokens
+// each response reports using. Prompt caching works like the real API's, so
+// the first request with a cached system prompt reports writing it to the
+// cache, and later ones report reading it, as long as the prefix is at least
+// as long as the model's minimum.
+type Server struct {
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	responses   map[string]ToolFunc
+	failures    []Error
+	requests    []*claude.Request
+	tokenCounts int
+	cached      map[string]bool
+}
+
+// An Error is an error response from the fake API. See Server.FailNext.
+type Error struct {
+	// Status is the HTTP status code, e.g. 529 for an overloaded API.
+	Status int
+	// Type and Message go in the error body, e.g. "overloaded_error" and
+	// "Overloaded".
+	Type    string
+	Message string
+	// RetryAfter, if set, is sent in the retry-after header.
+	RetryAfter time.Duration
+}
+
+// NewServer starts a fake API server, which should be closed when the test is
+// done.
+func NewServer() *Server {
+	s := &Server{responses: make(map[string]ToolFunc), cached: make(map[string]bool)}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL is the base URL of the fake API.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Client returns a claude.Client that talks to the fake API.
+func (s *Server) Client() *claude.Client {
+	c := claude.NewClient("test-api-key")
+	c.BaseURL = s.srv.URL
+	c.HTTPClient = s.srv.Client()
+	return c
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Respond makes the fake model always call tool with input.
+func (s *Server) Respond(tool string, input any) {
+	s.RespondFunc(tool, func(*claude.Request) any { return input })
+}
+
+// RespondFunc makes the fake model call tool with whatever fn returns.
+func (s *Server) RespondFunc(tool string, fn ToolFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[tool] = fn
+}
+
+// FailNext makes the next n requests fail with e, before going back to the
+// registered responses.
+func (s *Server) FailNext(n int, e Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for range n {
+		s.failures = append(s.failures, e)
+	}
+}
+
+// Requests returns the requests the fake API has received, oldest first.
+func (s *Server) Requests() []*claude.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*claude.Request(nil), s.requests...)
+}
+
+// TokenCounts returns how many token counting requests the fake API has
+// received. They aren't included in Requests.
+func (s *Server) TokenCounts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenCounts
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || (r.URL.Path != "/v1/messages" && r.URL.Path != "/v1/messages/count_tokens") {
+		apiError(w, http.StatusNotFound, "not_found_error", fmt.Sprintf("%s %s not found", r.Method, r.URL.Path))
+		return
+	}
+	if r.Header.Get("x-api-key") == "" {
+		apiError(w, http.StatusUnauthorized, "authentication_error", "x-api-key header is required")
+		return
+	}
+	if r.Header.Get("anthropic-version") == "" {
+		apiError(w, http.StatusBadRequest, "invalid_request_error", "anthropic-version header is required")
+		return
+	}
+
+	var req claude.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if r.URL.Path == "/v1/messages/count_tokens" {
+		s.mu.Lock()
+		s.tokenCounts++
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]int{"input_tokens": claude.EstimateTokens(&req)})
+		return
+	}
+	if req.ToolChoice == nil || req.ToolChoice.Type != "tool" {
+		apiError(w, http.StatusBadRequest, "invalid_request_error", "claudetest only supports requests that force a tool")
+		return
+	}
+	tool := req.ToolChoice.Name
+
+	s.mu.Lock()
+	s.requests = append(s.requests, &req)
+	fn, ok := s.responses[tool]
+	n := len(s.requests)
+	var failure *Error
+	if len(s.failures) > 0 {
+		failure = &s.failures[0]
+		s.failures = s.failures[1:]
+	}
+	s.mu.Unlock()
+	if failure != nil {
+		if failure.RetryAfter > 0 {
+			w.Header().Set("retry-after", strconv.Itoa(int(failure.RetryAfter.Seconds())))
+		}
+		apiError(w, failure.Status, failure.Type, failure.Message)
+		return
+	}
+	if !ok {
+		apiError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("no response registered for tool %q", tool))
+		return
+	}
+
+	input, err := json.Marshal(fn(&req))
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "api_error", fmt.Sprintf("failed to marshal tool input: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":          fmt.Sprintf("msg_test_%d", n),
+		"type":        "message",
+		"role":        "assistant",
+		"model":       req.Model,
+		"stop_reason": "tool_use",
+		"content": []map[string]any{{
+			"type":  "tool_use",
+			"id":    fmt.Sprintf("toolu_test_%d", n),
+			"name":  tool,
+			"input": json.RawMessage(input),
+		}},
+		"usage": s.usage(&req, len(input)),
+	})
+}
+
+// usage returns the tokens the fake API says req used, to respond with
+// outputBytes of tool input.
+func (s *Server) usage(req *claude.Request, outputBytes int) claude.Usage {
+	u := claude.Usage{
+		InputTokens:  claude.EstimateTokens(req),
+		OutputTokens: outputBytes/3 + 1,
+	}
+
+	// The cached prefix is the tools and system prompt, up to the last block
+	// marked for caching.
+	prefix := &claude.Request{Model: req.Model, Tools: req.Tools}
+	for i, block := range req.System {
+		if block.CacheControl != nil {
+			prefix.System = req.System[:i+1]
+		}
+	}
+	if prefix.System == nil {
+		return u
+	}
+	prefixTokens := claude.EstimateTokens(prefix)
+	if prefixTokens < minCacheTokens(req.Model) {
+		return u
+	}
+	key, _ := json.Marshal(prefix)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached[string(key)] {
+		u.CacheReadInputTokens = prefixTokens
+	} else {
+		u.CacheCreationInputTokens = prefixTokens
+		s.cached[string(key)] = true
+	}
+	u.InputTokens = max(u.InputTokens-prefixTokens, 0)
+	return u
+}
+
+// minCacheTokens returns the shortest prompt prefix, in tokens, that the real
+// API caches for model.
+func minCacheTokens(model string) int {
+	if strings.HasPrefix(model, "claude-haiku-4-5") {
+		return 4096
+	}
+	return 1024
+}
+
+// apiError writes an error in the format the real API uses.
+func apiError(w http.ResponseWriter, status int, typ, msg string) {
+	writeJSON(w, status, map[string]any{
+		"type": "error",
+		"error": map[string]string{
+			"type":    typ,
+			"message": msg,
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,163 @@
+package claude
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of API errors, for use with errors.Is. See APIError.
+var (
+	// ErrRateLimited means we've gone over our rate limits, and should slow down.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrOverloaded means the API is overloaded, and the request should be tried
+	// again later.
+	ErrOverloaded = errors.New("overloaded")
+	// ErrInvalidRequest means the request was malformed, and retrying it won't
+	// help.
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrContextTooLong means the prompt was too long for the model. It's a
+	// kind of invalid request, so these errors match ErrInvalidRequest too.
+	ErrContextTooLong = errors.New("prompt too long")
+	// ErrInvalidOutput means the model didn't call the tool we asked it to
+	// with valid input, even after being told what was wrong. See
+	// InvalidOutputError.
+	ErrInvalidOutput = errors.New("invalid tool input")
+)
+
+// InvalidOutputError means the model didn't call Tool with input matching its
+// schema, even when given a second chance.
+type InvalidOutputError struct {
+	Tool     string
+	Problems []string
+}
+
+func (e *InvalidOutputError) Error() string {
+	return fmt.Sprintf("invalid input to tool %q: %s", e.Tool, strings.Join(e.Problems, "; "))
+}
+
+func (e *InvalidOutputError) Is(target error) bool {
+	return target == ErrInvalidOutput
+}
+
+// APIError is an error response from the Anthropic API.
+type APIError struct {
+	StatusCode int
+	// Type is the error type from the response, e.g. "overloaded_error".
+	Type    string
+	Message string
+	// RequestID identifies the request, for asking Anthropic about it.
+	RequestID string
+	// RetryAfter is how long the API asked us to wait before retrying, or zero
+	// if it didn't say.
+	RetryAfter time.Duration
+
+	// shouldRetry is the API's own opinion on retrying, from the x-should-retry
+	// header, if it gave one.
+	shouldRetry string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("claude API returned status %d (%s): %s", e.StatusCode, e.Type, e.Message)
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request ID %s)", e.RequestID)
+	}
+	return msg
+}
+
+// Is reports whether the error is one of the kinds of errors above.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.Type == "rate_limit_error"
+	case ErrOverloaded:
+		return e.Type == "overloaded_error"
+	case ErrInvalidRequest:
+		return e.Type == "invalid_request_error" || e.Type == "request_too_large"
+	case ErrContextTooLong:
+		return e.Type == "request_too_large" ||
+			(e.Type == "invalid_request_error" && strings.Contains(strings.ToLower(e.Message), "prompt is too long"))
+	}
+	return false
+}
+
+// Temporary reports whether the request might succeed if it's retried later.
+func (e *APIError) Temporary() bool {
+	switch e.shouldRetry {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	switch {
+	case e.StatusCode == http.StatusRequestTimeout, e.StatusCode == http.StatusConflict, e.StatusCode == http.StatusTooManyRequests:
+		return true
+	case e.StatusCode >= 500:
+		// Including 529, which means the API is overloaded.
+		return true
+	}
+	return false
+}
+
+// IsTemporary reports whether err, from one of the Client's methods, is likely
+// to go away if the request is retried later, like the API being overloaded
+// or unreachable. The Client already retries these a few times (except for
+// timeouts, which took long enough already), so callers that see one should
+// put the work aside for a while, rather than retrying immediately.
+func IsTemporary(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	// The request didn't make it to the API, or we didn't get a response.
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// newAPIError reads an error response, which the API normally sends as JSON
+// like {"type": "error", "error": {"type": "...", "message": "..."}}.
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode:  resp.StatusCode,
+		RequestID:   resp.Header.Get("request-id"),
+		shouldRetry: resp.Header.Get("x-should-retry"),
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("retry-after")); err == nil && secs > 0 {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+
+	var envelope struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error.Type != "" {
+		apiErr.Type, apiErr.Message = envelope.Error.Type, envelope.Error.Message
+		return apiErr
+	}
+
+	// Not the usual format, e.g. an error page from a proxy along the way.
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErr.Type = "rate_limit_error"
+	case resp.StatusCode == 529:
+		apiErr.Type = "overloaded_error"
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		apiErr.Type = "request_too_large"
+	case resp.StatusCode == http.StatusBadRequest:
+		apiErr.Type = "invalid_request_error"
+	default:
+		apiErr.Type = "api_error"
+	}
+	apiErr.Message = strings.TrimSpace(string(body))
+	if len(apiErr.Message) > 200 {
+		apiErr.Message = apiErr.Message[:200] + "..."
+	}
+	return apiErr
+}
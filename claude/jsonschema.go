@@ -1,7 +1,16 @@
 package claude
 
 import (
+	"encoding/json"
 	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 // JSONSchemaType represents the possible types in a JSON Schema.
@@ -76,6 +85,241 @@ type JSONSchema struct {
 	Examples []any `json:"examples,omitempty"`
 }
 
+// SchemaFor returns the schema of the JSON that T decodes from, for tools
+// whose input we decode into a T. Properties are named by their json tags,
+// described by their description tags, and constrained by their jsonschema
+// tags, a comma-separated list of:
+//
+//   - required, for properties that must be present
+//   - enum=a|b|c, for strings that must be one of the given values
+//   - minimum=n and maximum=n, for numbers
+//   - minLength=n and maxLength=n, for strings
+//   - pattern=re, for strings, which can't contain commas
+//   - format=f, for strings
+//
+// A jsonschema tag of "-" leaves the field out of the schema, for fields that
+// we fill in ourselves rather than the LLM.
+//
+// SchemaFor panics if T has fields JSON Schema can't describe, like maps or
+// channels, or malformed tags. Both are bugs, so it's best to call it from
+// somewhere that'll fail fast, like a test.
+func SchemaFor[T any]() *JSONSchema {
+	return schemaFor(reflect.TypeFor[T]())
+}
+
+func schemaFor(t reflect.Type) *JSONSchema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem())
+	case reflect.Bool:
+		return &JSONSchema{Type: BooleanType}
+	case reflect.String:
+		return &JSONSchema{Type: StringType}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: IntegerType}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: NumberType}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: ArrayType, Items: schemaFor(t.Elem())}
+	case reflect.Struct:
+		s := &JSONSchema{Type: ObjectType, Properties: make(map[string]*JSONSchema)}
+		addFields(s, t)
+		return s
+	default:
+		panic(fmt.Sprintf("claude: can't generate a JSON schema for %s", t))
+	}
+}
+
+// addFields adds the fields of struct type t to the properties of s,
+// including the fields of embedded structs, like encoding/json does.
+func addFields(s *JSONSchema, t reflect.Type) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || f.Tag.Get("jsonschema") == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && indirect(f.Type).Kind() == reflect.Struct {
+			addFields(s, indirect(f.Type))
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := schemaFor(f.Type)
+		prop.Description = f.Tag.Get("description")
+		if required := applyTag(prop, f); required {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+	}
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+// applyTag applies the constraints in field f's jsonschema tag to its schema,
+// reporting whether the field is required.
+func applyTag(s *JSONSchema, f reflect.StructField) (required bool) {
+	tag := f.Tag.Get("jsonschema")
+	if tag == "" {
+		return false
+	}
+	for opt := range strings.SplitSeq(tag, ",") {
+		key, val, _ := strings.Cut(opt, "=")
+		var err error
+		switch key {
+		case "required":
+			required = true
+		case "enum":
+			if s.Type != StringType {
+				err = fmt.Errorf("enum is only supported for strings")
+			}
+			for v := range strings.SplitSeq(val, "|") {
+				s.Enum = append(s.Enum, v)
+			}
+		case "minimum":
+			s.Minimum, err = parseFloat(val)
+		case "maximum":
+			s.Maximum, err = parseFloat(val)
+		case "minLength":
+			s.MinLength, err = parseInt(val)
+		case "maxLength":
+			s.MaxLength, err = parseInt(val)
+		case "pattern":
+			_, err = regexp.Compile(val)
+			s.Pattern = val
+		case "format":
+			s.Format = val
+		default:
+			err = fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("claude: bad jsonschema tag on field %s: %v", f.Name, err))
+		}
+	}
+	return required
+}
+
+func parseFloat(s string) (*float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return floatPtr(f), nil
+}
+
+func parseInt(s string) (*int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, err
+	}
+	return intPtr(i), nil
+}
+
+// Validate checks v, a value decoded from JSON, against the schema, returning
+// what's wrong with it, or nil if nothing is. It only knows the parts of JSON
+// Schema that SchemaFor uses, and ignores properties that aren't in the
+// schema.
+func (s *JSONSchema) Validate(v any) []string {
+	var problems []string
+	s.validate("input", v, &problems)
+	return problems
+}
+
+func (s *JSONSchema) validate(path string, v any, problems *[]string) {
+	problem := func(format string, args ...any) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+		problem("%s isn't one of the allowed values %s", jsonString(v), jsonString(s.Enum))
+		return
+	}
+
+	switch s.Type {
+	case ObjectType:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			problem("got %s, want an object", jsonString(v))
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				problem("missing required property %q", name)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(s.Properties)) {
+			if pv, ok := obj[name]; ok {
+				s.Properties[name].validate(path+"."+name, pv, problems)
+			}
+		}
+	case ArrayType:
+		arr, ok := v.([]any)
+		if !ok {
+			problem("got %s, want an array", jsonString(v))
+			return
+		}
+		if s.Items != nil {
+			for i, item := range arr {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, problems)
+			}
+		}
+	case StringType:
+		str, ok := v.(string)
+		if !ok {
+			problem("got %s, want a string", jsonString(v))
+			return
+		}
+		if n := utf8.RuneCountInString(str); s.MinLength != nil && n < *s.MinLength {
+			problem("%q is shorter than %d characters", str, *s.MinLength)
+		} else if s.MaxLength != nil && n > *s.MaxLength {
+			problem("%q is longer than %d characters", str, *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			if re, err := regexp.Compile(s.Pattern); err == nil && !re.MatchString(str) {
+				problem("%q doesn't match the pattern %s", str, s.Pattern)
+			}
+		}
+	case NumberType, IntegerType:
+		n, ok := v.(float64)
+		if !ok || (s.Type == IntegerType && n != math.Trunc(n)) {
+			problem("got %s, want %s %s", jsonString(v), map[JSONSchemaType]string{NumberType: "a", IntegerType: "an"}[s.Type], s.Type)
+			return
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			problem("%v is less than the minimum %v", n, *s.Minimum)
+		} else if s.Maximum != nil && n > *s.Maximum {
+			problem("%v is more than the maximum %v", n, *s.Maximum)
+		}
+	case BooleanType:
+		if _, ok := v.(bool); !ok {
+			problem("got %s, want a boolean", jsonString(v))
+		}
+	case NullType:
+		if v != nil {
+			problem("got %s, want null", jsonString(v))
+		}
+	}
+}
+
+// jsonString formats v as JSON, for error messages.
+func jsonString(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
 //nolint:unused
 func examples() {
 	// Example: Schema for a weather tool
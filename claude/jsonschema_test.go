@@ -0,0 +1,197 @@
+package claude
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSchemaFor(t *testing.T) {
+	type Base struct {
+		ID string `json:"id" jsonschema:"required"`
+	}
+	type item struct {
+		Name     string `json:"name" jsonschema:"required,minLength=1,maxLength=50" description:"Name of the item."`
+		Quantity int    `json:"quantity" jsonschema:"minimum=1,maximum=10"`
+	}
+	type list struct {
+		Base
+		Unit    string   `json:"unit,omitempty" jsonschema:"enum=celsius|fahrenheit"`
+		Items   []item   `json:"items" jsonschema:"required" description:"Items on the list."`
+		Price   *float64 `json:"price"`
+		Done    bool
+		Ignored string `json:"-"`
+		Ours    bool   `json:"ours" jsonschema:"-"`
+		private string
+	}
+
+	want := &JSONSchema{
+		Type: ObjectType,
+		Properties: map[string]*JSONSchema{
+			"id":   {Type: StringType},
+			"unit": {Type: StringType, Enum: []any{"celsius", "fahrenheit"}},
+			"items": {
+				Type:        ArrayType,
+				Description: "Items on the list.",
+				Items: &JSONSchema{
+					Type: ObjectType,
+					Properties: map[string]*JSONSchema{
+						"name":     {Type: StringType, Description: "Name of the item.", MinLength: intPtr(1), MaxLength: intPtr(50)},
+						"quantity": {Type: IntegerType, Minimum: floatPtr(1), Maximum: floatPtr(10)},
+					},
+					Required: []string{"name"},
+				},
+			},
+			"price": {Type: NumberType},
+			"Done":  {Type: BooleanType},
+		},
+		Required: []string{"id", "items"},
+	}
+	if got := SchemaFor[list](); !reflect.DeepEqual(got, want) {
+		gotJSON, _ := json.MarshalIndent(got, "", "  ")
+		wantJSON, _ := json.MarshalIndent(want, "", "  ")
+		t.Errorf("SchemaFor = %s, want %s", gotJSON, wantJSON)
+	}
+}
+
+func TestSchemaFor_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema func() *JSONSchema
+	}{
+		{
+			name: "map",
+			schema: SchemaFor[struct {
+				M map[string]string `json:"m"`
+			}],
+		},
+		{
+			name: "enum on a number",
+			schema: SchemaFor[struct {
+				N int `json:"n" jsonschema:"enum=1|2"`
+			}],
+		},
+		{
+			name: "bad minimum",
+			schema: SchemaFor[struct {
+				N int `json:"n" jsonschema:"minimum=one"`
+			}],
+		},
+		{
+			name: "unknown option",
+			schema: SchemaFor[struct {
+				S string `json:"s" jsonschema:"requried"`
+			}],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("SchemaFor didn't panic")
+				}
+			}()
+			tt.schema()
+		})
+	}
+}
+
+func TestJSONSchema_Validate(t *testing.T) {
+	type highlight struct {
+		Description    string `json:"description" jsonschema:"required,minLength=1"`
+		Classification string `json:"classification" jsonschema:"required,enum=good|bad"`
+	}
+	type output struct {
+		URL        string      `json:"url" jsonschema:"pattern=^https?://"`
+		Score      int         `json:"score" jsonschema:"minimum=0,maximum=10"`
+		Highlights []highlight `json:"highlights" jsonschema:"required"`
+	}
+	schema := SchemaFor[output]()
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "valid",
+			input: `{"url": "https://example.com", "score": 3, "highlights": [{"description": "d", "classification": "good"}], "extra": 1}`,
+		},
+		{
+			name:  "not an object",
+			input: `[]`,
+			want:  []string{"input: got [], want an object"},
+		},
+		{
+			name:  "missing required",
+			input: `{}`,
+			want:  []string{`input: missing required property "highlights"`},
+		},
+		{
+			name:  "bad enum",
+			input: `{"highlights": [{"description": "d", "classification": "good"}, {"description": "d", "classification": "terrible"}]}`,
+			want:  []string{`input.highlights[1].classification: "terrible" isn't one of the allowed values ["good","bad"]`},
+		},
+		{
+			name:  "wrong types",
+			input: `{"url": null, "score": 1.5, "highlights": {}}`,
+			want: []string{
+				"input.highlights: got {}, want an array",
+				"input.score: got 1.5, want an integer",
+				"input.url: got null, want a string",
+			},
+		},
+		{
+			name:  "constraints",
+			input: `{"url": "ftp://example.com", "score": 11, "highlights": [{"description": "", "classification": "bad"}]}`,
+			want: []string{
+				`input.highlights[0].description: "" is shorter than 1 characters`,
+				"input.score: 11 is more than the maximum 10",
+				`input.url: "ftp://example.com" doesn't match the pattern ^https?://`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v any
+			if err := json.Unmarshal([]byte(tt.input), &v); err != nil {
+				t.Fatalf("bad test input: %v", err)
+			}
+			if got := schema.Validate(v); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToolSchemas(t *testing.T) {
+	// The fields we fill in ourselves shouldn't be in the schemas we give the
+	// LLM, and the ones it has to fill in should be required.
+	pc := SchemaFor[PolicyClassification]()
+	if _, ok := pc.Properties["trimmed"]; ok {
+		t.Error("classification schema includes trimmed")
+	}
+	if len(pc.Required) != 5 {
+		t.Errorf("classification schema requires %q, want all five fields", pc.Required)
+	}
+	if enum := pc.Properties["policy_type"].Enum; len(enum) != 5 || enum[4] != "" {
+		t.Errorf("policy_type enum = %q, want the four types and blank", enum)
+	}
+	for url, valid := range map[string]bool{"": true, "https://example.com/privacy": true, "http://example.com": true, "javascript:alert(1)": false, "example.com": false} {
+		if got := len(pc.Properties["policy_url"].Validate(url)) == 0; got != valid {
+			t.Errorf("policy_url %q valid = %t, want %t", url, got, valid)
+		}
+	}
+
+	for name, s := range map[string]*JSONSchema{
+		"summary": SchemaFor[PolicySummary](),
+		"diff":    SchemaFor[DiffSummary](),
+	} {
+		if len(s.Properties) != 1 || len(s.Required) != 1 {
+			t.Errorf("%s schema has properties %v, required %q, want just highlights", name, s.Properties, s.Required)
+		}
+		if h := s.Properties["highlights"]; h == nil || h.Items == nil || len(h.Items.Required) != 2 {
+			t.Errorf("%s schema doesn't require a description and classification for each highlight", name)
+		}
+	}
+}
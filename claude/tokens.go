@@ -0,0 +1,162 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// defaultContextWindow is the context window, in tokens, of models that
+// aren't in contextWindows.
+const defaultContextWindow = 200_000
+
+// contextWindows are the context windows, in tokens, of the models we use.
+var contextWindows = map[string]int{
+	"claude-haiku-4-5-20251001": 200_000,
+	"claude-sonnet-4-6":         200_000,
+}
+
+// inputBudget returns the most input tokens a request to model can use: what
+// fits in the model's context window alongside maxTokens of output, up to
+// c.MaxInputTokens.
+func (c *Client) inputBudget(model string, maxTokens int) int {
+	window, ok := contextWindows[model]
+	if !ok {
+		window = defaultContextWindow
+	}
+	budget := window - maxTokens
+	if c.MaxInputTokens > 0 {
+		budget = min(budget, c.MaxInputTokens)
+	}
+	return budget
+}
+
+// countTokensRequest is the body of a token counting request, which is a
+// Messages request without the parameters that only affect the output.
+type countTokensRequest struct {
+	Model      string         `json:"model"`
+	System     []ContentBlock `json:"system,omitempty"`
+	Messages   []Message      `json:"messages"`
+	Tools      []Tool         `json:"tools,omitempty"`
+	ToolChoice *ToolChoice    `json:"tool_choice,omitempty"`
+}
+
+// CountTokens returns how many input tokens req would use, according to the
+// API's token counting endpoint. If that fails, it logs the error and falls
+// back to EstimateTokens, since an estimate is good enough to budget with.
+func (c *Client) CountTokens(req *Request) int {
+	n, err := c.countTokens(req)
+	if err != nil {
+		log.Printf("Failed to count tokens, estimating instead: %v", err)
+		return EstimateTokens(req)
+	}
+	return n
+}
+
+func (c *Client) countTokens(req *Request) (int, error) {
+	if c.APIKey == "" {
+		return 0, fmt.Errorf("ANTHROPIC_API_KEY not provided")
+	}
+	body, err := json.Marshal(&countTokensRequest{
+		Model:      req.Model,
+		System:     req.System,
+		Messages:   req.Messages,
+		Tools:      req.Tools,
+		ToolChoice: req.ToolChoice,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error marshaling request: %v", err)
+	}
+	var resp struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := c.post("/v1/messages/count_tokens", body, &resp); err != nil {
+		return 0, err
+	}
+	return resp.InputTokens, nil
+}
+
+// EstimateTokens guesses how many input tokens req would use, without asking
+// the API. It errs on the high side, assuming three bytes of JSON per token,
+// where English text usually gets closer to four.
+func EstimateTokens(req *Request) int {
+	data, err := json.Marshal(&countTokensRequest{
+		System:     req.System,
+		Messages:   req.Messages,
+		Tools:      req.Tools,
+		ToolChoice: req.ToolChoice,
+	})
+	if err != nil {
+		// Can't happen, these are all plain data.
+		return 0
+	}
+	return len(data)/3 + 1
+}
+
+// fitChunks splits text into as few chunks as it can (up to maxChunks, or any
+// number if it's zero) that each fit in our input budget when built into a
+// request with build. Chunks break at boundaries where possible, see
+// splitChunks. If the chunks run out before the text does, the rest of the
+// text is left out, and fitChunks reports that it was trimmed. It returns an
+// error wrapping ErrContextTooLong if the request doesn't fit in the budget
+// even without any text.
+func (c *Client) fitChunks(text string, maxChunks int, isBoundary func(line string) bool, build func(chunk string) *Request) ([]string, bool, error) {
+	base := build("")
+	limit := c.inputBudget(base.Model, base.MaxTokens)
+	estimatedOverhead := EstimateTokens(base)
+	countedOverhead := -1 // Counted when first needed.
+	// measure returns how many tokens chunk adds to the rest of the request,
+	// and how many it can add while still fitting, both measured the same
+	// way.
+	measure := func(chunk string) (n, budget int, err error) {
+		req := build(chunk)
+		if est := EstimateTokens(req); est <= limit {
+			// It fits even by our pessimistic estimate, no need to ask.
+			return est - estimatedOverhead, limit - estimatedOverhead, nil
+		}
+		if countedOverhead < 0 {
+			countedOverhead = c.CountTokens(base)
+		}
+		if countedOverhead >= limit {
+			return 0, 0, fmt.Errorf("%w: the prompt is %d tokens without any input, leaving no room in our budget of %d", ErrContextTooLong, countedOverhead, limit)
+		}
+		return c.CountTokens(req) - countedOverhead, limit - countedOverhead, nil
+	}
+
+	var chunks []string
+	// add adds text to chunks, splitting it up if it doesn't fit, and returns
+	// false if we run out of chunks.
+	var add func(text string) (bool, error)
+	add = func(text string) (bool, error) {
+		n, budget, err := measure(text)
+		if err != nil {
+			return false, err
+		}
+		if n <= budget || len(text) <= 1 {
+			if maxChunks > 0 && len(chunks) == maxChunks {
+				return false, nil
+			}
+			chunks = append(chunks, text)
+			return true, nil
+		}
+		// Aim a bit under the budget, since the number of bytes per token varies
+		// across the text.
+		size := int(float64(len(text)) * float64(budget) / float64(n) * 0.9)
+		size = max(min(size, len(text)-1), 1)
+		for _, part := range splitChunks(text, size, isBoundary) {
+			if ok, err := add(part); !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	ok, err := add(text)
+	if err != nil {
+		return nil, false, err
+	}
+	trimmed := !ok
+	if trimmed {
+		log.Printf("Analyzing %d chunks, the rest are over our limit", len(chunks))
+	}
+	return chunks, trimmed, nil
+}
@@ -0,0 +1,99 @@
+package claude
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// Usage is how many tokens requests used, as reported by the API. Writing to
+// and reading from the prompt cache are billed differently from other input
+// (a bit more and a lot less, respectively), so those tokens are counted
+// separately, and aren't included in InputTokens.
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// Add adds o to u.
+func (u *Usage) Add(o Usage) {
+	u.InputTokens += o.InputTokens
+	u.OutputTokens += o.OutputTokens
+	u.CacheCreationInputTokens += o.CacheCreationInputTokens
+	u.CacheReadInputTokens += o.CacheReadInputTokens
+}
+
+// CacheControl marks the end of a prompt prefix to cache. See
+// Client.cacheSystemPrompt.
+type CacheControl struct {
+	Type string `json:"type"`
+}
+
+// defaultMinCacheTokens is the minimum cacheable prefix for models that
+// aren't in minCacheTokens.
+const defaultMinCacheTokens = 1024
+
+// minCacheTokens are the shortest prompt prefixes, in tokens, that the models
+// we use will cache. Shorter prefixes are processed like any other input, even
+// when they're marked for caching.
+var minCacheTokens = map[string]int{
+	"claude-haiku-4-5-20251001": 4096,
+	"claude-sonnet-4-6":         1024,
+}
+
+// minCacheTokensFor returns how many tokens a prompt prefix needs for model to
+// cache it.
+func minCacheTokensFor(model string) int {
+	if n, ok := minCacheTokens[model]; ok {
+		return n
+	}
+	return defaultMinCacheTokens
+}
+
+// systemPrompt returns a system prompt of text.
+func systemPrompt(text string) []ContentBlock {
+	return []ContentBlock{{Type: "text", Text: text}}
+}
+
+// cacheSystemPrompt marks the end of req's system prompt, if it has one, to be
+// cached. The cached prefix covers the tools too, so requests with the same
+// tools and instructions, like every report of one kind, only pay full price
+// for their instructions the first time in a while (five minutes, refreshed on
+// each use). Models don't cache prefixes shorter than minCacheTokens, so those
+// aren't marked. Each prefix is only measured once.
+func (c *Client) cacheSystemPrompt(req *Request) {
+	if len(req.System) == 0 {
+		return
+	}
+	// The token counting endpoint needs a message, which adds a few tokens to
+	// the count.
+	prefix := &Request{
+		Model:    req.Model,
+		System:   req.System,
+		Tools:    req.Tools,
+		Messages: []Message{{Role: "user", Content: "."}},
+	}
+	key, err := json.Marshal(prefix)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	cacheable, ok := c.cacheable[string(key)]
+	c.mu.Unlock()
+	if !ok {
+		cacheable = c.CountTokens(prefix) >= minCacheTokensFor(req.Model)
+		c.mu.Lock()
+		if c.cacheable == nil {
+			c.cacheable = make(map[string]bool)
+		}
+		c.cacheable[string(key)] = cacheable
+		c.mu.Unlock()
+	}
+	if !cacheable {
+		return
+	}
+	req.System = slices.Clone(req.System)
+	req.System[len(req.System)-1].CacheControl = &CacheControl{Type: "ephemeral"}
+}
@@ -0,0 +1,39 @@
+package claude
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCacheSystemPrompt(t *testing.T) {
+	// Without an API key, token counts fall back to EstimateTokens.
+	c := NewClient("")
+	tools := []Tool{{Name: "extract_highlights", InputSchema: *policySummarySchema}}
+	long := strings.Repeat("Some instructions. ", 500)
+
+	tests := []struct {
+		name      string
+		model     string
+		system    string
+		wantCache bool
+	}{
+		{name: "long enough", model: "claude-sonnet-4-6", system: long, wantCache: true},
+		{name: "too short", model: "claude-sonnet-4-6", system: summaryInstructions},
+		{name: "too short for the model", model: "claude-haiku-4-5-20251001", system: long},
+		{name: "no system prompt", model: "claude-sonnet-4-6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Model: tt.model, Tools: tools}
+			if tt.system != "" {
+				req.System = systemPrompt(tt.system)
+			}
+			prefixTokens := EstimateTokens(&Request{System: req.System, Tools: tools})
+			c.cacheSystemPrompt(req)
+			cached := len(req.System) > 0 && req.System[0].CacheControl != nil
+			if cached != tt.wantCache {
+				t.Errorf("cacheSystemPrompt marked a %d token prefix for %s: %t, want %t", prefixTokens, tt.model, cached, tt.wantCache)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bcspragu/fineprint/commands"
+	"github.com/bcspragu/fineprint/postmark"
+	"github.com/bcspragu/fineprint/queue"
+	"github.com/bcspragu/fineprint/ratelimit"
+	"github.com/bcspragu/fineprint/store"
+)
+
+// maxWatchesPerUser limits how many policies one person can watch, since each
+// one costs us a fetch (and possibly an LLM call) per check.
+const maxWatchesPerUser = 25
+
+// pendingWatchTTL is how long someone has to confirm a watch.
+const pendingWatchTTL = 7 * 24 * time.Hour
+
+// handleCommand carries out a command from an email (see package commands),
+// and replies with the result.
+func (h *Handler) handleCommand(email *postmark.InboundEmail, messageID string, cmd commands.Command) error {
+	log.Printf("Handling %q command from %s", cmd.Name, email.From)
+
+	analysis := &store.Analysis{
+		MessageID: messageID,
+		From:      email.From,
+		Subject:   email.Subject,
+	}
+	defer h.saveAnalysis(analysis)
+
+	if !h.allow("commands:"+ratelimit.NormalizeEmail(email.From), 20, time.Hour) {
+		log.Printf("Command rate limit exceeded for %s", email.From)
+		analysis.Outcome = "Command rate limit exceeded"
+		return nil
+	}
+
+	// Check before running the command, since it's run again when we retry,
+	// and confirmations only work once.
+	if !h.allow("email:global", 1000, time.Hour) {
+		analysis.Outcome = "Service temporarily unavailable - email sending limit reached"
+		return queue.Delay(errors.New("global email sending rate limit exceeded"), rateLimitDelay)
+	}
+
+	subject, reply, err := h.runCommand(email.From, cmd)
+	if err != nil {
+		analysis.Outcome = fmt.Sprintf("Command %q failed", cmd.Name)
+		return fmt.Errorf("failed to run %q command: %w", cmd.Name, err)
+	}
+
+	if subject == "" {
+		subject = "Re: " + strings.TrimSpace(email.Subject)
+		if strings.TrimSpace(email.Subject) == "" {
+			subject = "Your Fineprint watches"
+		}
+	}
+	analysis.ReplySubject, analysis.ReplyText = subject, reply
+
+	if err := h.mailer.Send(postmark.NewEmail(h.replyFromEmail, email.From, subject, reply, "", messageID, messageID)); err != nil {
+		analysis.Outcome = "Failed to send the command reply"
+		return fmt.Errorf("failed to send command reply: %w", err)
+	}
+	analysis.SentAt = time.Now()
+	analysis.Outcome = fmt.Sprintf("Command %q handled", cmd.Name)
+	h.updateMessage(messageID, func(m *store.Message) { m.RepliedAt = analysis.SentAt })
+	return nil
+}
+
+// runCommand carries out cmd on behalf of from, and returns the subject and
+// text of our reply. The subject is empty to reply with the subject of the
+// command's email. Mistakes in the command are explained in the reply rather
+// than returned as errors, which are reserved for our own failures.
+func (h *Handler) runCommand(from string, cmd commands.Command) (string, string, error) {
+	watched, err := h.watchedBy(from)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch cmd.Name {
+	case commands.Watch:
+		u, err := commands.ParseURL(cmd.Arg)
+		if err != nil {
+			return "", fmt.Sprintf("We couldn't watch %q: %v.\n\n%s", cmd.Arg, err, commands.Usage), nil
+		}
+		policyURL := store.CanonicalURL(u.String())
+		if reply, ok := checkCanWatch(watched, policyURL); !ok {
+			return "", reply, nil
+		}
+		// Anyone can send us an email from any address, so we only start
+		// watching once whoever gets our reply confirms it.
+		pw := &store.PendingWatch{Email: from, Policy: newWatchedPolicy(u)}
+		if err := h.store.AddPendingWatch(pw); err != nil {
+			return "", "", fmt.Errorf("failed to add pending watch: %w", err)
+		}
+		// Replying keeps the subject, which is itself the command to confirm.
+		confirm := commands.Confirm + " " + pw.Token
+		return confirm, fmt.Sprintf("Before we start watching %s for you, please confirm that you asked us to, by replying to this email. You can also send us \"%s\" to confirm.\n\nIf you didn't ask us to, you can ignore this email, and we won't watch it for you.", policyURL, confirm), nil
+
+	case commands.Confirm:
+		pw, err := h.store.PendingWatch(cmd.Arg)
+		// Tokens only go to the address that asked to watch, and confirmations
+		// have to come back from it.
+		if errors.Is(err, store.ErrNotFound) || (err == nil && !strings.EqualFold(strings.TrimSpace(pw.Email), strings.TrimSpace(from))) {
+			return "", fmt.Sprintf("We couldn't find that watch to confirm. It may have been confirmed already.\n\n%s", listWatches(watched)), nil
+		} else if err != nil {
+			return "", "", fmt.Errorf("failed to load pending watch: %w", err)
+		}
+		if err := h.store.RemovePendingWatch(pw.Token); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return "", "", fmt.Errorf("failed to remove pending watch: %w", err)
+		}
+		if time.Since(pw.CreatedAt) > pendingWatchTTL {
+			return "", fmt.Sprintf("That confirmation has expired. To watch %s, send us \"watch %s\" again.", pw.Policy.URL, pw.Policy.URL), nil
+		}
+		policyURL := store.CanonicalURL(pw.Policy.URL)
+		if reply, ok := checkCanWatch(watched, policyURL); !ok {
+			return "", reply, nil
+		}
+		if _, err := h.store.AddWatcher(pw.Policy, from); err != nil {
+			return "", "", fmt.Errorf("failed to add watcher: %w", err)
+		}
+		return "", fmt.Sprintf("You're now watching %s. We'll check it regularly, and email you a summary whenever it changes.\n\nTo stop, reply with \"unwatch %s\".", policyURL, policyURL), nil
+
+	case commands.Unwatch:
+		if cmd.Arg == "" {
+			if len(watched) != 1 {
+				return "", fmt.Sprintf("Which policy should we stop watching? Reply with \"unwatch <url>\", or \"stop\" to stop watching all of them.\n\n%s", listWatches(watched)), nil
+			}
+			cmd.Arg = watched[0].URL
+		}
+		// There's no need to look up the host of a URL we're only going to
+		// stop fetching (see commands.ParseURL), so just fill in the scheme.
+		policyURL := cmd.Arg
+		if !strings.Contains(policyURL, "://") {
+			policyURL = "https://" + policyURL
+		}
+		policyURL = store.CanonicalURL(policyURL)
+		if err := h.store.RemoveWatcher(policyURL, from); errors.Is(err, store.ErrNotFound) {
+			return "", fmt.Sprintf("You weren't watching %s.\n\n%s", policyURL, listWatches(watched)), nil
+		} else if err != nil {
+			return "", "", fmt.Errorf("failed to remove watcher: %w", err)
+		}
+		return "", fmt.Sprintf("You're no longer watching %s.", policyURL), nil
+
+	case commands.List:
+		return "", listWatches(watched), nil
+
+	case commands.Stop:
+		if len(watched) == 0 {
+			return "", "You weren't watching any policies.", nil
+		}
+		for _, wp := range watched {
+			if err := h.store.RemoveWatcher(wp.URL, from); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return "", "", fmt.Errorf("failed to remove watcher: %w", err)
+			}
+		}
+		return "", fmt.Sprintf("You're no longer watching any policies. We stopped watching:\n\n%s", watchList(watched)), nil
+
+	default:
+		return "", commands.Usage, nil
+	}
+}
+
+// checkCanWatch checks whether someone watching the watched policies can
+// start watching the one at policyURL, and if not, returns a reply explaining
+// why.
+func checkCanWatch(watched []*store.WatchedPolicy, policyURL string) (string, bool) {
+	if slices.ContainsFunc(watched, func(wp *store.WatchedPolicy) bool { return wp.URL == policyURL }) {
+		return fmt.Sprintf("You're already watching %s.", policyURL), false
+	}
+	if len(watched) >= maxWatchesPerUser {
+		return fmt.Sprintf("You're already watching %d policies, which is as many as we allow. Unwatch one first.\n\n%s", len(watched), listWatches(watched)), false
+	}
+	return "", true
+}
+
+// watchedBy returns the policies email is watching.
+func (h *Handler) watchedBy(email string) ([]*store.WatchedPolicy, error) {
+	all, err := h.store.WatchedPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load watched policies: %w", err)
+	}
+	var out []*store.WatchedPolicy
+	for _, wp := range all {
+		if wp.HasWatcher(email) {
+			out = append(out, wp)
+		}
+	}
+	slices.SortFunc(out, func(a, b *store.WatchedPolicy) int { return strings.Compare(a.URL, b.URL) })
+	return out, nil
+}
+
+func listWatches(watched []*store.WatchedPolicy) string {
+	if len(watched) == 0 {
+		return "You aren't watching any policies.\n\n" + commands.Usage
+	}
+	return fmt.Sprintf("You're watching:\n\n%s\n\n%s", watchList(watched), commands.Usage)
+}
+
+func watchList(watched []*store.WatchedPolicy) string {
+	var lines []string
+	for _, wp := range watched {
+		lines = append(lines, "- "+wp.URL)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// newWatchedPolicy describes the policy at u as best we can without having
+// classified it, for use in the summaries we send.
+func newWatchedPolicy(u *url.URL) *store.WatchedPolicy {
+	company, policyType := guessPolicy(u)
+	return &store.WatchedPolicy{
+		URL:        u.String(),
+		Company:    company,
+		PolicyType: policyType,
+	}
+}
+
+// guessPolicy guesses who a policy belongs to and what kind of policy it is
+// from its URL, for when we haven't classified an email about it.
+func guessPolicy(u *url.URL) (company, policyType string) {
+	policyType = "other"
+	path := strings.ToLower(u.Path)
+	switch {
+	case strings.Contains(path, "privacy"):
+		policyType = "privacy_policy"
+	case strings.Contains(path, "terms"), strings.Contains(path, "tos"):
+		policyType = "terms_of_service"
+	case strings.Contains(path, "agreement"):
+		policyType = "user_agreement"
+	}
+	return strings.TrimPrefix(u.Hostname(), "www."), policyType
+}
@@ -0,0 +1,148 @@
+// Package commands parses the commands people can email us to manage the
+// policies they're watching, like "watch https://example.com/privacy".
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bcspragu/fineprint/internal/netguard"
+)
+
+// Names of the commands we understand.
+const (
+	// Watch starts watching the policy at the given URL, once it's confirmed.
+	Watch = "watch"
+	// Confirm confirms a watch, given the token from our reply to it.
+	Confirm = "confirm"
+	// Unwatch stops watching the policy at the given URL, or the only policy
+	// being watched if there's no URL.
+	Unwatch = "unwatch"
+	// List lists the policies being watched.
+	List = "list"
+	// Stop stops watching every policy.
+	Stop = "stop"
+	// Help explains the commands.
+	Help = "help"
+)
+
+// Usage explains the commands, for inclusion in replies.
+const Usage = `You can manage the policies you're watching by emailing us one of these commands, on the first line of the email or as its subject:
+
+  watch <url>     Email you a summary whenever the policy at <url> changes,
+                  once you confirm by replying to our confirmation email
+  unwatch <url>   Stop watching the policy at <url>
+  list            List the policies you're watching
+  stop            Stop watching all policies
+  help            Show this message`
+
+// A Command is a request from an email.
+type Command struct {
+	// Name is one of the command names above.
+	Name string
+	// Arg is the command's argument, e.g. the URL to watch, if any.
+	Arg string
+}
+
+// Parse looks for a command in an email. Commands are recognized on the first
+// non-empty line of the body (which should be the reply text, without any
+// quoted message), or failing that, in the subject, ignoring any "Re:" or
+// "Fwd:" prefixes. To keep forwarded policy emails from being mistaken for
+// commands, the whole line has to be a command: a command name, and a single
+// argument for commands that take one.
+func Parse(subject, body string) (Command, bool) {
+	for line := range strings.SplitSeq(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if cmd, ok := parseLine(line); ok {
+			return cmd, true
+		}
+		break
+	}
+	return parseLine(stripSubjectPrefixes(subject))
+}
+
+func parseLine(line string) (Command, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Command{}, false
+	}
+	// Allow for some punctuation, like "Stop." or "list!".
+	name := strings.ToLower(strings.TrimRight(fields[0], ".!"))
+	var arg string
+	if len(fields) == 2 {
+		// Some mail clients wrap links in angle brackets.
+		arg = strings.Trim(fields[1], "<>")
+	}
+
+	switch name {
+	case Watch, Confirm:
+		if arg == "" {
+			return Command{}, false
+		}
+	case Unwatch:
+		// The URL is optional.
+	case List, Stop, Help:
+		if arg != "" {
+			return Command{}, false
+		}
+	default:
+		return Command{}, false
+	}
+	return Command{Name: name, Arg: arg}, true
+}
+
+func stripSubjectPrefixes(subject string) string {
+	subject = strings.TrimSpace(subject)
+	for {
+		lower := strings.ToLower(subject)
+		var found bool
+		for _, prefix := range []string{"re:", "fwd:", "fw:"} {
+			if strings.HasPrefix(lower, prefix) {
+				subject = strings.TrimSpace(subject[len(prefix):])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return subject
+		}
+	}
+}
+
+// lookupTimeout caps how long ParseURL waits to resolve a URL's host.
+const lookupTimeout = 10 * time.Second
+
+// ParseURL validates the URL argument of a watch or unwatch command. A missing
+// scheme is assumed to be HTTPS. The host has to resolve to public internet
+// addresses only (see netguard.CheckHost), since we fetch the URL and email
+// people what's there.
+func ParseURL(arg string) (*url.URL, error) {
+	if !strings.Contains(arg, "://") {
+		arg = "https://" + arg
+	}
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, errors.New("that doesn't look like a URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("only http and https URLs can be watched")
+	}
+	if u.Hostname() == "" || !strings.Contains(u.Hostname(), ".") {
+		return nil, errors.New("that URL doesn't have a valid domain")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	if err := netguard.CheckHost(ctx, u.Hostname()); errors.Is(err, netguard.ErrNotPublic) {
+		return nil, errors.New("that URL isn't on the public internet")
+	} else if err != nil {
+		return nil, fmt.Errorf("we couldn't look up %s", u.Hostname())
+	}
+	return u, nil
+}
@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/bcspragu/fineprint/internal/netguard"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		body    string
+		want    Command
+		wantOK  bool
+	}{
+		{
+			name:   "watch in body",
+			body:   "watch https://example.com/privacy\n\nSent from my phone",
+			want:   Command{Name: Watch, Arg: "https://example.com/privacy"},
+			wantOK: true,
+		},
+		{
+			name:   "leading blank lines and case",
+			body:   "\n\n  WATCH <https://example.com/terms>  \n",
+			want:   Command{Name: Watch, Arg: "https://example.com/terms"},
+			wantOK: true,
+		},
+		{
+			name:   "unwatch without URL",
+			body:   "unwatch",
+			want:   Command{Name: Unwatch},
+			wantOK: true,
+		},
+		{
+			name:    "command in subject",
+			subject: "Re: Re: Stop",
+			body:    "Thanks, but no thanks.",
+			want:    Command{Name: Stop},
+			wantOK:  true,
+		},
+		{
+			name:   "punctuation",
+			body:   "List.",
+			want:   Command{Name: List},
+			wantOK: true,
+		},
+		{
+			name:    "reply to a confirmation",
+			subject: "RE: confirm 0123456789abcdef",
+			body:    "Yes please\n\nOn Mon, Fineprint wrote:\n> Before we start watching",
+			want:    Command{Name: Confirm, Arg: "0123456789abcdef"},
+			wantOK:  true,
+		},
+		{
+			name:   "confirm needs a token",
+			body:   "confirm",
+			wantOK: false,
+		},
+		{
+			name:   "watch needs a URL",
+			body:   "watch",
+			wantOK: false,
+		},
+		{
+			name:   "list takes no argument",
+			body:   "list everything",
+			wantOK: false,
+		},
+		{
+			name:    "forwarded policy email",
+			subject: "Fwd: We're updating our Privacy Policy",
+			body:    "---------- Forwarded message ---------\nFrom: Example <privacy@example.com>\n\nStop by our help center to learn more.",
+			wantOK:  false,
+		},
+		{
+			name:   "command-like sentence",
+			body:   "Stop selling my data, please.",
+			wantOK: false,
+		},
+		{
+			name:   "command not on the first line",
+			body:   "Hi there,\nwatch https://example.com/privacy",
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Parse(tt.subject, tt.body)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("Parse(%q, %q) = %+v, %t, want %+v, %t", tt.subject, tt.body, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+type fakeResolver map[string]string
+
+func (r fakeResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addr, ok := r[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return []netip.Addr{netip.MustParseAddr(addr)}, nil
+}
+
+func TestParseURL(t *testing.T) {
+	old := netguard.Resolver
+	defer func() { netguard.Resolver = old }()
+	netguard.Resolver = fakeResolver{
+		"example.com":          "93.184.215.14",
+		"metadata.example.com": "169.254.169.254",
+	}
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "https://example.com/privacy", want: "https://example.com/privacy"},
+		{in: "example.com/privacy", want: "https://example.com/privacy"},
+		{in: "ftp://example.com/privacy", wantErr: true},
+		{in: "localhost/privacy", wantErr: true},
+		{in: "https://", wantErr: true},
+		{in: "http://169.254.169.254/latest/meta-data", wantErr: true},
+		{in: "10.0.0.5/admin", wantErr: true},
+		{in: "http://[::1]:8080/", wantErr: true},
+		{in: "https://metadata.example.com/privacy", wantErr: true},
+		{in: "https://nonexistent.example.com/privacy", wantErr: true},
+	}
+	for _, tt := range tests {
+		u, err := ParseURL(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseURL(%q) = %v, want an error", tt.in, u)
+			}
+			continue
+		}
+		if err != nil || u.String() != tt.want {
+			t.Errorf("ParseURL(%q) = %v, %v, want %q", tt.in, u, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bcspragu/fineprint/commands"
+	"github.com/bcspragu/fineprint/postmark"
+	"github.com/bcspragu/fineprint/store"
+)
+
+// sentMail records the emails it's asked to send.
+type sentMail []*postmark.EmailRequest
+
+func (s *sentMail) Send(email *postmark.EmailRequest) error {
+	*s = append(*s, email)
+	return nil
+}
+
+func TestHandleCommand_WatchNeedsConfirmation(t *testing.T) {
+	var sent sentMail
+	h := &Handler{
+		store:          store.NewMemory(),
+		mailer:         &sent,
+		replyFromEmail: "app@fineprint.example",
+	}
+	send := func(from, subject, body string) *postmark.EmailRequest {
+		t.Helper()
+		cmd, ok := commands.Parse(subject, body)
+		if !ok {
+			t.Fatalf("commands.Parse(%q, %q) found no command", subject, body)
+		}
+		email := &postmark.InboundEmail{From: from, Subject: subject, TextBody: body}
+		if err := h.handleCommand(email, "<"+from+"-"+subject+">", cmd); err != nil {
+			t.Fatalf("handleCommand(%+v): %v", cmd, err)
+		}
+		reply := sent[len(sent)-1]
+		if reply.To != from {
+			t.Errorf("reply went to %q, want %q", reply.To, from)
+		}
+		return reply
+	}
+	watchers := func() int {
+		t.Helper()
+		watched, err := h.store.WatchedPolicies()
+		if err != nil {
+			t.Fatalf("WatchedPolicies: %v", err)
+		}
+		n := 0
+		for _, wp := range watched {
+			n += len(wp.Watchers)
+		}
+		return n
+	}
+
+	reply := send("alice@example.com", "", "watch example.com/privacy")
+	confirm, ok := strings.CutPrefix(reply.Subject, "confirm ")
+	if !ok || !strings.Contains(reply.TextBody, "https://example.com/privacy") {
+		t.Fatalf("watch reply = %q: %q, want a confirmation request", reply.Subject, reply.TextBody)
+	}
+	if n := watchers(); n != 0 {
+		t.Fatalf("%d watchers before confirming, want none", n)
+	}
+
+	// Someone else can't confirm it for alice, even with the token.
+	send("mallory@example.com", "", "confirm "+confirm)
+	if n := watchers(); n != 0 {
+		t.Fatalf("%d watchers after someone else confirmed, want none", n)
+	}
+
+	// Replying to the confirmation request confirms it.
+	reply = send("Alice@example.com", "Re: "+reply.Subject, "Yes please\n\n> Before we start watching")
+	if !strings.Contains(reply.TextBody, "You're now watching https://example.com/privacy") {
+		t.Errorf("confirm reply = %q, want the watch to start", reply.TextBody)
+	}
+	if n := watchers(); n != 1 {
+		t.Errorf("%d watchers after confirming, want 1", n)
+	}
+
+	// Tokens only work once.
+	reply = send("alice@example.com", "", "confirm "+confirm)
+	if !strings.Contains(reply.TextBody, "couldn't find that watch") {
+		t.Errorf("second confirm reply = %q, want it rejected", reply.TextBody)
+	}
+
+	for _, url := range []string{"http://169.254.169.254/latest/meta-data", "10.0.0.5/admin"} {
+		reply = send("alice@example.com", "", "watch "+url)
+		if !strings.Contains(reply.TextBody, "isn't on the public internet") || strings.HasPrefix(reply.Subject, "confirm") {
+			t.Errorf("watch %s reply = %q: %q, want it rejected", url, reply.Subject, reply.TextBody)
+		}
+	}
+}
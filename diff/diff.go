@@ -16,9 +16,46 @@ import (
 type Edit struct {
 	Start, End int    // byte offsets of the region to replace
 	New        string // the replacement
+
+	// Kind and Move describe edits that are one half of a move, as found
+	// by DetectMoves. Other edits leave them zero. They're informational only:
+	// Apply treats every edit as a plain replacement.
+	Kind EditKind
+	// Move is the Start of the other half of the move: where a MoveOut edit's
+	// text was reinserted, or where a MoveIn edit's text was removed.
+	Move int
+}
+
+// An EditKind classifies an Edit.
+type EditKind uint8
+
+const (
+	// Replace is an ordinary edit.
+	Replace EditKind = iota
+	// MoveOut is a deletion of text that was reinserted, unchanged, elsewhere.
+	MoveOut
+	// MoveIn is an insertion of text that was deleted, unchanged, from
+	// elsewhere.
+	MoveIn
+)
+
+func (k EditKind) String() string {
+	switch k {
+	case Replace:
+		return "replace"
+	case MoveOut:
+		return "move-out"
+	case MoveIn:
+		return "move-in"
+	default:
+		return fmt.Sprintf("EditKind(%d)", k)
+	}
 }
 
 func (e Edit) String() string {
+	if e.Kind != Replace {
+		return fmt.Sprintf("{Start:%d,End:%d,New:%q,Kind:%s,Move:%d}", e.Start, e.End, e.New, e.Kind, e.Move)
+	}
 	return fmt.Sprintf("{Start:%d,End:%d,New:%q}", e.Start, e.End, e.New)
 }
 
@@ -138,10 +175,12 @@ expand:
 	// TODO(adonovan): opt: avoid quadratic cost of string += string.
 	for _, edit := range edits[1:] {
 		between := src[prev.End:edit.Start]
-		if !strings.Contains(between, "\n") {
+		if !strings.Contains(between, "\n") && !(between == "" && endsLine(src, prev)) {
 			// overlapping lines: combine with previous edit.
+			// The result is no longer a pure move, if either half was one.
 			prev.New += between + edit.New
 			prev.End = edit.End
+			prev.Kind, prev.Move = Replace, 0
 		} else {
 			// non-overlapping lines: flush previous edit.
 			expanded = append(expanded, expandEdit(prev, src))
@@ -151,6 +190,14 @@ expand:
 	return append(expanded, expandEdit(prev, src)), nil // flush final edit
 }
 
+// endsLine reports whether edit ends at the start of a line in both src and
+// the output, so that an edit starting right after it doesn't share a line
+// with it. Keeping such edits apart preserves the moves found by DetectMoves.
+func endsLine(src string, edit Edit) bool {
+	return (edit.End == 0 || src[edit.End-1] == '\n') &&
+		(edit.New == "" || edit.New[len(edit.New)-1] == '\n')
+}
+
 // expandEdit returns edit expanded to complete whole lines.
 func expandEdit(edit Edit, src string) Edit {
 	// Expand start left to start of line.
@@ -173,5 +220,9 @@ func expandEdit(edit Edit, src string) Edit {
 	}
 	edit.New += src[end:edit.End]
 
+	if edit.Start != start || edit.End != end {
+		// Expanded moves include unmoved text, so they aren't moves anymore.
+		edit.Kind, edit.Move = Replace, 0
+	}
 	return edit
 }
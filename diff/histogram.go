@@ -0,0 +1,63 @@
+package diff
+
+// maxChainLength is the most times a line can appear in a before the
+// histogram algorithm stops considering it as an anchor, as in JGit.
+const maxChainLength = 64
+
+// histogram matches a and b using the histogram diff algorithm: it finds the
+// longest run of equal lines whose rarest line (in a) is as rare as possible,
+// matches that, and recurses on either side. See JGit's HistogramDiff.
+func (m *matcher) histogram(a, b []string, aoff, boff int) {
+	m.trim(a, b, aoff, boff, m.histogramAnchor)
+}
+
+func (m *matcher) histogramAnchor(a, b []string, aoff, boff int) {
+	occurrences := make(map[string][]int) // line -> indexes in a
+	for i, l := range a {
+		occurrences[l] = append(occurrences[l], i)
+	}
+
+	var (
+		bestA, bestB, bestLen int
+		bestCount             = maxChainLength
+	)
+	for j := 0; j < len(b); {
+		next := j + 1
+		positions := occurrences[b[j]]
+		if n := len(positions); n == 0 || n > bestCount {
+			j = next
+			continue
+		}
+		for _, i := range positions {
+			// Extend the match in both directions as far as it goes.
+			as, bs := i, j
+			for as > 0 && bs > 0 && a[as-1] == b[bs-1] {
+				as--
+				bs--
+			}
+			ae, be := i+1, j+1
+			for ae < len(a) && be < len(b) && a[ae] == b[be] {
+				ae++
+				be++
+			}
+
+			count := bestCount
+			for k := as; k < ae; k++ {
+				count = min(count, len(occurrences[a[k]]))
+			}
+			if count < bestCount || (count == bestCount && ae-as > bestLen) {
+				bestA, bestB, bestLen, bestCount = as, bs, ae-as, count
+			}
+			next = max(next, be)
+		}
+		j = next
+	}
+
+	if bestLen == 0 {
+		m.lcs(a, b, aoff, boff)
+		return
+	}
+	m.histogram(a[:bestA], b[:bestB], aoff, boff)
+	m.equal(aoff+bestA, boff+bestB, bestLen)
+	m.histogram(a[bestA+bestLen:], b[bestB+bestLen:], aoff+bestA+bestLen, boff+bestB+bestLen)
+}
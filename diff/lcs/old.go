@@ -27,6 +27,10 @@ func DiffBytes(a, b []byte) []Diff { return diff(bytesSeqs{a, b}) }
 // DiffRunes returns the differences between two rune sequences.
 func DiffRunes(a, b []rune) []Diff { return diff(runesSeqs{a, b}) }
 
+// DiffTokens returns the differences between two sequences of tokens,
+// such as words or lines, comparing tokens for equality.
+func DiffTokens(a, b []string) []Diff { return diff(tokensSeqs{a, b}) }
+
 func diff(seqs sequences) []Diff {
 	// A limit on how deeply the LCS algorithm should search. The value is just a guess.
 	const maxDiffs = 100
@@ -158,6 +158,16 @@ func TestDiffAPI(t *testing.T) {
 	}
 }
 
+func TestDiffTokens(t *testing.T) {
+	a := []string{"We", " ", "may", " ", "share", " ", "data"}
+	b := []string{"We", " ", "will", " ", "share", " ", "your", " ", "data"}
+
+	got := fmt.Sprint(DiffTokens(a, b))
+	if want := "[{2 3 2 3} {5 5 5 7}]"; got != want {
+		t.Errorf("DiffTokens(%q, %q) = %v, want %v", a, b, got, want)
+	}
+}
+
 func BenchmarkTwoOld(b *testing.B) {
 	tests := genBench(rng(b), "abc", 96)
 	for i := 0; i < b.N; i++ {
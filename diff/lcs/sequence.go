@@ -45,6 +45,16 @@ func (s runesSeqs) commonSuffixLen(ai, aj, bi, bj int) int {
 	return commonSuffixLenRunes(s.a[ai:aj:aj], s.b[bi:bj:bj])
 }
 
+type tokensSeqs struct{ a, b []string }
+
+func (s tokensSeqs) lengths() (int, int) { return len(s.a), len(s.b) }
+func (s tokensSeqs) commonPrefixLen(ai, aj, bi, bj int) int {
+	return commonPrefixLenTokens(s.a[ai:aj:aj], s.b[bi:bj:bj])
+}
+func (s tokensSeqs) commonSuffixLen(ai, aj, bi, bj int) int {
+	return commonSuffixLenTokens(s.a[ai:aj:aj], s.b[bi:bj:bj])
+}
+
 // TODO(adonovan): optimize these functions using ideas from:
 // - https://go.dev/cl/408116 common.go
 // - https://go.dev/cl/421435 xor_generic.go
@@ -69,6 +79,14 @@ func commonPrefixLenRunes(a, b []rune) int {
 	}
 	return i
 }
+func commonPrefixLenTokens(a, b []string) int {
+	n := min(len(a), len(b))
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
 func commonPrefixLenString(a, b string) int {
 	n := min(len(a), len(b))
 	i := 0
@@ -95,6 +113,14 @@ func commonSuffixLenRunes(a, b []rune) int {
 	}
 	return i
 }
+func commonSuffixLenTokens(a, b []string) int {
+	n := min(len(a), len(b))
+	i := 0
+	for i < n && a[len(a)-1-i] == b[len(b)-1-i] {
+		i++
+	}
+	return i
+}
 func commonSuffixLenString(a, b string) int {
 	n := min(len(a), len(b))
 	i := 0
@@ -56,13 +56,13 @@ func Merge(x, y []Edit) ([]Edit, bool) {
 		} else if px.Start < py.Start {
 			// x is partly before y:
 			// split it into a deletion and an edit.
-			add(Edit{px.Start, py.Start, ""})
+			add(Edit{Start: px.Start, End: py.Start})
 			px.Start = py.Start
 
 		} else if py.Start < px.Start {
 			// y is partly before x:
 			// split it into a deletion and an edit.
-			add(Edit{py.Start, px.Start, ""})
+			add(Edit{Start: py.Start, End: px.Start})
 			py.Start = px.Start
 
 		} else {
@@ -0,0 +1,150 @@
+package diff
+
+import (
+	"slices"
+	"strings"
+)
+
+// minMoveLen is the shortest block of text, in bytes after collapsing
+// whitespace, that DetectMoves will report as moved. Short lines (headings,
+// list markers, "}") turn up on both sides of a diff by coincidence all the
+// time.
+const minMoveLen = 40
+
+// DetectMoves finds blocks of text that edits delete from one place in src
+// and insert somewhere else, unchanged apart from whitespace. It returns
+// equivalent, line-aligned edits in which each such block is split out into a
+// MoveOut edit where it was deleted and a MoveIn edit where it was inserted,
+// which point at each other through Move. Applying the result gives the same
+// output as applying edits.
+//
+// A block is a paragraph: a run of non-blank lines, plus any blank lines
+// after it. Moves are only found between different edits, so edits from a
+// line-based diff like Lines work best; character-level diffs tend to lump a
+// moved paragraph and the text it moved past into a single edit.
+//
+// It returns an error if the edits are inconsistent; see Apply.
+func DetectMoves(src string, edits []Edit) ([]Edit, error) {
+	edits, err := lineEdits(src, edits)
+	if err != nil {
+		return nil, err
+	}
+
+	// Index the deleted blocks by their normalized text.
+	type block struct {
+		edit       int
+		start, end int // offsets in src
+	}
+	deleted := make(map[string][]block)
+	for i, e := range edits {
+		for _, b := range paragraphs(src[e.Start:e.End]) {
+			if key := normalizeBlock(src[e.Start+b[0] : e.Start+b[1]]); len(key) >= minMoveLen {
+				deleted[key] = append(deleted[key], block{i, e.Start + b[0], e.Start + b[1]})
+			}
+		}
+	}
+	if len(deleted) == 0 {
+		return edits, nil
+	}
+
+	// Pair each inserted block with the first matching deletion from a
+	// different edit.
+	type insertion struct {
+		start, end int // offsets in the edit's New text
+		from       int // Start of the matching MoveOut edit
+	}
+	outs := make(map[int][]Edit)     // edit index -> MoveOut edits
+	ins := make(map[int][]insertion) // edit index -> moved insertions
+	for i, e := range edits {
+		for _, b := range paragraphs(e.New) {
+			key := normalizeBlock(e.New[b[0]:b[1]])
+			cands := deleted[key]
+			j := slices.IndexFunc(cands, func(d block) bool { return d.edit != i })
+			if j < 0 {
+				continue
+			}
+			d := cands[j]
+			deleted[key] = slices.Delete(cands, j, j+1)
+
+			// Insertions are split out to the end of their edit (see below), so
+			// that's where the moved text ends up.
+			outs[d.edit] = append(outs[d.edit], Edit{Start: d.start, End: d.end, Kind: MoveOut, Move: e.End})
+			ins[i] = append(ins[i], insertion{b[0], b[1], d.start})
+		}
+	}
+	if len(outs) == 0 {
+		return edits, nil
+	}
+
+	// Rebuild the edits that took part in a move as a series of deletions
+	// followed by a series of insertions.
+	var res []Edit
+	for i, e := range edits {
+		if len(outs[i]) == 0 && len(ins[i]) == 0 {
+			res = append(res, e)
+			continue
+		}
+
+		moved := outs[i]
+		SortEdits(moved)
+		last := e.Start
+		for _, m := range moved {
+			if last < m.Start {
+				res = append(res, Edit{Start: last, End: m.Start})
+			}
+			res = append(res, m)
+			last = m.End
+		}
+		if last < e.End {
+			res = append(res, Edit{Start: last, End: e.End})
+		}
+
+		lastNew := 0
+		for _, in := range ins[i] {
+			if lastNew < in.start {
+				res = append(res, Edit{Start: e.End, End: e.End, New: e.New[lastNew:in.start]})
+			}
+			res = append(res, Edit{Start: e.End, End: e.End, New: e.New[in.start:in.end], Kind: MoveIn, Move: in.from})
+			lastNew = in.end
+		}
+		if lastNew < len(e.New) {
+			res = append(res, Edit{Start: e.End, End: e.End, New: e.New[lastNew:]})
+		}
+	}
+	return res, nil
+}
+
+// paragraphs returns the [start, end) offsets of each paragraph in text: a
+// run of non-blank lines, along with any blank lines that follow it.
+func paragraphs(text string) [][2]int {
+	var (
+		out       [][2]int
+		start     = -1
+		pos       = 0
+		prevBlank = false
+	)
+	for _, l := range splitLines(text) {
+		blank := strings.TrimSpace(l) == ""
+		if !blank {
+			if start >= 0 && prevBlank {
+				out = append(out, [2]int{start, pos})
+				start = -1
+			}
+			if start < 0 {
+				start = pos
+			}
+		}
+		prevBlank = blank
+		pos += len(l)
+	}
+	if start >= 0 {
+		out = append(out, [2]int{start, pos})
+	}
+	return out
+}
+
+// normalizeBlock collapses whitespace in s, so that reflowed or reindented
+// text still counts as unchanged.
+func normalizeBlock(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
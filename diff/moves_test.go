@@ -0,0 +1,109 @@
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bcspragu/fineprint/diff"
+)
+
+const (
+	paraA = "We collect your email address when you sign up for an account.\n"
+	paraB = "We may share aggregated usage data with our advertising partners.\n"
+	paraC = "You can delete your account at any time from the settings page.\n"
+)
+
+func TestDetectMoves(t *testing.T) {
+	before := paraA + "\n" + paraB + "\n" + paraC
+	after := paraB + "\n" + paraA + "\n" + paraC
+
+	edits, err := diff.DetectMoves(before, diff.Lines(before, after))
+	if err != nil {
+		t.Fatalf("DetectMoves: %v", err)
+	}
+	if got, err := diff.Apply(before, edits); err != nil || got != after {
+		t.Fatalf("Apply(DetectMoves()) = %q, %v, want %q", got, err, after)
+	}
+
+	var out, in *diff.Edit
+	for i, e := range edits {
+		switch e.Kind {
+		case diff.MoveOut:
+			out = &edits[i]
+		case diff.MoveIn:
+			in = &edits[i]
+		}
+	}
+	if out == nil || in == nil {
+		t.Fatalf("DetectMoves() = %v, want a move-out and move-in pair", edits)
+	}
+	if strings.TrimSpace(before[out.Start:out.End]) != strings.TrimSpace(in.New) {
+		t.Errorf("moved text differs: %q vs %q", before[out.Start:out.End], in.New)
+	}
+	if out.Move != in.Start || in.Move != out.Start {
+		t.Errorf("move edits don't point at each other: %v, %v", out, in)
+	}
+
+	unified, err := diff.ToUnified("a", "b", before, edits, 0)
+	if err != nil {
+		t.Fatalf("ToUnified: %v", err)
+	}
+	for _, l := range strings.Split(strings.TrimSpace(unified), "\n") {
+		if strings.HasPrefix(l, "-We") || strings.HasPrefix(l, "+We") {
+			t.Errorf("moved paragraph shown as a plain edit: %q\n%s", l, unified)
+		}
+	}
+	if !strings.Contains(unified, "<"+strings.TrimSuffix(paraA, "\n")) && !strings.Contains(unified, "<"+strings.TrimSuffix(paraB, "\n")) {
+		t.Errorf("unified diff has no moved-out lines:\n%s", unified)
+	}
+}
+
+func TestDetectMoves_Reflowed(t *testing.T) {
+	reflowed := strings.Replace(paraA, "address ", "address\n  ", 1)
+	before := paraA + "\n" + paraC
+	after := paraC + "\n" + reflowed
+
+	edits, err := diff.DetectMoves(before, diff.Lines(before, after))
+	if err != nil {
+		t.Fatalf("DetectMoves: %v", err)
+	}
+	if got, err := diff.Apply(before, edits); err != nil || got != after {
+		t.Fatalf("Apply(DetectMoves()) = %q, %v, want %q", got, err, after)
+	}
+	found := false
+	for _, e := range edits {
+		if e.Kind == diff.MoveIn && e.New == reflowed {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("DetectMoves() = %v, want the reflowed paragraph to be moved in", edits)
+	}
+}
+
+func TestDetectMoves_NoMoves(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+	}{
+		{"rewritten", paraA + "\n" + paraB, paraA + "\n" + strings.ToUpper(paraB)},
+		{"short lines", "# Terms\n\nHello\n\n# Privacy\n", "# Privacy\n\nHello\n\n# Terms\n"},
+		{"deleted", paraA + "\n" + paraB, paraA},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			edits, err := diff.DetectMoves(test.before, diff.Lines(test.before, test.after))
+			if err != nil {
+				t.Fatalf("DetectMoves: %v", err)
+			}
+			if got, err := diff.Apply(test.before, edits); err != nil || got != test.after {
+				t.Fatalf("Apply(DetectMoves()) = %q, %v, want %q", got, err, test.after)
+			}
+			for _, e := range edits {
+				if e.Kind != diff.Replace {
+					t.Errorf("unexpected move %v", e)
+				}
+			}
+		})
+	}
+}
@@ -44,7 +44,7 @@ func diffASCII(before, after []byte) []Edit {
 	// Convert from LCS diffs.
 	res := make([]Edit, len(diffs))
 	for i, d := range diffs {
-		res[i] = Edit{d.Start, d.End, string(after[d.ReplStart:d.ReplEnd])}
+		res[i] = Edit{Start: d.Start, End: d.End, New: string(after[d.ReplStart:d.ReplEnd])}
 	}
 	return res
 }
@@ -62,7 +62,7 @@ func diffRunes(before, after []rune) []Edit {
 		utf8Len += runesLen(before[lastEnd:d.Start]) // text between edits
 		start := utf8Len
 		utf8Len += runesLen(before[d.Start:d.End]) // text deleted by this edit
-		res[i] = Edit{start, utf8Len, string(after[d.ReplStart:d.ReplEnd])}
+		res[i] = Edit{Start: start, End: utf8Len, New: string(after[d.ReplStart:d.ReplEnd])}
 		lastEnd = d.End
 	}
 	return res
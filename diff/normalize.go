@@ -0,0 +1,237 @@
+package diff
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// A Normalization is a set of rules for folding away differences in text
+// that don't change what it says, like curly versus straight quotes. Two
+// copies of the same page (say, a live one and one from an archive) often
+// differ in these ways, which would otherwise show up as noise in a diff.
+type Normalization uint
+
+const (
+	// FoldQuotes turns curly quotes and primes into straight ones.
+	FoldQuotes Normalization = 1 << iota
+	// FoldDashes turns hyphens, en and em dashes and minus signs into "-".
+	FoldDashes
+	// FoldSpaces turns non-breaking and other Unicode spaces into " ".
+	FoldSpaces
+	// RemoveSoftHyphens removes soft hyphens (U+00AD).
+	RemoveSoftHyphens
+	// RemoveZeroWidth removes zero-width spaces, joiners and byte order marks.
+	RemoveZeroWidth
+	// CollapseWhitespace turns runs of spaces within a line into a single
+	// space, removes trailing spaces, and turns runs of blank lines into a
+	// single blank line. Indentation is left alone, since it's meaningful in
+	// Markdown.
+	CollapseWhitespace
+	// UnwrapLines joins lines of a paragraph that were wrapped with single
+	// line breaks, unless the next line starts a new Markdown block (a list
+	// item, heading, quote, table row or code fence). It only applies along
+	// with CollapseWhitespace.
+	UnwrapLines
+
+	// DefaultNormalization applies all of the rules above.
+	DefaultNormalization = FoldQuotes | FoldDashes | FoldSpaces | RemoveSoftHyphens | RemoveZeroWidth | CollapseWhitespace | UnwrapLines
+)
+
+// StringsNormalized is like Strings, but ignores differences that norm folds
+// away. See Normalized.
+func StringsNormalized(before, after string, norm Normalization) []Edit {
+	return Normalized(before, after, norm, Strings)
+}
+
+// Normalized computes the differences between before and after using
+// diffFunc (e.g. Strings or Lines), but on their normalized forms, so that
+// differences that norm folds away aren't reported. The edits are mapped back
+// to before: Start and End are offsets in before, and New is taken verbatim
+// from after. Text that's only different in insignificant ways is treated as
+// unchanged, so applying the edits to before gives a text that's equivalent
+// to after under norm, but not necessarily identical to it.
+func Normalized(before, after string, norm Normalization, diffFunc func(before, after string) []Edit) []Edit {
+	nb, beforeOffsets := Normalize(before, norm)
+	na, afterOffsets := Normalize(after, norm)
+
+	edits := diffFunc(nb, na)
+	res := make([]Edit, len(edits))
+	delta := 0 // offset in na minus offset in nb
+	for i, e := range edits {
+		newStart := e.Start + delta
+		newEnd := newStart + len(e.New)
+		delta += len(e.New) - (e.End - e.Start)
+
+		res[i] = Edit{
+			Start: beforeOffsets[e.Start],
+			End:   beforeOffsets[e.End],
+			New:   after[afterOffsets[newStart]:afterOffsets[newEnd]],
+		}
+	}
+	return res
+}
+
+// Normalize returns s with the rules in norm applied, along with a map from
+// byte offsets in the result to byte offsets in s. The map has one more entry
+// than the result has bytes, so that the end of the result maps to the end of
+// s. Every offset in the map falls on a rune boundary in s.
+func Normalize(s string, norm Normalization) (string, []int) {
+	n := &normalizer{norm: norm, src: s}
+	n.out.Grow(len(s))
+	n.offsets = make([]int, 0, len(s)+1)
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if norm&CollapseWhitespace != 0 && n.isSpace(r) {
+			i = n.whitespace(i)
+			continue
+		}
+		n.emit(n.fold(r), i)
+		i += size
+	}
+
+	n.offsets = append(n.offsets, len(s))
+	return n.out.String(), n.offsets
+}
+
+type normalizer struct {
+	norm    Normalization
+	src     string
+	out     strings.Builder
+	offsets []int
+}
+
+// emit appends s to the output, mapping each of its bytes to offset at in
+// the source.
+func (n *normalizer) emit(s string, at int) {
+	for range len(s) {
+		n.offsets = append(n.offsets, at)
+	}
+	n.out.WriteString(s)
+}
+
+// fold returns what r turns into under n.norm.
+func (n *normalizer) fold(r rune) string {
+	switch {
+	case n.norm&FoldQuotes != 0 && strings.ContainsRune("\u2018\u2019\u201a\u201b\u2032", r):
+		return "'"
+	case n.norm&FoldQuotes != 0 && strings.ContainsRune("\u201c\u201d\u201e\u201f\u2033", r):
+		return `"`
+	case n.norm&FoldDashes != 0 && strings.ContainsRune("\u2010\u2011\u2012\u2013\u2014\u2015\u2212", r):
+		return "-"
+	case n.norm&FoldSpaces != 0 && isUnicodeSpace(r):
+		return " "
+	case n.norm&RemoveSoftHyphens != 0 && r == '\u00ad':
+		return ""
+	case n.norm&RemoveZeroWidth != 0 && strings.ContainsRune("\u200b\u200c\u200d\u2060\ufeff", r):
+		return ""
+	}
+	return string(r)
+}
+
+// isSpace reports whether r is whitespace for the purposes of
+// CollapseWhitespace.
+func (n *normalizer) isSpace(r rune) bool {
+	switch r {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	}
+	return n.norm&FoldSpaces != 0 && isUnicodeSpace(r)
+}
+
+func isUnicodeSpace(r rune) bool {
+	switch {
+	case r == '\u00a0', r == '\u202f', r == '\u205f', r == '\u3000':
+		return true
+	case '\u2000' <= r && r <= '\u200a':
+		return true
+	}
+	return false
+}
+
+// whitespace normalizes the run of whitespace starting at offset i, and
+// returns the offset just past it.
+func (n *normalizer) whitespace(i int) int {
+	s := n.src
+	j, newlines, firstNewline, lastNewline := i, 0, -1, -1
+	for j < len(s) {
+		r, size := utf8.DecodeRuneInString(s[j:])
+		if !n.isSpace(r) {
+			break
+		}
+		if r == '\n' {
+			if newlines == 0 {
+				firstNewline = j
+			}
+			newlines++
+			lastNewline = j
+		}
+		j += size
+	}
+
+	atLineStart := i == 0 || s[i-1] == '\n'
+	switch {
+	case j == len(s):
+		// Trailing whitespace is dropped, apart from a final line break.
+		if newlines > 0 {
+			n.emit("\n", lastNewline)
+		}
+	case newlines == 0 && atLineStart:
+		n.emit(n.indent(s[i:j]), i)
+	case newlines == 0:
+		n.emit(" ", i)
+	case newlines == 1 && n.norm&UnwrapLines != 0 && n.wrapped(i, j):
+		n.emit(" ", i)
+	default:
+		// Line breaks are mapped to the line breaks in s, rather than to any
+		// trailing spaces before them.
+		if newlines > 1 {
+			n.emit("\n", firstNewline)
+		}
+		n.emit("\n", lastNewline)
+		// Keep the indentation of the next line, mapped to the start of that
+		// line so that line-based edits stay aligned.
+		n.emit(n.indent(s[lastNewline+1:j]), lastNewline+1)
+	}
+	return j
+}
+
+// indent returns the indentation ws, with any Unicode spaces folded.
+func (n *normalizer) indent(ws string) string {
+	var sb strings.Builder
+	for _, r := range ws {
+		if r == '\r' {
+			continue
+		}
+		sb.WriteString(n.fold(r))
+	}
+	return sb.String()
+}
+
+// wrapped reports whether the single line break in the whitespace at s[i:j]
+// just wraps a paragraph, i.e. neither the line before it nor the line after
+// it is a Markdown block of its own.
+func (n *normalizer) wrapped(i, j int) bool {
+	s := n.src
+	prev := s[strings.LastIndexByte(s[:i], '\n')+1 : i]
+	next := s[j:]
+	if nl := strings.IndexByte(next, '\n'); nl >= 0 {
+		next = next[:nl]
+	}
+	return !startsMarkdownBlock(prev) && !startsMarkdownBlock(next)
+}
+
+// startsMarkdownBlock reports whether line (ignoring indentation) starts a
+// Markdown block that a wrapped line can't be joined to or from.
+func startsMarkdownBlock(line string) bool {
+	line = strings.TrimLeft(line, " \t")
+	for _, prefix := range []string{"- ", "* ", "+ ", "#", ">", "|", "```", "---"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	// Ordered list items, like "1. " or "12) ".
+	digits := len(line) - len(strings.TrimLeft(line, "0123456789"))
+	rest := line[digits:]
+	return digits > 0 && (strings.HasPrefix(rest, ". ") || strings.HasPrefix(rest, ") "))
+}
@@ -0,0 +1,87 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/bcspragu/fineprint/diff"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		norm diff.Normalization
+		want string
+	}{
+		{"quotes", "We’re “here”", diff.FoldQuotes, `We're "here"`},
+		{"dashes", "2020–2024 — done", diff.FoldDashes, "2020-2024 - done"},
+		{"spaces", "Section\u00a04", diff.FoldSpaces, "Section 4"},
+		{"soft hyphens", "pri\u00advacy", diff.RemoveSoftHyphens, "privacy"},
+		{"zero width", "\ufeffda\u200bta", diff.RemoveZeroWidth, "data"},
+		{"disabled", "We’re", diff.FoldDashes, "We’re"},
+		{"collapse", "a  b\t c  \n\n\n\n  d  \n", diff.CollapseWhitespace, "a b c\n\n  d\n"},
+		{"crlf", "a\r\nb\r\n", diff.CollapseWhitespace, "a\nb\n"},
+		{"no unwrap", "one\ntwo", diff.CollapseWhitespace, "one\ntwo"},
+		{"unwrap", "one\ntwo\n\nthree", diff.CollapseWhitespace | diff.UnwrapLines, "one two\n\nthree"},
+		{"unwrap keeps lists", "Intro:\n- one\n- two\n1. three", diff.CollapseWhitespace | diff.UnwrapLines, "Intro:\n- one\n- two\n1. three"},
+		{"unwrap keeps headings", "# Title\nText", diff.CollapseWhitespace | diff.UnwrapLines, "# Title\nText"},
+		{"default", "We’ll  never\u00a0sell\u200b\nyour data — ever.", diff.DefaultNormalization, "We'll never sell your data - ever."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, offsets := diff.Normalize(test.in, test.norm)
+			if got != test.want {
+				t.Errorf("Normalize(%q) = %q, want %q", test.in, got, test.want)
+			}
+			if len(offsets) != len(got)+1 {
+				t.Fatalf("Normalize(%q) returned %d offsets, want %d", test.in, len(offsets), len(got)+1)
+			}
+			for i := 1; i < len(offsets); i++ {
+				if offsets[i] < offsets[i-1] {
+					t.Errorf("offsets aren't increasing at %d: %v", i, offsets)
+				}
+			}
+			if offsets[len(got)] != len(test.in) {
+				t.Errorf("final offset = %d, want %d", offsets[len(got)], len(test.in))
+			}
+		})
+	}
+}
+
+func TestStringsNormalized(t *testing.T) {
+	before := "We don’t sell your\u00a0data.\nWe keep it for 30 days."
+	after := "We don't sell your data.\nWe keep it for 90 days."
+
+	edits := diff.StringsNormalized(before, after, diff.DefaultNormalization)
+	if len(edits) != 1 {
+		t.Fatalf("StringsNormalized() = %v, want a single edit", edits)
+	}
+	got, err := diff.Apply(before, edits)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if want := "We don’t sell your\u00a0data.\nWe keep it for 90 days."; got != want {
+		t.Errorf("Apply(StringsNormalized()) = %q, want %q", got, want)
+	}
+}
+
+func TestNormalized_Lines(t *testing.T) {
+	before := "Intro text.\n\nWe “may” share data.\n\nOutro.\n"
+	after := "Intro text.\n\nWe \"may\" share data.\n\nA new paragraph.\n\nOutro.\n"
+
+	edits := diff.Normalized(before, after, diff.DefaultNormalization, diff.Lines)
+	got, err := diff.Apply(before, edits)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if want := "Intro text.\n\nWe “may” share data.\n\nA new paragraph.\n\nOutro.\n"; got != want {
+		t.Errorf("Apply(Normalized()) = %q, want %q", got, want)
+	}
+	unified, err := diff.ToUnified("a", "b", before, edits, 0)
+	if err != nil {
+		t.Fatalf("ToUnified: %v", err)
+	}
+	if want := "--- a\n+++ b\n@@ -4 +4,2 @@\n+\n+A new paragraph.\n"; unified != want {
+		t.Errorf("ToUnified(Normalized()) =\n%s\nwant\n%s", unified, want)
+	}
+}
@@ -0,0 +1,132 @@
+package diff
+
+import (
+	"fmt"
+
+	"github.com/bcspragu/fineprint/diff/lcs"
+)
+
+// An Algorithm is a method of computing the differences between two texts.
+type Algorithm int
+
+const (
+	// LCS finds a small (though not always minimal) set of character-level
+	// edits, and is what Strings uses.
+	LCS Algorithm = iota
+	// Patience diffs line by line, anchoring on lines that appear exactly once
+	// in each text. Those are usually the distinctive ones, so it lines up
+	// documents with lots of repeated boilerplate better than LCS does, at the
+	// cost of sometimes larger diffs.
+	Patience
+	// Histogram is a refinement of Patience (from JGit, and git's default for
+	// "diff --histogram") that anchors on the least common lines, rather than
+	// only unique ones, so it still does well when no line is unique.
+	Histogram
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case LCS:
+		return "lcs"
+	case Patience:
+		return "patience"
+	case Histogram:
+		return "histogram"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", int(a))
+	}
+}
+
+// Options configure how differences are computed.
+type Options struct {
+	// Algorithm is the diff algorithm to use. The zero value is LCS.
+	Algorithm Algorithm
+}
+
+// StringsWithOptions is like Strings, but computes the differences as
+// configured by opts. The line-based algorithms (Patience and Histogram)
+// produce edits that replace whole lines.
+func StringsWithOptions(before, after string, opts Options) []Edit {
+	if before == after {
+		return nil
+	}
+	switch opts.Algorithm {
+	case Patience:
+		return lineDiff(before, after, (*matcher).patience)
+	case Histogram:
+		return lineDiff(before, after, (*matcher).histogram)
+	default:
+		return Strings(before, after)
+	}
+}
+
+// lineDiff computes the line-based differences between before and after with
+// the given matching strategy.
+func lineDiff(before, after string, match func(m *matcher, a, b []string, aoff, boff int)) []Edit {
+	a, b := splitLines(before), splitLines(after)
+	m := &matcher{}
+	match(m, a, b, 0, 0)
+	return diffsToEdits(a, b, m.diffs(len(a), len(b)))
+}
+
+// A matcher accumulates pairs of equal lines between two texts, in increasing
+// order, as found by the line-based algorithms.
+type matcher struct {
+	matches [][2]int // index in a, index in b
+}
+
+// equal records that the n lines at a[ai:] and b[bi:] are equal.
+func (m *matcher) equal(ai, bi, n int) {
+	for k := range n {
+		m.matches = append(m.matches, [2]int{ai + k, bi + k})
+	}
+}
+
+// lcs matches a and b, which start at aoff and boff in the full texts, using
+// the LCS algorithm. The anchored algorithms fall back to this when they
+// can't find an anchor.
+func (m *matcher) lcs(a, b []string, aoff, boff int) {
+	lastA, lastB := 0, 0
+	for _, d := range lcs.DiffTokens(a, b) {
+		m.equal(aoff+lastA, boff+lastB, d.Start-lastA)
+		lastA, lastB = d.End, d.ReplEnd
+	}
+	m.equal(aoff+lastA, boff+lastB, len(a)-lastA)
+}
+
+// trim matches the common prefix and suffix of a and b, calls middle with
+// what's left in between, and then records the suffix, keeping matches in
+// order.
+func (m *matcher) trim(a, b []string, aoff, boff int, middle func(a, b []string, aoff, boff int)) {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	m.equal(aoff, boff, pre)
+	a, b = a[pre:], b[pre:]
+	aoff, boff = aoff+pre, boff+pre
+
+	suf := 0
+	for suf < len(a) && suf < len(b) && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	a, b = a[:len(a)-suf], b[:len(b)-suf]
+	if len(a) > 0 && len(b) > 0 {
+		middle(a, b, aoff, boff)
+	}
+	m.equal(aoff+len(a), boff+len(b), suf)
+}
+
+// diffs converts the matches into the differences between a and b, which
+// have alen and blen lines.
+func (m *matcher) diffs(alen, blen int) []lcs.Diff {
+	var out []lcs.Diff
+	prevA, prevB := -1, -1
+	for _, match := range append(m.matches, [2]int{alen, blen}) {
+		if match[0] > prevA+1 || match[1] > prevB+1 {
+			out = append(out, lcs.Diff{Start: prevA + 1, End: match[0], ReplStart: prevB + 1, ReplEnd: match[1]})
+		}
+		prevA, prevB = match[0], match[1]
+	}
+	return out
+}
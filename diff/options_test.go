@@ -0,0 +1,108 @@
+package diff_test
+
+import (
+	"log"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/bcspragu/fineprint/diff"
+	"github.com/bcspragu/fineprint/diff/difftest"
+)
+
+var algorithms = []diff.Algorithm{diff.LCS, diff.Patience, diff.Histogram}
+
+// TestLineAlgorithms checks the line-based algorithms against the same cases
+// as myers. (LCS isn't line-based, so its unified diffs differ; see
+// TestNEdits.)
+func TestLineAlgorithms(t *testing.T) {
+	for _, algo := range []diff.Algorithm{diff.Patience, diff.Histogram} {
+		t.Run(algo.String(), func(t *testing.T) {
+			difftest.DiffTest(t, func(before, after string) []diff.Edit {
+				return diff.StringsWithOptions(before, after, diff.Options{Algorithm: algo})
+			})
+		})
+	}
+}
+
+func TestAlgorithms_RepeatedBoilerplate(t *testing.T) {
+	before := `We may collect your name.
+You agree to these terms.
+
+We may share your name.
+You agree to these terms.
+`
+	after := `We may sell your name.
+You agree to these terms.
+
+We may collect your name.
+You agree to these terms.
+
+We may share your name.
+You agree to these terms.
+`
+	want := `--- a
++++ b
+@@ -0,0 +1,3 @@
++We may sell your name.
++You agree to these terms.
++
+`
+	for _, algo := range []diff.Algorithm{diff.Patience, diff.Histogram} {
+		t.Run(algo.String(), func(t *testing.T) {
+			edits := diff.StringsWithOptions(before, after, diff.Options{Algorithm: algo})
+			got, err := diff.ToUnified("a", "b", before, edits, 0)
+			if err != nil {
+				t.Fatalf("ToUnified: %v", err)
+			}
+			if got != want {
+				t.Errorf("got diff:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestAlgorithms_Random(t *testing.T) {
+	for _, algo := range algorithms {
+		for i := range 100 {
+			a := randlines("abcde", 1+i%30)
+			b := randlines("abcde", 1+(i*7)%30)
+			edits := diff.StringsWithOptions(a, b, diff.Options{Algorithm: algo})
+			got, err := diff.Apply(a, edits)
+			if err != nil {
+				t.Fatalf("%s: Apply failed: %v", algo, err)
+			}
+			if got != b {
+				t.Fatalf("%s: applying diff(%q, %q) gives %q; edits=%v", algo, a, b, got, edits)
+			}
+		}
+	}
+}
+
+// randlines returns n random lines, each a single character from alphabet.
+func randlines(alphabet string, n int) string {
+	var out []byte
+	for range n {
+		out = append(out, alphabet[rand.Intn(len(alphabet))], '\n')
+	}
+	return string(out)
+}
+
+func BenchmarkLargeFileSmallDiff(b *testing.B) {
+	data, err := os.ReadFile("lcs/old.go") // large file
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n := len(data)
+	src := string(data)
+	dst := src[:n*49/100] + src[n*51/100:] // remove 2% from the middle
+
+	for _, algo := range algorithms {
+		b.Run(algo.String(), func(b *testing.B) {
+			for b.Loop() {
+				diff.StringsWithOptions(src, dst, diff.Options{Algorithm: algo})
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package diff
+
+import "sort"
+
+// patience matches a and b using the patience diff algorithm: lines that
+// appear exactly once in both are matched up (keeping the longest run of them
+// that's in the same order in both), and the gaps between them are matched
+// recursively. See https://bramcohen.livejournal.com/73318.html.
+func (m *matcher) patience(a, b []string, aoff, boff int) {
+	m.trim(a, b, aoff, boff, m.patienceAnchors)
+}
+
+func (m *matcher) patienceAnchors(a, b []string, aoff, boff int) {
+	type count struct{ a, b, bi int }
+	counts := make(map[string]*count)
+	for _, l := range a {
+		c := counts[l]
+		if c == nil {
+			c = &count{}
+			counts[l] = c
+		}
+		c.a++
+	}
+	for j, l := range b {
+		if c := counts[l]; c != nil {
+			c.b++
+			c.bi = j
+		}
+	}
+
+	// Lines unique to both texts, in the order they appear in a, along with
+	// where they are in b.
+	var anchorsA, anchorsB []int
+	for i, l := range a {
+		if c := counts[l]; c.a == 1 && c.b == 1 {
+			anchorsA = append(anchorsA, i)
+			anchorsB = append(anchorsB, c.bi)
+		}
+	}
+	if len(anchorsA) == 0 {
+		m.lcs(a, b, aoff, boff)
+		return
+	}
+
+	lastA, lastB := 0, 0
+	for k, inOrder := range LongestIncreasing(anchorsB) {
+		if !inOrder {
+			continue
+		}
+		i, j := anchorsA[k], anchorsB[k]
+		m.patience(a[lastA:i], b[lastB:j], aoff+lastA, boff+lastB)
+		m.equal(aoff+i, boff+j, 1)
+		lastA, lastB = i+1, j+1
+	}
+	m.patience(a[lastA:], b[lastB:], aoff+lastA, boff+lastB)
+}
+
+// LongestIncreasing reports which elements of xs belong to a longest strictly
+// increasing subsequence.
+func LongestIncreasing(xs []int) []bool {
+	// Patience sorting: tails[k] is the index of the smallest tail of an
+	// increasing subsequence of length k+1.
+	var tails []int
+	prev := make([]int, len(xs))
+	for i, x := range xs {
+		k := sort.Search(len(tails), func(k int) bool { return xs[tails[k]] >= x })
+		if k > 0 {
+			prev[i] = tails[k-1]
+		} else {
+			prev[i] = -1
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+
+	in := make([]bool, len(xs))
+	if len(tails) == 0 {
+		return in
+	}
+	for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+		in[i] = true
+	}
+	return in
+}
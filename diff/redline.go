@@ -0,0 +1,121 @@
+package diff
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// ToRedline renders edits to content as a self-contained HTML page, in the
+// style of a legal redline: deleted text is struck through in red, inserted
+// text is underlined in green, and moved text (see DetectMoves) is marked in
+// blue. Unchanged stretches longer than 2*contextLines+1 lines are collapsed
+// behind a <details> element, leaving contextLines lines visible on either
+// side of each change. A negative contextLines never collapses anything.
+//
+// The page has no external resources or scripts, so it can be sent as an
+// email attachment or hosted as-is.
+//
+// ToRedline returns an error if the edits are out of bounds or overlap.
+func ToRedline(title, content string, edits []Edit, contextLines int) (string, error) {
+	edits, _, err := validate(content, edits)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, redlineHeader, html.EscapeString(title))
+	sb.WriteString(`<div class="redline">`)
+
+	if len(edits) == 0 {
+		sb.WriteString(`<p class="note">No changes.</p>`)
+	}
+
+	last := 0
+	for _, e := range edits {
+		writeUnchanged(&sb, content, last, e.Start, contextLines)
+
+		insClass, delClass := "", ""
+		switch e.Kind {
+		case MoveOut:
+			delClass = ` class="moved" title="Moved elsewhere, unchanged"`
+		case MoveIn:
+			insClass = ` class="moved" title="Moved from elsewhere, unchanged"`
+		}
+		if old := content[e.Start:e.End]; old != "" {
+			fmt.Fprintf(&sb, "<del%s>%s</del>", delClass, html.EscapeString(old))
+		}
+		if e.New != "" {
+			fmt.Fprintf(&sb, "<ins%s>%s</ins>", insClass, html.EscapeString(e.New))
+		}
+		last = e.End
+	}
+	if len(edits) > 0 {
+		writeUnchanged(&sb, content, last, len(content), contextLines)
+	}
+
+	sb.WriteString("</div>\n</body>\n</html>\n")
+	return sb.String(), nil
+}
+
+// writeUnchanged writes the unchanged text content[start:end], collapsing all
+// but contextLines lines at either end. The first stretch of text only keeps
+// context at its end (before the first edit), and the last only at its start.
+func writeUnchanged(sb *strings.Builder, content string, start, end, contextLines int) {
+	text := content[start:end]
+	if text == "" {
+		return
+	}
+	lines := splitLines(text)
+
+	// Lines that are partly changed don't count towards the context.
+	head, tail := contextLines, contextLines
+	if start > 0 && content[start-1] != '\n' {
+		head++
+	}
+	if !strings.HasSuffix(text, "\n") {
+		tail++
+	}
+	if start == 0 {
+		head = 0
+	}
+	if end == len(content) {
+		tail = 0
+	}
+	// Don't hide a single line behind a <details> element; it takes as much
+	// space as the line would.
+	if contextLines < 0 || len(lines) <= head+tail+1 {
+		sb.WriteString(html.EscapeString(text))
+		return
+	}
+
+	sb.WriteString(html.EscapeString(strings.Join(lines[:head], "")))
+	hidden := lines[head : len(lines)-tail]
+	fmt.Fprintf(sb, `<details><summary>%d unchanged lines</summary>%s</details>`,
+		len(hidden), html.EscapeString(strings.Join(hidden, "")))
+	sb.WriteString(html.EscapeString(strings.Join(lines[len(lines)-tail:], "")))
+}
+
+const redlineHeader = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>%[1]s</title>
+<style>
+body { font-family: Georgia, serif; line-height: 1.5; max-width: 50em; margin: 2em auto; padding: 0 1em; color: #1f2937; }
+.redline { white-space: pre-wrap; overflow-wrap: break-word; }
+ins { color: #166534; background: #dcfce7; text-decoration: underline; }
+del { color: #991b1b; background: #fee2e2; text-decoration: line-through; }
+ins.moved, del.moved { color: #1e40af; background: #dbeafe; }
+details { display: inline; }
+summary { display: block; cursor: pointer; color: #6b7280; font-family: sans-serif; font-size: 0.85em; margin: 0.5em 0; }
+.legend { font-family: sans-serif; font-size: 0.85em; color: #6b7280; border-bottom: 1px solid #e5e7eb; padding-bottom: 1em; margin-bottom: 1em; }
+h1 { font-size: 1.4em; }
+.note { font-family: sans-serif; color: #6b7280; }
+</style>
+</head>
+<body>
+<h1>%[1]s</h1>
+<div class="legend"><del>Deleted text</del> &middot; <ins>Inserted text</ins> &middot; <ins class="moved">Moved text</ins></div>
+`
@@ -0,0 +1,67 @@
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bcspragu/fineprint/diff"
+)
+
+func TestToRedline(t *testing.T) {
+	var lines []string
+	for i := range 20 {
+		lines = append(lines, "Unchanged line "+string(rune('a'+i))+".")
+	}
+	before := strings.Join(lines, "\n") + "\nWe may share <your> data.\n" + strings.Join(lines, "\n") + "\n"
+	after := strings.Replace(before, "may share", "will sell", 1)
+
+	got, err := diff.ToRedline("Privacy Policy & Terms", before, diff.Words(before, after), 2)
+	if err != nil {
+		t.Fatalf("ToRedline: %v", err)
+	}
+
+	for _, want := range []string{
+		"<title>Privacy Policy &amp; Terms</title>",
+		"<del>may</del><ins>will</ins> <del>share</del><ins>sell</ins> &lt;your&gt; data.",
+		"<details><summary>18 unchanged lines</summary>Unchanged line a.\n",
+		"Unchanged line s.\nUnchanged line t.\nWe ",
+		"<summary>18 unchanged lines</summary>Unchanged line c.\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("ToRedline() output is missing %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "<script") || strings.Contains(got, "<link") {
+		t.Errorf("ToRedline() output isn't self-contained:\n%s", got)
+	}
+}
+
+func TestToRedline_Moves(t *testing.T) {
+	before := paraA + "\n" + paraB + "\n" + paraC
+	after := paraB + "\n" + paraA + "\n" + paraC
+
+	edits, err := diff.DetectMoves(before, diff.Lines(before, after))
+	if err != nil {
+		t.Fatalf("DetectMoves: %v", err)
+	}
+	got, err := diff.ToRedline("Terms", before, edits, -1)
+	if err != nil {
+		t.Fatalf("ToRedline: %v", err)
+	}
+	if !strings.Contains(got, `<del class="moved"`) || !strings.Contains(got, `<ins class="moved"`) {
+		t.Errorf("ToRedline() doesn't mark moves, got:\n%s", got)
+	}
+	if strings.Contains(got, "<details>") {
+		t.Errorf("ToRedline() collapsed context with contextLines < 0, got:\n%s", got)
+	}
+}
+
+func TestToRedline_NoChanges(t *testing.T) {
+	got, err := diff.ToRedline("Terms", "Same text.", nil, 3)
+	if err != nil {
+		t.Fatalf("ToRedline: %v", err)
+	}
+	if !strings.Contains(got, "No changes.") {
+		t.Errorf("ToRedline() = %s, want a no changes note", got)
+	}
+}
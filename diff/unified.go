@@ -81,6 +81,10 @@ const (
 	// opEqual is the operation kind for a line that is the same in the input and
 	// output, often used to provide context around edited lines.
 	opEqual
+	// opMoveOut is like opDelete, for a line that was moved elsewhere.
+	opMoveOut
+	// opMoveIn is like opInsert, for a line that was moved from elsewhere.
+	opMoveIn
 )
 
 // String returns a human readable representation of an OpKind. It is not
@@ -93,6 +97,10 @@ func (k opKind) String() string {
 		return "insert"
 	case opEqual:
 		return "equal"
+	case opMoveOut:
+		return "move-out"
+	case opMoveIn:
+		return "move-in"
 	default:
 		panic("unknown operation kind")
 	}
@@ -151,13 +159,20 @@ func toUnified(fromName, toName string, content string, edits []Edit, contextLin
 			h.toLine -= delta
 		}
 		last = start
+		delKind, insKind := opDelete, opInsert
+		switch edit.Kind {
+		case MoveOut:
+			delKind = opMoveOut
+		case MoveIn:
+			insKind = opMoveIn
+		}
 		for i := start; i < end; i++ {
-			h.lines = append(h.lines, line{kind: opDelete, content: lines[i]})
+			h.lines = append(h.lines, line{kind: delKind, content: lines[i]})
 			last++
 		}
 		if edit.New != "" {
 			for _, content := range splitLines(edit.New) {
-				h.lines = append(h.lines, line{kind: opInsert, content: content})
+				h.lines = append(h.lines, line{kind: insKind, content: content})
 				toLine++
 			}
 		}
@@ -194,7 +209,10 @@ func addEqualLines(h *hunk, lines []string, start, end int) int {
 }
 
 // String converts a unified diff to the standard textual form for that diff.
-// The output of this function can be passed to tools like patch.
+// The output of this function can be passed to tools like patch, unless the
+// edits include moves (see DetectMoves): moved lines are marked with "<"
+// where they were removed and ">" where they were inserted, instead of "-"
+// and "+".
 func (u unified) String() string {
 	if len(u.hunks) == 0 {
 		return ""
@@ -206,9 +224,9 @@ func (u unified) String() string {
 		fromCount, toCount := 0, 0
 		for _, l := range hunk.lines {
 			switch l.kind {
-			case opDelete:
+			case opDelete, opMoveOut:
 				fromCount++
-			case opInsert:
+			case opInsert, opMoveIn:
 				toCount++
 			default:
 				fromCount++
@@ -239,6 +257,10 @@ func (u unified) String() string {
 				fmt.Fprintf(b, "-%s", l.content)
 			case opInsert:
 				fmt.Fprintf(b, "+%s", l.content)
+			case opMoveOut:
+				fmt.Fprintf(b, "<%s", l.content)
+			case opMoveIn:
+				fmt.Fprintf(b, ">%s", l.content)
 			default:
 				fmt.Fprintf(b, " %s", l.content)
 			}
@@ -0,0 +1,324 @@
+package diff
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bcspragu/fineprint/diff/lcs"
+)
+
+// Words computes the differences between two strings a word at a time,
+// which is usually what you want for prose: an edit never starts or ends in
+// the middle of a word. Runs of whitespace and individual punctuation
+// characters are units of their own, so "data." to "data," is a one character
+// edit rather than a whole-word one.
+func Words(before, after string) []Edit {
+	if before == after {
+		return nil
+	}
+	return tokenEdits(SplitWords(before), SplitWords(after))
+}
+
+// Sentences computes the differences between two strings a sentence at a
+// time. Any change to a sentence replaces the whole sentence, which makes for
+// coarse but readable diffs of heavily edited text.
+func Sentences(before, after string) []Edit {
+	if before == after {
+		return nil
+	}
+	return tokenEdits(SplitSentences(before), SplitSentences(after))
+}
+
+// Lines computes the differences between two strings a line at a time. Unlike
+// Strings, which finds the smallest edits and can match up stray characters
+// across unrelated lines, every edit replaces whole lines.
+func Lines(before, after string) []Edit {
+	if before == after {
+		return nil
+	}
+	return tokenEdits(splitLines(before), splitLines(after))
+}
+
+// tokenEdits diffs two token sequences and converts the result into byte
+// offsets within the concatenation of before.
+func tokenEdits(before, after []string) []Edit {
+	return diffsToEdits(before, after, lcs.DiffTokens(before, after))
+}
+
+// diffsToEdits converts diffs between two token sequences into edits, with
+// byte offsets within the concatenation of before.
+func diffsToEdits(before, after []string, diffs []lcs.Diff) []Edit {
+	res := make([]Edit, len(diffs))
+	lastEnd := 0
+	offset := 0
+	for i, d := range diffs {
+		offset += tokensLen(before[lastEnd:d.Start]) // text between edits
+		start := offset
+		offset += tokensLen(before[d.Start:d.End]) // text deleted by this edit
+		res[i] = Edit{Start: start, End: offset, New: strings.Join(after[d.ReplStart:d.ReplEnd], "")}
+		lastEnd = d.End
+	}
+	return res
+}
+
+// tokensLen returns the total length in bytes of toks.
+func tokensLen(toks []string) int {
+	n := 0
+	for _, t := range toks {
+		n += len(t)
+	}
+	return n
+}
+
+// SplitWords splits s into words (runs of letters, digits and combining
+// marks, including inner apostrophes as in "don't"), runs of whitespace, and
+// single punctuation characters. Joining the result gives back s.
+func SplitWords(s string) []string {
+	var toks []string
+	for s != "" {
+		r, size := utf8.DecodeRuneInString(s)
+		n := size
+		switch {
+		case isWordRune(r):
+			for n < len(s) {
+				r, size := utf8.DecodeRuneInString(s[n:])
+				if isWordRune(r) {
+					n += size
+					continue
+				}
+				// Apostrophes only belong to the word if a letter follows.
+				if r == '\'' || r == '’' {
+					if next, _ := utf8.DecodeRuneInString(s[n+size:]); unicode.IsLetter(next) {
+						n += size
+						continue
+					}
+				}
+				break
+			}
+		case unicode.IsSpace(r):
+			for n < len(s) {
+				r, size := utf8.DecodeRuneInString(s[n:])
+				if !unicode.IsSpace(r) {
+					break
+				}
+				n += size
+			}
+		}
+		toks = append(toks, s[:n])
+		s = s[n:]
+	}
+	return toks
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r)
+}
+
+// SplitSentences splits s into sentences, each including its trailing
+// whitespace. A sentence ends at a line break, or at ".", "!" or "?" (plus any
+// closing quotes or brackets) followed by whitespace and something that looks
+// like the start of a new sentence. Joining the result gives back s.
+//
+// This is a heuristic: abbreviations like "e.g. Google" end a sentence early,
+// which only makes a diff slightly more fine-grained than it needs to be.
+func SplitSentences(s string) []string {
+	var toks []string
+	start, i := 0, 0
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r != '\n' && r != '.' && r != '!' && r != '?' {
+			continue
+		}
+
+		end := i
+		if r != '\n' {
+			end = skipRunes(s, end, isCloser)
+			if end < len(s) {
+				if next, _ := utf8.DecodeRuneInString(s[end:]); !unicode.IsSpace(next) {
+					continue
+				}
+			}
+		}
+		ws := end
+		end = skipRunes(s, end, unicode.IsSpace)
+		if r != '\n' && end < len(s) && !strings.Contains(s[ws:end], "\n") {
+			if next, _ := utf8.DecodeRuneInString(s[end:]); !startsSentence(next) {
+				continue
+			}
+		}
+
+		toks = append(toks, s[start:end])
+		start, i = end, end
+	}
+	if start < len(s) {
+		toks = append(toks, s[start:])
+	}
+	return toks
+}
+
+// skipRunes returns the offset of the first rune in s at or after i that
+// doesn't satisfy f.
+func skipRunes(s string, i int, f func(rune) bool) int {
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !f(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+func isCloser(r rune) bool {
+	return strings.ContainsRune(`"')]*_”’»`, r)
+}
+
+func startsSentence(r rune) bool {
+	return unicode.IsUpper(r) || unicode.IsDigit(r) || strings.ContainsRune(`"'([*_“‘«`, r)
+}
+
+// ToWordDiff renders edits to content inline, in the style of
+// "git diff --word-diff": removed text is shown as [-old-] and inserted text
+// as {+new+}, in place. Only contextWords words of unchanged text are kept on
+// either side of each edit, with "..." marking what was left out; a negative
+// contextWords keeps all of it.
+//
+// Edits separated by nothing but whitespace and punctuation are shown as one,
+// covering the whole words on either side, so "address and" to "address,
+// location and" reads [-address and-]{+address, location and+} rather than
+// address{+,+} {+location +}and.
+//
+// ToWordDiff returns an error if the edits are out of bounds or overlap.
+func ToWordDiff(content string, edits []Edit, contextWords int) (string, error) {
+	edits, _, err := validate(content, edits)
+	if err != nil {
+		return "", err
+	}
+	if len(edits) == 0 {
+		return content, nil
+	}
+	edits = mergeNearbyEdits(content, edits)
+
+	var sb strings.Builder
+	last := 0
+	for i, e := range edits {
+		between := content[last:e.Start]
+		if i == 0 {
+			sb.WriteString(leadingContext(between, contextWords))
+		} else {
+			sb.WriteString(middleContext(between, contextWords))
+		}
+		if old := content[e.Start:e.End]; old != "" {
+			sb.WriteString("[-" + old + "-]")
+		}
+		if e.New != "" {
+			sb.WriteString("{+" + e.New + "+}")
+		}
+		last = e.End
+	}
+	sb.WriteString(trailingContext(content[last:], contextWords))
+	return sb.String(), nil
+}
+
+// leadingContext returns the last n words of s, i.e. the context leading up
+// to an edit.
+func leadingContext(s string, n int) string {
+	fields := strings.Fields(s)
+	if n < 0 || len(fields) <= n {
+		return s
+	}
+	if n == 0 {
+		return "..." + trailingSpace(s)
+	}
+	return "... " + strings.Join(fields[len(fields)-n:], " ") + trailingSpace(s)
+}
+
+// trailingContext returns the first n words of s, i.e. the context trailing
+// an edit.
+func trailingContext(s string, n int) string {
+	fields := strings.Fields(s)
+	if n < 0 || len(fields) <= n {
+		return s
+	}
+	if n == 0 {
+		return leadingSpace(s) + "..."
+	}
+	return leadingSpace(s) + strings.Join(fields[:n], " ") + " ..."
+}
+
+// middleContext returns the context between two edits: n words after the
+// first and n words before the second.
+func middleContext(s string, n int) string {
+	fields := strings.Fields(s)
+	if n < 0 || len(fields) <= 2*n {
+		return s
+	}
+	if n == 0 {
+		return leadingSpace(s) + "..." + trailingSpace(s)
+	}
+	return leadingSpace(s) +
+		strings.Join(fields[:n], " ") + " ... " + strings.Join(fields[len(fields)-n:], " ") +
+		trailingSpace(s)
+}
+
+func leadingSpace(s string) string {
+	if r, _ := utf8.DecodeRuneInString(s); s != "" && unicode.IsSpace(r) {
+		return " "
+	}
+	return ""
+}
+
+func trailingSpace(s string) string {
+	if r, _ := utf8.DecodeLastRuneInString(s); s != "" && unicode.IsSpace(r) {
+		return " "
+	}
+	return ""
+}
+
+// mergeNearbyEdits combines runs of sorted edits to src that are separated
+// only by whitespace and punctuation into single edits, expanded to start and
+// end at whitespace.
+func mergeNearbyEdits(src string, edits []Edit) []Edit {
+	var out []Edit
+	for i := 0; i < len(edits); {
+		merged := edits[i]
+		j := i + 1
+		for ; j < len(edits); j++ {
+			between := src[merged.End:edits[j].Start]
+			if strings.IndexFunc(between, isWordRune) >= 0 {
+				break
+			}
+			merged.New += between + edits[j].New
+			merged.End = edits[j].End
+		}
+		if j > i+1 {
+			merged = expandToSpace(src, merged)
+		}
+		out = append(out, merged)
+		i = j
+	}
+	return out
+}
+
+// expandToSpace expands e to start and end at whitespace (or the ends of src).
+func expandToSpace(src string, e Edit) Edit {
+	start := e.Start
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(src[:start])
+		if unicode.IsSpace(r) {
+			break
+		}
+		start -= size
+	}
+	end := e.End
+	for end < len(src) {
+		r, size := utf8.DecodeRuneInString(src[end:])
+		if unicode.IsSpace(r) {
+			break
+		}
+		end += size
+	}
+	return Edit{Start: start, End: end, New: src[start:e.Start] + e.New + src[e.End:end]}
+}
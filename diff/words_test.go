@@ -0,0 +1,197 @@
+package diff_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bcspragu/fineprint/diff"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"We may share data.", []string{"We", " ", "may", " ", "share", " ", "data", "."}},
+		{"don't  sell\n(ever)", []string{"don't", "  ", "sell", "\n", "(", "ever", ")"}},
+		{"users' data", []string{"users", "'", " ", "data"}},
+		{"Section 4.2", []string{"Section", " ", "4", ".", "2"}},
+		{"café", []string{"café"}},
+	}
+	for _, test := range tests {
+		got := diff.SplitWords(test.in)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitWords(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"One sentence", []string{"One sentence"}},
+		{
+			"We collect data. We share it! Do we sell it? No.",
+			[]string{"We collect data. ", "We share it! ", "Do we sell it? ", "No."},
+		},
+		{
+			`He said "stop." Then left.`,
+			[]string{`He said "stop." `, "Then left."},
+		},
+		{
+			"Version 1.2 applies to e.g. apps. 2 more.",
+			[]string{"Version 1.2 applies to e.g. apps. ", "2 more."},
+		},
+		{
+			"- first item\n- second item.\n\nNew paragraph",
+			[]string{"- first item\n", "- second item.\n\n", "New paragraph"},
+		},
+	}
+	for _, test := range tests {
+		got := diff.SplitSentences(test.in)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitSentences(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		before, after string
+		want          string
+	}{
+		{"same", "same", "same"},
+		{"We may share data.", "We will share data.", "We [-may-]{+will+} share data."},
+		{"We may share data.", "We may share data,", "We may share data[-.-]{+,+}"},
+		{"We sell data.", "We sell your data.", "We sell{+ your+} data."},
+		{"Last updated: January 1, 2024", "Last updated: March 1, 2025", "Last updated: [-January-]{+March+} 1, [-2024-]{+2025+}"},
+	}
+	for _, test := range tests {
+		edits := diff.Words(test.before, test.after)
+		got, err := diff.Apply(test.before, edits)
+		if err != nil || got != test.after {
+			t.Errorf("Apply(Words(%q, %q)) = %q, %v, want %q", test.before, test.after, got, err, test.after)
+		}
+		for _, e := range edits {
+			if before := test.before; (e.Start > 0 && e.Start < len(before) && isWordByte(before[e.Start-1]) && isWordByte(before[e.Start])) ||
+				(e.End > 0 && e.End < len(before) && isWordByte(before[e.End-1]) && isWordByte(before[e.End])) {
+				t.Errorf("Words(%q, %q) edit %v splits a word", test.before, test.after, e)
+			}
+		}
+		wd, err := diff.ToWordDiff(test.before, edits, -1)
+		if err != nil {
+			t.Fatalf("ToWordDiff: %v", err)
+		}
+		if wd != test.want {
+			t.Errorf("ToWordDiff(Words(%q, %q)) = %q, want %q", test.before, test.after, wd, test.want)
+		}
+	}
+}
+
+func isWordByte(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
+
+func TestSentences(t *testing.T) {
+	before := "We collect your email. We never sell data. Contact us anytime."
+	after := "We collect your email. We may sell data to partners. Contact us anytime."
+
+	edits := diff.Sentences(before, after)
+	got, err := diff.Apply(before, edits)
+	if err != nil || got != after {
+		t.Fatalf("Apply(Sentences()) = %q, %v, want %q", got, err, after)
+	}
+	wd, err := diff.ToWordDiff(before, edits, -1)
+	if err != nil {
+		t.Fatalf("ToWordDiff: %v", err)
+	}
+	want := "We collect your email. [-We never sell data. -]{+We may sell data to partners. +}Contact us anytime."
+	if wd != want {
+		t.Errorf("ToWordDiff(Sentences()) = %q, want %q", wd, want)
+	}
+}
+
+func TestToWordDiff_Context(t *testing.T) {
+	src := "one two three four five six seven eight nine ten eleven twelve"
+	edits := []diff.Edit{
+		{Start: strings.Index(src, "three"), End: strings.Index(src, "three") + len("three"), New: "3"},
+		{Start: strings.Index(src, "ten"), End: strings.Index(src, "ten") + len("ten"), New: "10"},
+	}
+
+	tests := []struct {
+		context int
+		want    string
+	}{
+		{-1, "one two [-three-]{+3+} four five six seven eight nine [-ten-]{+10+} eleven twelve"},
+		{2, "one two [-three-]{+3+} four five ... eight nine [-ten-]{+10+} eleven twelve"},
+		{1, "... two [-three-]{+3+} four ... nine [-ten-]{+10+} eleven ..."},
+		{0, "... [-three-]{+3+} ... [-ten-]{+10+} ..."},
+	}
+	for _, test := range tests {
+		got, err := diff.ToWordDiff(src, edits, test.context)
+		if err != nil {
+			t.Fatalf("ToWordDiff(%d): %v", test.context, err)
+		}
+		if got != test.want {
+			t.Errorf("ToWordDiff(%d) =\n%q\nwant\n%q", test.context, got, test.want)
+		}
+	}
+}
+
+func TestToWordDiff_MergesNearbyEdits(t *testing.T) {
+	src := "We collect your email address and usage data, and (sometimes) more."
+	at := func(s string) int { return strings.Index(src, s) }
+
+	tests := []struct {
+		name  string
+		edits []diff.Edit
+		want  string
+	}{
+		{
+			name: "separated by whitespace",
+			edits: []diff.Edit{
+				{Start: at(" and usage"), End: at(" and usage"), New: ","},
+				{Start: at("and usage"), End: at("and usage"), New: "location "},
+			},
+			want: "We collect your email [-address and-]{+address, location and+} usage data, and (sometimes) more.",
+		},
+		{
+			name: "separated by punctuation",
+			edits: []diff.Edit{
+				{Start: at("sometimes"), End: at("sometimes") + len("sometimes"), New: "often"},
+				{Start: at("more"), End: at("more") + len("more"), New: "less"},
+			},
+			want: "We collect your email address and usage data, and [-(sometimes) more.-]{+(often) less.+}",
+		},
+		{
+			name: "separated by a word",
+			edits: []diff.Edit{
+				{Start: at("email"), End: at("email") + len("email"), New: "phone"},
+				{Start: at("usage"), End: at("usage") + len("usage"), New: "location"},
+			},
+			want: "We collect your [-email-]{+phone+} address and [-usage-]{+location+} data, and (sometimes) more.",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := diff.ToWordDiff(src, test.edits, -1)
+			if err != nil {
+				t.Fatalf("ToWordDiff: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("ToWordDiff =\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestToWordDiff_Invalid(t *testing.T) {
+	if _, err := diff.ToWordDiff("short", []diff.Edit{{Start: 2, End: 10}}, -1); err == nil {
+		t.Error("ToWordDiff with out-of-bounds edit succeeded, want error")
+	}
+}
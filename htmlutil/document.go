@@ -0,0 +1,250 @@
+package htmlutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// A Document is a legal document broken down into its hierarchy of sections.
+type Document struct {
+	// Title is the document's top-level heading, if it has one.
+	Title string
+	// Preamble is any text that comes before the first section.
+	Preamble string
+	// Sections are the top-level sections of the document.
+	Sections []*Section
+}
+
+// A Section is a headed portion of a Document, e.g. "4.2 Data Sharing".
+type Section struct {
+	// ID is an anchor for the section derived from its heading, unique within
+	// the document, e.g. "4-2-data-sharing".
+	ID string
+	// Number is the section's numbering with trailing punctuation removed,
+	// e.g. "4.2", "IV" or "a", or empty if the section is unnumbered.
+	Number string
+	// Title is the heading text, without the number.
+	Title string
+	// Level is the heading level, where 1 is the outermost.
+	Level int
+	// Body is the Markdown text directly under the heading, not including any
+	// subsections.
+	Body string
+	// Children are the subsections of this section, in document order.
+	Children []*Section
+}
+
+// Heading returns the section number and title, joined the way headings are
+// usually written, e.g. "4.2 Data Sharing".
+func (s *Section) Heading() string {
+	return strings.TrimSpace(s.Number + " " + s.Title)
+}
+
+// Text returns the Markdown text of the section and all of its subsections,
+// including their headings.
+func (s *Section) Text() string {
+	var sb strings.Builder
+	s.writeText(&sb)
+	return strings.TrimSpace(sb.String())
+}
+
+func (s *Section) writeText(sb *strings.Builder) {
+	sb.WriteString(strings.Repeat("#", s.Level) + " " + s.Heading() + "\n\n")
+	if s.Body != "" {
+		sb.WriteString(s.Body + "\n\n")
+	}
+	for _, c := range s.Children {
+		c.writeText(sb)
+	}
+}
+
+// AllSections returns every section in the document, including nested ones,
+// in document order.
+func (d *Document) AllSections() []*Section {
+	var out []*Section
+	var walk func([]*Section)
+	walk = func(secs []*Section) {
+		for _, s := range secs {
+			out = append(out, s)
+			walk(s.Children)
+		}
+	}
+	walk(d.Sections)
+	return out
+}
+
+// ParseDocument parses an HTML page and builds a Document from its main
+// content (see MainContent). Relative links are resolved against base, which
+// may be nil.
+func ParseDocument(r io.Reader, base *url.URL) (*Document, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+	main := MainContent(doc)
+	if main == nil {
+		return nil, errors.New("no body element found in HTML")
+	}
+	return NewDocument(main, base), nil
+}
+
+// NewDocument builds a Document from n, using its headings to determine the
+// section hierarchy.
+func NewDocument(n *html.Node, base *url.URL) *Document {
+	return DocumentFromMarkdown(Markdown(n, base))
+}
+
+// DocumentFromMarkdown builds a Document from Markdown text, like that
+// produced by Markdown. Besides ATX headings ("## 4. Sharing"), paragraphs
+// that consist entirely of a bold, numbered line ("**4. Sharing**") are
+// treated as headings, since many policies are formatted that way.
+func DocumentFromMarkdown(md string) *Document {
+	b := &documentBuilder{
+		doc: &Document{},
+		ids: make(map[string]int),
+	}
+
+	inFence := false
+	for line := range strings.SplitSeq(md, "\n") {
+		if strings.HasPrefix(line, "```") {
+			inFence = !inFence
+		}
+		if !inFence {
+			if level, text, ok := parseHeadingLine(line); ok {
+				b.startSection(level, text)
+				continue
+			}
+		}
+		b.body = append(b.body, line)
+	}
+	b.flushBody()
+
+	// A single <h1> at the very top is the document's title, not a section.
+	if secs := b.doc.Sections; len(secs) == 1 && secs[0].Level == 1 && b.doc.Preamble == "" {
+		title := secs[0]
+		b.doc.Title = title.Heading()
+		b.doc.Preamble = title.Body
+		b.doc.Sections = title.Children
+	}
+
+	return b.doc
+}
+
+type documentBuilder struct {
+	doc   *Document
+	stack []*Section
+	body  []string
+	ids   map[string]int
+}
+
+func (b *documentBuilder) startSection(level int, heading string) {
+	b.flushBody()
+
+	number, title := splitSectionNumber(heading)
+	s := &Section{
+		Number: number,
+		Title:  title,
+		Level:  level,
+	}
+	s.ID = b.uniqueID(slugify(s.Heading()))
+
+	for len(b.stack) > 0 && b.stack[len(b.stack)-1].Level >= level {
+		b.stack = b.stack[:len(b.stack)-1]
+	}
+	if len(b.stack) == 0 {
+		b.doc.Sections = append(b.doc.Sections, s)
+	} else {
+		parent := b.stack[len(b.stack)-1]
+		parent.Children = append(parent.Children, s)
+	}
+	b.stack = append(b.stack, s)
+}
+
+func (b *documentBuilder) flushBody() {
+	body := strings.TrimSpace(strings.Join(b.body, "\n"))
+	b.body = nil
+	if body == "" {
+		return
+	}
+	if len(b.stack) == 0 {
+		b.doc.Preamble = body
+		return
+	}
+	b.stack[len(b.stack)-1].Body = body
+}
+
+func (b *documentBuilder) uniqueID(id string) string {
+	if id == "" {
+		id = "section"
+	}
+	b.ids[id]++
+	if n := b.ids[id]; n > 1 {
+		return id + "-" + strconv.Itoa(n)
+	}
+	return id
+}
+
+var (
+	atxHeading  = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+	boldHeading = regexp.MustCompile(`^\*\*([^*]{1,120})\*\*$`)
+
+	// sectionNumber matches the numbering at the start of a heading, like
+	// "4.2", "Section 7:", "IV.", "B." or "(a)".
+	sectionNumber = regexp.MustCompile(`^(?i:(?:section|article|clause|part|§)\s*)?(\d+(?:\.\d+)*\.?|[IVXLCDM]+\.|[A-Za-z]\.|\([a-zA-Z0-9]{1,4}\))(?:\s*[:.\-–—]?\s+(.*))?$`)
+)
+
+// parseHeadingLine returns the level and text of line, if it's a heading.
+func parseHeadingLine(line string) (int, string, bool) {
+	if m := atxHeading.FindStringSubmatch(line); m != nil {
+		return len(m[1]), m[2], true
+	}
+
+	m := boldHeading.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return 0, "", false
+	}
+	number, _ := splitSectionNumber(m[1])
+	if number == "" {
+		return 0, "", false
+	}
+	// We don't know how these fit in with real headings, so we guess based on
+	// how deeply nested the numbering is: "4." is level 2, "4.2" is level 3.
+	return min(6, 2+strings.Count(number, ".")), m[1], true
+}
+
+// splitSectionNumber splits a heading into its number and title.
+func splitSectionNumber(heading string) (string, string) {
+	heading = strings.TrimSpace(heading)
+	m := sectionNumber.FindStringSubmatch(heading)
+	if m == nil {
+		return "", heading
+	}
+	number := strings.Trim(m[1], ".()")
+	return number, strings.TrimSpace(m[2])
+}
+
+// slugify turns s into a lowercase, hyphen-separated identifier.
+func slugify(s string) string {
+	var sb strings.Builder
+	lastHyphen := true
+	for _, r := range strings.ToLower(s) {
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') {
+			sb.WriteRune(r)
+			lastHyphen = false
+		} else if !lastHyphen {
+			sb.WriteByte('-')
+			lastHyphen = true
+		}
+		if sb.Len() >= 64 {
+			break
+		}
+	}
+	return strings.Trim(sb.String(), "-")
+}
@@ -0,0 +1,148 @@
+package htmlutil
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDocumentFromMarkdown(t *testing.T) {
+	md := `# Acme Terms of Service
+
+Last updated: March 3, 2025
+
+## 1. Accounts
+
+You must be 13.
+
+### 1.1 Account Security
+
+Keep your password safe.
+
+### 1.2 Termination
+
+We may terminate accounts.
+
+## Section 7: Arbitration
+
+Disputes go to arbitration.
+
+**8. Governing Law**
+
+Delaware law applies.
+
+## Contact Us
+
+` + "```" + `
+# not a heading
+` + "```"
+
+	doc := DocumentFromMarkdown(md)
+
+	if doc.Title != "Acme Terms of Service" {
+		t.Errorf("Title = %q, want %q", doc.Title, "Acme Terms of Service")
+	}
+	if doc.Preamble != "Last updated: March 3, 2025" {
+		t.Errorf("Preamble = %q", doc.Preamble)
+	}
+
+	type flat struct {
+		ID, Number, Title string
+		Level             int
+		Body              string
+		Children          int
+	}
+	var got []flat
+	for _, s := range doc.AllSections() {
+		got = append(got, flat{s.ID, s.Number, s.Title, s.Level, s.Body, len(s.Children)})
+	}
+	want := []flat{
+		{"1-accounts", "1", "Accounts", 2, "You must be 13.", 2},
+		{"1-1-account-security", "1.1", "Account Security", 3, "Keep your password safe.", 0},
+		{"1-2-termination", "1.2", "Termination", 3, "We may terminate accounts.", 0},
+		{"7-arbitration", "7", "Arbitration", 2, "Disputes go to arbitration.", 0},
+		{"8-governing-law", "8", "Governing Law", 2, "Delaware law applies.", 0},
+		{"contact-us", "", "Contact Us", 2, "```\n# not a heading\n```", 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sections =\n%+v\nwant\n%+v", got, want)
+	}
+	if n := len(doc.Sections); n != 4 {
+		t.Errorf("got %d top-level sections, want 4", n)
+	}
+}
+
+func TestDocumentFromMarkdown_DuplicateIDs(t *testing.T) {
+	doc := DocumentFromMarkdown("## Overview\n\nOne\n\n## Overview\n\nTwo\n\n## !!!\n\nThree")
+
+	var ids []string
+	for _, s := range doc.AllSections() {
+		ids = append(ids, s.ID)
+	}
+	if want := []string{"overview", "overview-2", "section"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("IDs = %q, want %q", ids, want)
+	}
+}
+
+func TestSplitSectionNumber(t *testing.T) {
+	tests := []struct {
+		heading, number, title string
+	}{
+		{"4.2 Data Sharing", "4.2", "Data Sharing"},
+		{"4. Sharing", "4", "Sharing"},
+		{"Section 7 - Arbitration", "7", "Arbitration"},
+		{"ARTICLE IV. Liability", "IV", "Liability"},
+		{"B. Cookies", "B", "Cookies"},
+		{"(a) Definitions", "a", "Definitions"},
+		{"Section 12", "12", ""},
+		{"Introduction", "", "Introduction"},
+		{"I agree", "", "I agree"},
+		{"U.S. Residents", "", "U.S. Residents"},
+	}
+	for _, tt := range tests {
+		number, title := splitSectionNumber(tt.heading)
+		if number != tt.number || title != tt.title {
+			t.Errorf("splitSectionNumber(%q) = (%q, %q), want (%q, %q)", tt.heading, number, title, tt.number, tt.title)
+		}
+	}
+}
+
+func TestSection_Text(t *testing.T) {
+	doc := DocumentFromMarkdown("## 1. Accounts\n\nBody one.\n\n### 1.1 Security\n\nBody two.")
+
+	want := "## 1 Accounts\n\nBody one.\n\n### 1.1 Security\n\nBody two."
+	if got := doc.Sections[0].Text(); got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
+
+func TestParseDocument_Fixture(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "semantic_privacy.html"))
+	if err != nil {
+		t.Fatalf("failed to open fixture: %v", err)
+	}
+	defer f.Close()
+
+	doc, err := ParseDocument(f, nil)
+	if err != nil {
+		t.Fatalf("ParseDocument() error = %v", err)
+	}
+
+	if doc.Title != "Acme Privacy Policy" {
+		t.Errorf("Title = %q", doc.Title)
+	}
+	var headings []string
+	for _, s := range doc.AllSections() {
+		headings = append(headings, strings.Repeat("#", s.Level)+" "+s.Heading())
+	}
+	want := []string{
+		"## 1 Information We Collect",
+		"### 1.1 Device Information",
+		"## 2 How We Share Information",
+	}
+	if !reflect.DeepEqual(headings, want) {
+		t.Errorf("headings = %q, want %q", headings, want)
+	}
+}
@@ -351,3 +351,108 @@ func TestExtractText_RealWorldExample(t *testing.T) {
 		t.Errorf("ExtractText() = %q, want %q", result, expected)
 	}
 }
+
+func TestExtractStructuredText(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{
+			name:     "paragraphs on their own lines",
+			html:     `<html><body><p>First paragraph</p><p>Second paragraph</p></body></html>`,
+			expected: "First paragraph\nSecond paragraph",
+		},
+		{
+			name:     "heading markers",
+			html:     `<html><body><h1>Terms</h1><h2>1. Scope</h2><p>Text</p><h3>1.1 Details</h3></body></html>`,
+			expected: "# Terms\n## 1. Scope\nText\n### 1.1 Details",
+		},
+		{
+			name:     "inline elements stay on the line",
+			html:     `<html><body><p>First paragraph with <em>emphasis</em> and <strong>bold</strong>.</p></body></html>`,
+			expected: "First paragraph with emphasis and bold.",
+		},
+		{
+			name:     "list items",
+			html:     `<html><body><ul><li>Item one</li><li>Item <a href="#">two</a></li></ul></body></html>`,
+			expected: "- Item one\n- Item two",
+		},
+		{
+			name:     "list items with paragraphs",
+			html:     `<html><body><ul><li><p>Item one</p><p>More on one</p></li><li><div>Item two</div></li><li></li></ul><p>After</p></body></html>`,
+			expected: "- Item one\nMore on one\n- Item two\nAfter",
+		},
+		{
+			name: "table rows",
+			html: `<html><body><table>
+				<tr><th>Header 1</th><th>Header 2</th></tr>
+				<tr><td>Cell 1</td><td>Cell 2</td></tr>
+			</table></body></html>`,
+			expected: "Header 1 | Header 2\nCell 1 | Cell 2",
+		},
+		{
+			name:     "line breaks",
+			html:     `<html><body><p>123 Main St<br>Springfield<br/>USA</p></body></html>`,
+			expected: "123 Main St\nSpringfield\nUSA",
+		},
+		{
+			name:     "whitespace is collapsed",
+			html:     "<html><body><p>  Text \n\t with    spaces  </p>\n\n<p>Next</p></body></html>",
+			expected: "Text with spaces\nNext",
+		},
+		{
+			name:     "non-breaking spaces are kept",
+			html:     `<html><body><p>Section&nbsp;4</p></body></html>`,
+			expected: "Section\u00a04",
+		},
+		{
+			name: "preformatted text keeps its lines",
+			html: `<html><body><pre>line one
+  line two</pre></body></html>`,
+			expected: "line one\n  line two",
+		},
+		{
+			name: "non-content elements are dropped",
+			html: `<html><body>
+				<p>Content</p>
+				<script>var x = 1;</script>
+				<style>.class { margin: 0; }</style>
+				<noscript>No JavaScript</noscript>
+				<!-- comment -->
+				<p>More content</p>
+			</body></html>`,
+			expected: "Content\nMore content",
+		},
+		{
+			name: "hidden content is dropped",
+			html: `<html><body>
+				<p>Visible</p>
+				<p hidden>Hidden attribute</p>
+				<div aria-hidden="true">ARIA hidden</div>
+				<div style="display: none">Display none</div>
+				<span style="visibility:hidden">Visibility hidden</span>
+				<p>Also visible</p>
+			</body></html>`,
+			expected: "Visible\nAlso visible",
+		},
+		{
+			name:     "empty body",
+			html:     `<html><body></body></html>`,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := strings.NewReader(tt.html)
+			result, err := ExtractStructuredText(reader)
+			if err != nil {
+				t.Fatalf("ExtractStructuredText() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("ExtractStructuredText() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,427 @@
+package htmlutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ToMarkdown converts the <body> of an HTML document to Markdown. Headings,
+// lists, emphasis, tables and links are kept, and relative links are resolved
+// against base, which is usually the URL the page was loaded from. base may be
+// nil, in which case links are left as-is.
+func ToMarkdown(r io.Reader, base *url.URL) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		return "", errors.New("no body element found in HTML")
+	}
+
+	return Markdown(body, base), nil
+}
+
+// ExtractMainMarkdown is like ToMarkdown, but only converts the main content
+// of the page, as determined by MainContent.
+func ExtractMainMarkdown(r io.Reader, base *url.URL) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	main := MainContent(doc)
+	if main == nil {
+		return "", errors.New("no body element found in HTML")
+	}
+
+	return Markdown(main, base), nil
+}
+
+// Markdown renders n and its descendants as Markdown. See ToMarkdown.
+func Markdown(n *html.Node, base *url.URL) string {
+	c := &markdownConverter{base: base}
+	return strings.Join(c.blocks(n), "\n\n")
+}
+
+type markdownConverter struct {
+	base *url.URL
+}
+
+// blocks renders the children of n as a list of Markdown blocks (paragraphs,
+// headings, lists, etc), which are separated by blank lines in the output.
+// Runs of inline content between block elements become their own paragraph.
+func (c *markdownConverter) blocks(n *html.Node) []string {
+	var (
+		out []string
+		ib  = &inlineBuilder{}
+	)
+	flush := func() {
+		if s := ib.String(); s != "" {
+			out = append(out, s)
+		}
+		ib = &inlineBuilder{}
+	}
+
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		if skipNode(ch) {
+			continue
+		}
+		if !isMarkdownBlock(ch) {
+			c.inline(ib, ch)
+			continue
+		}
+		flush()
+		out = append(out, c.block(ch)...)
+	}
+	flush()
+
+	return out
+}
+
+func (c *markdownConverter) block(n *html.Node) []string {
+	if lvl := headingLevel(n); lvl > 0 {
+		ib := &inlineBuilder{}
+		c.inlineChildren(ib, n)
+		text := strings.ReplaceAll(ib.String(), "\n", " ")
+		if text == "" {
+			return nil
+		}
+		return []string{strings.Repeat("#", lvl) + " " + text}
+	}
+
+	switch n.DataAtom {
+	case atom.Ul, atom.Ol:
+		if list := c.list(n); list != "" {
+			return []string{list}
+		}
+		return nil
+	case atom.Table:
+		if table := c.table(n); table != "" {
+			return []string{table}
+		}
+		return nil
+	case atom.Pre:
+		text := strings.Trim(textContent(n), "\n")
+		if strings.TrimSpace(text) == "" {
+			return nil
+		}
+		return []string{"```\n" + text + "\n```"}
+	case atom.Blockquote:
+		inner := strings.Join(c.blocks(n), "\n\n")
+		if inner == "" {
+			return nil
+		}
+		lines := strings.Split(inner, "\n")
+		for i, l := range lines {
+			lines[i] = strings.TrimRight("> "+l, " ")
+		}
+		return []string{strings.Join(lines, "\n")}
+	case atom.Hr:
+		return []string{"---"}
+	}
+
+	return c.blocks(n)
+}
+
+// list renders a <ul> or <ol>, including any nested lists.
+func (c *markdownConverter) list(n *html.Node) string {
+	ordered := n.DataAtom == atom.Ol
+	num := 1
+	if start, err := strconv.Atoi(attr(n, "start")); ordered && err == nil {
+		num = start
+	}
+
+	var items []string
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || skipNode(li) {
+			continue
+		}
+		// Browsers are lenient about non-<li> children in lists, so we are too.
+		content := strings.Join(c.blocks(li), "\n")
+		if li.DataAtom != atom.Li {
+			content = strings.Join(c.block(li), "\n")
+		}
+		if content == "" {
+			continue
+		}
+
+		marker := "- "
+		if ordered {
+			marker = strconv.Itoa(num) + ". "
+			num++
+		}
+		items = append(items, prefixLines(content, marker, strings.Repeat(" ", len(marker))))
+	}
+
+	return strings.Join(items, "\n")
+}
+
+// table renders a table as a GitHub-flavored Markdown table, treating the
+// first row as the header.
+func (c *markdownConverter) table(n *html.Node) string {
+	var rows [][]string
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			if ch.Type != html.ElementNode || skipNode(ch) {
+				continue
+			}
+			switch ch.DataAtom {
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				collect(ch)
+			case atom.Tr:
+				var row []string
+				for cell := ch.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type != html.ElementNode || (cell.DataAtom != atom.Td && cell.DataAtom != atom.Th) || skipNode(cell) {
+						continue
+					}
+					ib := &inlineBuilder{}
+					c.inlineChildren(ib, cell)
+					text := strings.ReplaceAll(ib.String(), "\n", " ")
+					row = append(row, strings.ReplaceAll(text, "|", `\|`))
+				}
+				if len(row) > 0 {
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+	collect(n)
+
+	if len(rows) == 0 {
+		return ""
+	}
+
+	cols := 0
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+
+	var sb strings.Builder
+	writeRow := func(row []string) {
+		sb.WriteString("|")
+		for i := range cols {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			sb.WriteString(" " + cell + " |")
+		}
+	}
+	writeRow(rows[0])
+	sb.WriteString("\n|")
+	for range cols {
+		sb.WriteString(" --- |")
+	}
+	for _, row := range rows[1:] {
+		sb.WriteString("\n")
+		writeRow(row)
+	}
+	return sb.String()
+}
+
+func (c *markdownConverter) inline(ib *inlineBuilder, n *html.Node) {
+	if skipNode(n) {
+		return
+	}
+	switch n.Type {
+	case html.TextNode:
+		ib.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		c.inlineChildren(ib, n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		ib.lineBreak()
+	case atom.Img:
+		// Images don't carry policy text, and alt text is mostly noise.
+	case atom.Em, atom.I:
+		c.wrapped(ib, n, "*", "*")
+	case atom.Strong, atom.B:
+		c.wrapped(ib, n, "**", "**")
+	case atom.Code:
+		if text := strings.Join(strings.FieldsFunc(textContent(n), isHTMLSpace), " "); text != "" {
+			ib.raw("`" + text + "`")
+		}
+	case atom.A:
+		href := c.resolve(attr(n, "href"))
+		if href == "" {
+			c.inlineChildren(ib, n)
+			return
+		}
+		c.wrapped(ib, n, "[", "]("+href+")")
+	default:
+		if isMarkdownBlock(n) {
+			// A block nested inside inline content, which we can't represent, so
+			// just make sure it doesn't run into its neighbors.
+			ib.pendingSpace = true
+			c.inlineChildren(ib, n)
+			ib.pendingSpace = true
+			return
+		}
+		c.inlineChildren(ib, n)
+	}
+}
+
+func (c *markdownConverter) inlineChildren(ib *inlineBuilder, n *html.Node) {
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		c.inline(ib, ch)
+	}
+}
+
+// wrapped renders the children of n surrounded by open and close, keeping any
+// whitespace at the edges outside of the markers.
+func (c *markdownConverter) wrapped(ib *inlineBuilder, n *html.Node, open, close string) {
+	inner := &inlineBuilder{}
+	c.inlineChildren(inner, n)
+	text := inner.String()
+	if text == "" {
+		if inner.leadingSpace || inner.pendingSpace {
+			ib.pendingSpace = true
+		}
+		return
+	}
+	if inner.leadingSpace {
+		ib.pendingSpace = true
+	}
+	ib.raw(open + text + close)
+	ib.pendingSpace = inner.pendingSpace
+}
+
+// resolve returns href as an absolute URL, or "" if it isn't a useful link
+// target (in-page anchors, javascript: links, etc).
+func (c *markdownConverter) resolve(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if c.base != nil {
+		u = c.base.ResolveReference(u)
+	}
+	switch u.Scheme {
+	case "", "http", "https", "mailto", "tel":
+		return u.String()
+	}
+	return ""
+}
+
+// isMarkdownBlock reports whether n is rendered as its own Markdown block.
+func isMarkdownBlock(n *html.Node) bool {
+	return n.Type == html.ElementNode && (blockElements[n.DataAtom] || headingLevel(n) > 0)
+}
+
+// prefixLines prefixes the first line of s with first, and every subsequent
+// (non-empty) line with rest.
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		switch {
+		case i == 0:
+			lines[i] = first + l
+		case l != "":
+			lines[i] = rest + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// textContent returns the raw, unprocessed text of n and its descendants.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if !skipNode(c) {
+			sb.WriteString(textContent(c))
+		}
+	}
+	return sb.String()
+}
+
+// markdownEscaper escapes characters that would otherwise be interpreted as
+// inline Markdown formatting.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"*", `\*`,
+	"_", `\_`,
+	"`", "\\`",
+	"[", `\[`,
+	"]", `\]`,
+)
+
+// inlineBuilder accumulates inline Markdown, collapsing whitespace the way a
+// browser would.
+type inlineBuilder struct {
+	sb           strings.Builder
+	pendingSpace bool
+	// leadingSpace records whether the content started with whitespace, which
+	// callers wrapping the content in markers need to know.
+	leadingSpace bool
+}
+
+func (ib *inlineBuilder) text(s string) {
+	fields := strings.FieldsFunc(s, isHTMLSpace)
+	if len(fields) == 0 {
+		if s != "" {
+			ib.space()
+		}
+		return
+	}
+	if isHTMLSpace(rune(s[0])) {
+		ib.space()
+	}
+	for i, f := range fields {
+		if i > 0 {
+			ib.pendingSpace = true
+		}
+		ib.raw(markdownEscaper.Replace(f))
+	}
+	ib.pendingSpace = isHTMLSpace(rune(s[len(s)-1]))
+}
+
+func (ib *inlineBuilder) space() {
+	if ib.sb.Len() == 0 {
+		ib.leadingSpace = true
+	}
+	ib.pendingSpace = true
+}
+
+// raw appends s verbatim, preceded by a space if one is pending.
+func (ib *inlineBuilder) raw(s string) {
+	if ib.pendingSpace && ib.sb.Len() > 0 && !strings.HasSuffix(ib.sb.String(), "\n") {
+		ib.sb.WriteString(" ")
+	}
+	ib.pendingSpace = false
+	ib.sb.WriteString(s)
+}
+
+func (ib *inlineBuilder) lineBreak() {
+	if ib.sb.Len() > 0 {
+		ib.sb.WriteString("\n")
+	}
+	ib.pendingSpace = false
+}
+
+// String returns the accumulated content, without leading or trailing
+// whitespace.
+func (ib *inlineBuilder) String() string {
+	return strings.TrimSpace(ib.sb.String())
+}
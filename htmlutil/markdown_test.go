@@ -0,0 +1,129 @@
+package htmlutil
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestToMarkdown(t *testing.T) {
+	base, err := url.Parse("https://example.com/legal/privacy?lang=en")
+	if err != nil {
+		t.Fatalf("failed to parse base URL: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{
+			name:     "headings keep section numbering",
+			html:     `<h1>Privacy Policy</h1><h2>4. Sharing</h2><h3>4.2 Data Sharing</h3><p>We share data.</p>`,
+			expected: "# Privacy Policy\n\n## 4. Sharing\n\n### 4.2 Data Sharing\n\nWe share data.",
+		},
+		{
+			name:     "emphasis",
+			html:     `<p>You <strong>must</strong> be <em>at least</em> 13<b> </b>years old.</p>`,
+			expected: "You **must** be *at least* 13 years old.",
+		},
+		{
+			name:     "emphasis keeps whitespace outside markers",
+			html:     `<p>We<strong> never </strong>sell data.</p>`,
+			expected: "We **never** sell data.",
+		},
+		{
+			name:     "bulleted list",
+			html:     `<ul><li>Name</li><li>Email address</li></ul>`,
+			expected: "- Name\n- Email address",
+		},
+		{
+			name:     "numbered list with start",
+			html:     `<ol start="3"><li>Third</li><li>Fourth</li></ol>`,
+			expected: "3. Third\n4. Fourth",
+		},
+		{
+			name:     "nested list",
+			html:     `<ol><li>Collect<ul><li>Cookies</li><li>Pixels</li></ul></li><li>Share</li></ol>`,
+			expected: "1. Collect\n   - Cookies\n   - Pixels\n2. Share",
+		},
+		{
+			name:     "links are resolved",
+			html:     `<p>See <a href="/terms">our terms</a>, <a href="cookies#opt-out">cookies</a> and <a href="https://other.example/x">partners</a>.</p>`,
+			expected: "See [our terms](https://example.com/terms), [cookies](https://example.com/legal/cookies#opt-out) and [partners](https://other.example/x).",
+		},
+		{
+			name:     "in-page and script links become text",
+			html:     `<p><a href="#section-2">Section 2</a> and <a href="javascript:void(0)">settings</a></p>`,
+			expected: "Section 2 and settings",
+		},
+		{
+			name: "table",
+			html: `<table>
+				<thead><tr><th>Category</th><th>Purpose</th></tr></thead>
+				<tbody>
+					<tr><td>Identifiers</td><td>Ads | analytics</td></tr>
+					<tr><td>Location</td></tr>
+				</tbody>
+			</table>`,
+			expected: "| Category | Purpose |\n| --- | --- |\n| Identifiers | Ads \\| analytics |\n| Location |  |",
+		},
+		{
+			name:     "blockquote",
+			html:     `<blockquote><p>Quoted one</p><p>Quoted two</p></blockquote>`,
+			expected: "> Quoted one\n>\n> Quoted two",
+		},
+		{
+			name:     "mixed inline and block content",
+			html:     `<div>Intro text<p>Paragraph</p>Trailing text</div>`,
+			expected: "Intro text\n\nParagraph\n\nTrailing text",
+		},
+		{
+			name:     "line breaks",
+			html:     `<p>Acme Inc.<br>123 Main St</p>`,
+			expected: "Acme Inc.\n123 Main St",
+		},
+		{
+			name:     "markdown characters are escaped",
+			html:     `<p>Use *only* the [approved] list_name</p>`,
+			expected: `Use \*only\* the \[approved\] list\_name`,
+		},
+		{
+			name:     "non-content is dropped",
+			html:     `<p>Text</p><script>alert(1)</script><p hidden>Hidden</p><img src="x.png" alt="Logo">`,
+			expected: "Text",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ToMarkdown(strings.NewReader(tt.html), base)
+			if err != nil {
+				t.Fatalf("ToMarkdown() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("ToMarkdown() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtractMainMarkdown(t *testing.T) {
+	html := `<html><body>
+		<nav><a href="/">Home</a><a href="/blog">Blog</a></nav>
+		<main>
+			<h1>Terms</h1>
+			<p>By using the service you agree to the <a href="/privacy">Privacy Policy</a>.</p>
+		</main>
+		<footer>Footer text</footer>
+	</body></html>`
+
+	result, err := ExtractMainMarkdown(strings.NewReader(html), &url.URL{Scheme: "https", Host: "example.com"})
+	if err != nil {
+		t.Fatalf("ExtractMainMarkdown() error = %v", err)
+	}
+	expected := "# Terms\n\nBy using the service you agree to the [Privacy Policy](https://example.com/privacy)."
+	if result != expected {
+		t.Errorf("ExtractMainMarkdown() = %q, want %q", result, expected)
+	}
+}
@@ -0,0 +1,357 @@
+package htmlutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ExtractMainContent is like ExtractStructuredText, but it first strips site
+// chrome (headers, footers, navigation, cookie banners, related links) and
+// narrows the document down to the element holding the legal text itself. See
+// MainContent for how that element is chosen.
+func ExtractMainContent(r io.Reader) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	main := MainContent(doc)
+	if main == nil {
+		return "", errors.New("no body element found in HTML")
+	}
+
+	return StructuredText(main), nil
+}
+
+// MainContent returns the element of doc most likely to contain the main
+// document text, or nil if doc has no <body>. It modifies doc in the process,
+// removing boilerplate elements it finds along the way.
+//
+// The heuristics are loosely based on Readability: semantic hints (<main>,
+// <article>, ARIA roles) are preferred when they hold most of the page's text,
+// navigation-like and consent-banner elements are pruned, and text inside
+// links doesn't count as content, so link-dense menus lose out to prose.
+func MainContent(doc *html.Node) *html.Node {
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		return nil
+	}
+
+	pruneBoilerplate(body)
+
+	total := contentLen(body)
+	if total == 0 {
+		return body
+	}
+
+	root := body
+	if hinted := findHintedMain(body); hinted != nil && contentLen(hinted)*2 >= total {
+		root = hinted
+	}
+
+	// Descend as long as a single child holds nearly all of the content, but
+	// don't leave behind headings (usually the document title) on the way.
+	for {
+		var next *html.Node
+		rootLen := contentLen(root)
+		for c := root.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && contentLen(c)*10 >= rootLen*8 {
+				next = c
+				break
+			}
+		}
+		if next == nil || siblingsHaveHeading(next) {
+			break
+		}
+		root = next
+	}
+
+	pruneLinkLists(root)
+	return root
+}
+
+// consentIDs are the IDs and class names of well-known cookie consent
+// managers, which are always safe to remove.
+var consentIDs = map[string]bool{
+	"onetrust-consent-sdk":    true,
+	"onetrust-banner-sdk":     true,
+	"cybotcookiebotdialog":    true,
+	"cookiebanner":            true,
+	"cookie-banner":           true,
+	"cookie-consent":          true,
+	"cookieconsent":           true,
+	"cookie-notice":           true,
+	"cookie-law-info-bar":     true,
+	"usercentrics-root":       true,
+	"didomi-host":             true,
+	"truste-consent-track":    true,
+	"truste-consent-content":  true,
+	"qc-cmp2-container":       true,
+	"gdpr-banner":             true,
+	"gdpr-consent":            true,
+	"cc-window":               true,
+	"cc_banner":               true,
+	"osano-cm-window":         true,
+	"sp_message_container":    true,
+	"trustarc-banner-overlay": true,
+}
+
+// boilerplateHints are words in IDs and class names (split at hyphens,
+// underscores and the like, so "site-footer" has the word "footer") that
+// usually mark site chrome. Since legal documents sometimes reuse these words,
+// they only apply to elements with little text.
+var boilerplateHints = map[string]bool{
+	"breadcrumb":  true,
+	"breadcrumbs": true,
+	"footer":      true,
+	"masthead":    true,
+	"menu":        true,
+	"navbar":      true,
+	"newsletter":  true,
+	"sidebar":     true,
+	"skip":        true,
+	"subscribe":   true,
+}
+
+// linkBoilerplateHints are like boilerplateHints, but they're words policies
+// use for their own content too (a cookie policy might have
+// class="cookie-table", a privacy policy a section on how they share your
+// data), so they also only apply to elements that are mostly links, like
+// share buttons and lists of related articles.
+var linkBoilerplateHints = map[string]bool{
+	"consent": true,
+	"cookie":  true,
+	"cookies": true,
+	"related": true,
+	"share":   true,
+	"sharing": true,
+	"social":  true,
+}
+
+// maxHintedBoilerplateLen is the most text (in runes) an element can have
+// and still be removed solely because of boilerplateHints.
+const maxHintedBoilerplateLen = 1000
+
+// boilerplateRoles are ARIA landmark roles that never hold the main content.
+var boilerplateRoles = map[string]bool{
+	"navigation":    true,
+	"banner":        true,
+	"contentinfo":   true,
+	"complementary": true,
+	"search":        true,
+	"menu":          true,
+	"menubar":       true,
+	"alertdialog":   true,
+}
+
+// pruneBoilerplate removes descendants of n that look like site chrome.
+func pruneBoilerplate(n *html.Node) {
+	var next *html.Node
+	for c := n.FirstChild; c != nil; c = next {
+		next = c.NextSibling
+		if isBoilerplate(c) {
+			n.RemoveChild(c)
+			continue
+		}
+		pruneBoilerplate(c)
+	}
+}
+
+func isBoilerplate(n *html.Node) bool {
+	if n.Type == html.CommentNode {
+		return true
+	}
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if skipNode(n) {
+		return true
+	}
+
+	switch n.DataAtom {
+	case atom.Nav, atom.Aside, atom.Menu, atom.Dialog:
+		return true
+	case atom.Header, atom.Footer:
+		// Articles often have their own header (with the title) and footer,
+		// which we want to keep.
+		if !hasAncestor(n, atom.Article, atom.Main) {
+			return true
+		}
+	}
+
+	if boilerplateRoles[strings.ToLower(attr(n, "role"))] {
+		return true
+	}
+
+	for _, name := range names(n) {
+		if consentIDs[name] {
+			return true
+		}
+	}
+	total := textLen(n)
+	if total > maxHintedBoilerplateLen {
+		return false
+	}
+	return hasHint(n, boilerplateHints) || (hasHint(n, linkBoilerplateHints) && linkTextLen(n)*2 >= total)
+}
+
+// names returns n's ID and class names, lowercased.
+func names(n *html.Node) []string {
+	return append(strings.Fields(strings.ToLower(attr(n, "class"))), strings.ToLower(attr(n, "id")))
+}
+
+// hasHint reports whether any word of n's ID or class names is in hints.
+func hasHint(n *html.Node, hints map[string]bool) bool {
+	for _, name := range names(n) {
+		for _, word := range strings.FieldsFunc(name, isNameSeparator) {
+			if hints[word] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isNameSeparator reports whether r separates the words of an ID or class
+// name.
+func isNameSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// findHintedMain returns the element explicitly marked as the main content,
+// preferring <main> and role="main" over the largest <article>.
+func findHintedMain(n *html.Node) *html.Node {
+	var main, article *html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if main == nil && (n.DataAtom == atom.Main || strings.EqualFold(attr(n, "role"), "main")) {
+				main = n
+			}
+			if n.DataAtom == atom.Article && (article == nil || contentLen(n) > contentLen(article)) {
+				article = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+
+	if main != nil {
+		return main
+	}
+	return article
+}
+
+// pruneLinkLists removes link-dense lists and containers, like tables of
+// related links, from inside the main content. Policies have link lists of
+// their own (say, of the companies they share data with), so only ones with a
+// boilerplate hint in their names go, though unlike in isBoilerplate, however
+// much text they have.
+func pruneLinkLists(n *html.Node) {
+	var next *html.Node
+	for c := n.FirstChild; c != nil; c = next {
+		next = c.NextSibling
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.DataAtom {
+		case atom.Ul, atom.Ol, atom.Div, atom.Section, atom.Table:
+			hinted := hasHint(c, boilerplateHints) || hasHint(c, linkBoilerplateHints)
+			if total := textLen(c); hinted && total > 0 && countLinks(c) >= 3 && linkTextLen(c)*10 >= total*6 {
+				n.RemoveChild(c)
+				continue
+			}
+		}
+		pruneLinkLists(c)
+	}
+}
+
+// siblingsHaveHeading reports whether any sibling of n is or contains a
+// heading.
+func siblingsHaveHeading(n *html.Node) bool {
+	for s := n.Parent.FirstChild; s != nil; s = s.NextSibling {
+		if s != n && containsHeading(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsHeading(n *html.Node) bool {
+	if headingLevel(n) > 0 && textLen(n) > 0 {
+		return true
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if containsHeading(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAncestor(n *html.Node, atoms ...atom.Atom) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type != html.ElementNode {
+			continue
+		}
+		for _, a := range atoms {
+			if p.DataAtom == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// textLen returns the length in runes of the visible text in n, with runs of
+// whitespace collapsed.
+func textLen(n *html.Node) int {
+	return measureText(n, false)
+}
+
+// contentLen is like textLen, but doesn't count text inside links.
+func contentLen(n *html.Node) int {
+	return measureText(n, true)
+}
+
+// linkTextLen returns the length of the visible text in n that's inside links.
+func linkTextLen(n *html.Node) int {
+	return textLen(n) - contentLen(n)
+}
+
+func measureText(n *html.Node, skipLinks bool) int {
+	if skipNode(n) {
+		return 0
+	}
+	if n.Type == html.TextNode {
+		return utf8.RuneCountInString(strings.Join(strings.FieldsFunc(n.Data, isHTMLSpace), " "))
+	}
+	if skipLinks && n.Type == html.ElementNode && n.DataAtom == atom.A {
+		return 0
+	}
+	total := 0
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		total += measureText(c, skipLinks)
+	}
+	return total
+}
+
+func countLinks(n *html.Node) int {
+	count := 0
+	if n.Type == html.ElementNode && n.DataAtom == atom.A {
+		count++
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		count += countLinks(c)
+	}
+	return count
+}
@@ -0,0 +1,150 @@
+package htmlutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractMainContent_Fixtures(t *testing.T) {
+	tests := []struct {
+		file        string
+		firstLine   string
+		contains    []string
+		notContains []string
+	}{
+		{
+			file:      "semantic_privacy.html",
+			firstLine: "# Acme Privacy Policy",
+			contains: []string{
+				"## 1. Information We Collect",
+				"### 1.1 Device Information",
+				"We do not sell your personal information.",
+			},
+			notContains: []string{
+				"Skip to content",
+				"Products",
+				"We use cookies to improve your experience",
+				"Related articles",
+				"All rights reserved",
+				"dataLayer",
+			},
+		},
+		{
+			file:      "div_soup_terms.html",
+			firstLine: "# Widgetly Terms of Service",
+			contains: []string{
+				"## 3. Arbitration",
+				"you waive your right to participate in a class action.",
+			},
+			notContains: []string{
+				"Log in",
+				"This website uses cookies",
+				"Data Processing Addendum",
+				"Careers",
+				"Copyright 2025 Widgetly",
+			},
+		},
+		{
+			file:      "cookie_policy.html",
+			firstLine: "# Snacky Cookie Policy",
+			contains: []string{
+				"## What are cookies?",
+				"## How can I control cookies?",
+				"Last revised January 2025.",
+			},
+			notContains: []string{
+				"Tweet",
+				"Snacky Ltd.",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatalf("failed to open fixture: %v", err)
+			}
+			defer f.Close()
+
+			result, err := ExtractMainContent(f)
+			if err != nil {
+				t.Fatalf("ExtractMainContent() error = %v", err)
+			}
+
+			if first, _, _ := strings.Cut(result, "\n"); first != tt.firstLine {
+				t.Errorf("first line = %q, want %q\nfull text:\n%s", first, tt.firstLine, result)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(result, want) {
+					t.Errorf("result is missing %q\nfull text:\n%s", want, result)
+				}
+			}
+			for _, unwanted := range tt.notContains {
+				if strings.Contains(result, unwanted) {
+					t.Errorf("result unexpectedly contains %q\nfull text:\n%s", unwanted, result)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractMainContent_NoHints(t *testing.T) {
+	html := `<html><body><p>Just a single paragraph of text.</p></body></html>`
+
+	result, err := ExtractMainContent(strings.NewReader(html))
+	if err != nil {
+		t.Fatalf("ExtractMainContent() error = %v", err)
+	}
+	if want := "Just a single paragraph of text."; result != want {
+		t.Errorf("ExtractMainContent() = %q, want %q", result, want)
+	}
+}
+
+func TestMainContent_BoilerplateHints(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "chrome is removed",
+			html: `<div class="site-footer">Copyright Acme</div><div class="share-links"><a href="/t">Tweet</a> <a href="/f">Post</a></div><p>Policy text.</p>`,
+			want: "Policy text.",
+		},
+		{
+			name: "hints only match whole words",
+			html: `<p>Policy text.</p><div class="menuitem-description">Menu items are described here.</div>`,
+			want: "Policy text.\nMenu items are described here.",
+		},
+		{
+			name: "content that reuses the words is kept",
+			html: `<section class="share"><h2>How we share your data</h2><p>We share it with <a href="/processors">processors</a>.</p></section>` +
+				`<table class="cookie-table"><tr><td>_ga</td><td>Analytics</td></tr></table>`,
+			want: "## How we share your data\nWe share it with processors.\n_ga | Analytics",
+		},
+		{
+			name: "the policy's own link lists are kept",
+			html: `<p>We share your data with:</p><ul><li><a href="https://ads.example">Ads Incorporated</a>: ads</li><li><a href="https://metrics.example">Metrics Company</a>: stats</li><li><a href="https://cloud.example">Cloud Hosting Ltd</a>: hosting</li></ul>`,
+			want: "We share your data with:\n- Ads Incorporated: ads\n- Metrics Company: stats\n- Cloud Hosting Ltd: hosting",
+		},
+		{
+			name: "long related link lists are removed",
+			html: `<p>Policy text.</p><ul class="related-articles">` + strings.Repeat(`<li><a href="/blog/post">A blog post with a long and descriptive title</a></li>`, 30) + `</ul>`,
+			want: "Policy text.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractMainContent(strings.NewReader("<html><body>" + tt.html + "</body></html>"))
+			if err != nil {
+				t.Fatalf("ExtractMainContent() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ExtractMainContent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package htmlutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ExtractStructuredText is like ExtractText, but it keeps the document's
+// block structure: headings, paragraphs, list items, table rows and line breaks
+// each end up on their own line, which is what makes line-based diffs of
+// policy documents useful. Headings are prefixed with Markdown-style markers
+// ("#", "##", ...) so the hierarchy survives, and non-content (<script>,
+// <style>, <noscript>, hidden elements) is dropped.
+func ExtractStructuredText(r io.Reader) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		return "", errors.New("no body element found in HTML")
+	}
+
+	return StructuredText(body), nil
+}
+
+// StructuredText returns the text content of n, formatted as described in
+// ExtractStructuredText.
+func StructuredText(n *html.Node) string {
+	w := &lineWriter{}
+	w.walk(n)
+	w.breakLine()
+	return strings.Join(w.lines, "\n")
+}
+
+// findElement returns the first element of type a in a depth-first traversal
+// of n, or nil if there isn't one.
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// skippedElements never contain user-visible document text.
+var skippedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Head:     true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Canvas:   true,
+	atom.Select:   true,
+}
+
+// blockElements start and end a line of output.
+var blockElements = map[atom.Atom]bool{
+	atom.Address:    true,
+	atom.Article:    true,
+	atom.Aside:      true,
+	atom.Blockquote: true,
+	atom.Caption:    true,
+	atom.Dd:         true,
+	atom.Details:    true,
+	atom.Div:        true,
+	atom.Dl:         true,
+	atom.Dt:         true,
+	atom.Fieldset:   true,
+	atom.Figcaption: true,
+	atom.Figure:     true,
+	atom.Footer:     true,
+	atom.Form:       true,
+	atom.Header:     true,
+	atom.Hr:         true,
+	atom.Legend:     true,
+	atom.Li:         true,
+	atom.Main:       true,
+	atom.Nav:        true,
+	atom.Ol:         true,
+	atom.P:          true,
+	atom.Pre:        true,
+	atom.Section:    true,
+	atom.Summary:    true,
+	atom.Table:      true,
+	atom.Tbody:      true,
+	atom.Tfoot:      true,
+	atom.Thead:      true,
+	atom.Tr:         true,
+	atom.Ul:         true,
+}
+
+// headingLevel returns 1-6 for <h1>-<h6>, and 0 for everything else.
+func headingLevel(n *html.Node) int {
+	if n.Type != html.ElementNode {
+		return 0
+	}
+	switch n.DataAtom {
+	case atom.H1:
+		return 1
+	case atom.H2:
+		return 2
+	case atom.H3:
+		return 3
+	case atom.H4:
+		return 4
+	case atom.H5:
+		return 5
+	case atom.H6:
+		return 6
+	}
+	return 0
+}
+
+// isHidden reports whether n is explicitly hidden from readers, either via
+// the hidden attribute, aria-hidden, or an inline display/visibility style.
+func isHidden(n *html.Node) bool {
+	for _, a := range n.Attr {
+		switch strings.ToLower(a.Key) {
+		case "hidden":
+			return true
+		case "aria-hidden":
+			if strings.EqualFold(strings.TrimSpace(a.Val), "true") {
+				return true
+			}
+		case "style":
+			style := strings.ToLower(strings.Join(strings.Fields(a.Val), ""))
+			if strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden") {
+				return true
+			}
+		case "type":
+			if n.DataAtom == atom.Input && strings.EqualFold(a.Val, "hidden") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// skipNode reports whether n and its descendants should be left out of any
+// text rendering.
+func skipNode(n *html.Node) bool {
+	if n.Type == html.CommentNode {
+		return true
+	}
+	if n.Type != html.ElementNode {
+		return false
+	}
+	return skippedElements[n.DataAtom] || isHidden(n)
+}
+
+// attr returns the value of the attribute key on n, or "" if it isn't set.
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// lineWriter accumulates text into lines, collapsing whitespace the way a
+// browser would.
+type lineWriter struct {
+	lines []string
+
+	cur          strings.Builder
+	prefix       string
+	pendingSpace bool
+	pendingSep   string
+	preformatted int
+}
+
+// text appends a run of inline text to the current line.
+func (w *lineWriter) text(s string) {
+	if w.preformatted > 0 {
+		for i, l := range strings.Split(s, "\n") {
+			if i > 0 {
+				w.breakLine()
+			}
+			if strings.TrimSpace(l) != "" {
+				w.write(strings.TrimRight(l, " \t\r"))
+			}
+		}
+		return
+	}
+
+	fields := strings.FieldsFunc(s, isHTMLSpace)
+	if len(fields) == 0 {
+		if s != "" {
+			w.pendingSpace = true
+		}
+		return
+	}
+	if isHTMLSpace(rune(s[0])) {
+		w.pendingSpace = true
+	}
+	for i, f := range fields {
+		if i > 0 {
+			w.pendingSpace = true
+		}
+		w.write(f)
+	}
+	w.pendingSpace = isHTMLSpace(rune(s[len(s)-1]))
+}
+
+// write appends s to the current line, flushing any pending prefix, separator
+// or space first.
+func (w *lineWriter) write(s string) {
+	if w.cur.Len() == 0 {
+		w.cur.WriteString(w.prefix)
+		w.prefix = ""
+	} else if w.pendingSep != "" {
+		w.cur.WriteString(w.pendingSep)
+	} else if w.pendingSpace {
+		w.cur.WriteString(" ")
+	}
+	w.pendingSep = ""
+	w.pendingSpace = false
+	w.cur.WriteString(s)
+}
+
+// breakLine ends the current line, if it has any content. A prefix that
+// hasn't been written yet carries over to the next line, so that a list item
+// whose text is wrapped in a <p> still gets its marker.
+func (w *lineWriter) breakLine() {
+	if w.cur.Len() > 0 {
+		w.lines = append(w.lines, w.cur.String())
+		w.cur.Reset()
+	}
+	w.pendingSep = ""
+	w.pendingSpace = false
+}
+
+// endBlock ends the current line and drops any unused prefix, so that an
+// empty list item or heading doesn't mark whatever comes after it.
+func (w *lineWriter) endBlock() {
+	w.breakLine()
+	w.prefix = ""
+}
+
+func (w *lineWriter) walk(n *html.Node) {
+	if skipNode(n) {
+		return
+	}
+	if n.Type == html.TextNode {
+		w.text(n.Data)
+		return
+	}
+	if n.Type != html.ElementNode {
+		w.walkChildren(n)
+		return
+	}
+
+	if lvl := headingLevel(n); lvl > 0 {
+		w.breakLine()
+		w.prefix = strings.Repeat("#", lvl) + " "
+		w.walkChildren(n)
+		w.endBlock()
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		w.breakLine()
+	case atom.Li:
+		w.breakLine()
+		w.prefix = "- "
+		w.walkChildren(n)
+		w.endBlock()
+	case atom.Td, atom.Th:
+		if w.cur.Len() > 0 {
+			w.pendingSep = " | "
+		}
+		w.walkChildren(n)
+		if w.cur.Len() > 0 {
+			w.pendingSep = " | "
+		}
+	case atom.Pre:
+		w.breakLine()
+		w.preformatted++
+		w.walkChildren(n)
+		w.preformatted--
+		w.breakLine()
+	default:
+		if blockElements[n.DataAtom] {
+			w.breakLine()
+			w.walkChildren(n)
+			w.breakLine()
+			return
+		}
+		w.walkChildren(n)
+	}
+}
+
+func (w *lineWriter) walkChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.walk(c)
+	}
+}
+
+// isHTMLSpace reports whether r is one of the ASCII whitespace characters
+// HTML collapses. Notably, this excludes non-breaking spaces.
+func isHTMLSpace(r rune) bool {
+	switch r {
+	case ' ', '\t', '\n', '\f', '\r':
+		return true
+	}
+	return false
+}
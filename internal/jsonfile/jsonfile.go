@@ -0,0 +1,52 @@
+// Package jsonfile reads and writes values as JSON files, for the on-disk
+// stores.
+package jsonfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Read decodes the file at path into v. If the file doesn't exist, the error
+// wraps fs.ErrNotExist.
+func Read(path string, v any) error {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(dat, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// Write atomically replaces the file at path with v encoded as JSON, by
+// writing to a temporary file in the same directory and renaming it.
+func Write(path string, v any) error {
+	dat, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // No-op after a successful rename.
+
+	if _, err := f.Write(dat); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,102 @@
+// Package netguard keeps us from fetching URLs on private networks. The URLs
+// we fetch come from the emails people send us, and we email back what we find
+// there, so otherwise anyone could use us to read from services that are only
+// reachable from our network, like cloud metadata endpoints.
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrNotPublic is returned for hosts and addresses that aren't on the public
+// internet.
+var ErrNotPublic = errors.New("not a public internet address")
+
+// Resolver looks up hostnames for CheckHost. Tests replace it to run without a
+// network.
+var Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+} = net.DefaultResolver
+
+// nonPublic are ranges that IsPublic rejects on top of the ones netip knows
+// about.
+var nonPublic = []netip.Prefix{
+	// "This network", which Linux connects to as if it were localhost.
+	netip.MustParsePrefix("0.0.0.0/8"),
+	// Carrier-grade NAT, which some clouds use for internal services.
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// IsPublic reports whether addr is on the public internet, i.e. isn't a
+// loopback, private, link-local, multicast or unspecified address. IPv4
+// addresses mapped to IPv6 are judged as IPv4.
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublic {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckHost returns an error wrapping ErrNotPublic if host is, or resolves to,
+// an address that isn't public. Every address has to be public, since we could
+// connect to any of them.
+func CheckHost(ctx context.Context, host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !IsPublic(addr) {
+			return fmt.Errorf("%s is %w", host, ErrNotPublic)
+		}
+		return nil
+	}
+	addrs, err := Resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if !IsPublic(addr) {
+			return fmt.Errorf("%s resolves to %s, which is %w", host, addr, ErrNotPublic)
+		}
+	}
+	return nil
+}
+
+// Control is a net.Dialer Control function that refuses to connect to
+// addresses that aren't public. Checking the address we're about to connect
+// to, rather than only the URL we were given, also covers redirects, and hosts
+// that resolve differently by the time we fetch them.
+func Control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	if !IsPublic(addrPort.Addr()) {
+		return fmt.Errorf("refusing to connect to %s, which is %w", addrPort.Addr(), ErrNotPublic)
+	}
+	return nil
+}
+
+// NewTransport returns an HTTP transport like http.DefaultTransport that only
+// connects to public addresses. It doesn't use a proxy, which would connect on
+// our behalf without the check.
+func NewTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   Control,
+	}).DialContext
+	return t
+}
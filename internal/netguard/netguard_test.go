@@ -0,0 +1,91 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.5", false},
+		{"172.16.1.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00:ec2::254", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"::", false},
+		{"100.100.100.200", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:93.184.215.14", true},
+	}
+	for _, tt := range tests {
+		if got := IsPublic(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("IsPublic(%s) = %t, want %t", tt.addr, got, tt.want)
+		}
+	}
+}
+
+type fakeResolver map[string][]netip.Addr
+
+func (r fakeResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, ok := r[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return addrs, nil
+}
+
+func TestCheckHost(t *testing.T) {
+	old := Resolver
+	defer func() { Resolver = old }()
+	Resolver = fakeResolver{
+		"example.com":  {netip.MustParseAddr("93.184.215.14")},
+		"internal.com": {netip.MustParseAddr("93.184.215.14"), netip.MustParseAddr("10.0.0.5")},
+	}
+
+	tests := []struct {
+		host          string
+		wantErr       bool
+		wantNotPublic bool
+	}{
+		{host: "example.com"},
+		{host: "93.184.215.14"},
+		{host: "internal.com", wantErr: true, wantNotPublic: true},
+		{host: "169.254.169.254", wantErr: true, wantNotPublic: true},
+		{host: "::1", wantErr: true, wantNotPublic: true},
+		{host: "nonexistent.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		err := CheckHost(context.Background(), tt.host)
+		if (err != nil) != tt.wantErr || errors.Is(err, ErrNotPublic) != tt.wantNotPublic {
+			t.Errorf("CheckHost(%q) = %v, want error %t (not public %t)", tt.host, err, tt.wantErr, tt.wantNotPublic)
+		}
+	}
+}
+
+func TestNewTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewTransport()}
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrNotPublic) {
+		t.Errorf("fetching %s = %v, want %v", srv.URL, err, ErrNotPublic)
+	}
+}
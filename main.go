@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -10,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -19,10 +21,15 @@ import (
 	"slices"
 
 	"github.com/bcspragu/fineprint/claude"
+	"github.com/bcspragu/fineprint/commands"
 	"github.com/bcspragu/fineprint/diff"
 	"github.com/bcspragu/fineprint/htmlutil"
+	"github.com/bcspragu/fineprint/internal/netguard"
 	"github.com/bcspragu/fineprint/postmark"
+	"github.com/bcspragu/fineprint/queue"
 	"github.com/bcspragu/fineprint/ratelimit"
+	"github.com/bcspragu/fineprint/sectiondiff"
+	"github.com/bcspragu/fineprint/store"
 	"github.com/bcspragu/fineprint/templates"
 	"github.com/bcspragu/fineprint/tosdr"
 	"github.com/bcspragu/fineprint/webarchive"
@@ -42,6 +49,12 @@ func run(args []string) error {
 	if len(args) == 0 {
 		return errors.New("no args given")
 	}
+	if len(args) > 1 && args[1] == "analyze" {
+		return runAnalyze(args[2:])
+	}
+	if len(args) > 1 && args[1] == "replay" {
+		return runReplay(args[2:])
+	}
 
 	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
 	var (
@@ -55,6 +68,16 @@ func run(args []string) error {
 
 		archiveAccessKey = fs.String("archive-access-key", "", "Internet Archive access key")
 		archiveSecretKey = fs.String("archive-secret-key", "", "Internet Archive secret key")
+
+		adminToken = fs.String("admin-token", "", "Bearer token for the /admin/ debugging endpoints, which are disabled if empty")
+
+		reportTTL      = fs.Duration("report-ttl", 7*24*time.Hour, "How long to reuse an LLM report for the same policy change before generating a new one. Zero disables reuse")
+		watchInterval  = fs.Duration("watch-interval", 24*time.Hour, "How often to re-check watched policies for changes. Zero disables checking")
+		queueWorkers   = fs.Int("queue-workers", 4, "Number of emails to process concurrently")
+		maxInputTokens = fs.Int("max-input-tokens", 50000, "Most input tokens to send the LLM in one request. Longer emails are trimmed, and longer policies and diffs are analyzed in chunks. Zero means up to the model's context window")
+		maxChunks      = fs.Int("max-report-chunks", 8, "Most chunks a long policy or diff is split into for analysis, which caps the LLM cost of one report. Anything past that is left out. Zero means no limit")
+		dataDir        = fs.String("data-dir", "", "Directory to store fetched policies, analysis records and queued emails in. If empty, they're only kept until the server stops, so queued emails aren't retried after a restart")
+		inboundLogPath = fs.String("inbound-log", "", "File to append inbound emails to, for replaying them later with \"fineprint replay\". Emails aren't recorded if empty")
 	)
 
 	if err := ff.Parse(fs, args[1:], ff.WithEnvVars()); err != nil {
@@ -68,18 +91,72 @@ func run(args []string) error {
 		return errors.New("REPLY_FROM_EMAIL not set, which is required for email sending")
 	}
 
+	var (
+		db       store.Store
+		queueDir string
+	)
+	if *dataDir == "" {
+		log.Printf("DATA_DIR not set, analyses and queued emails won't persist across restarts")
+		db = store.NewMemory()
+		tmp, err := os.MkdirTemp("", "fineprint-queue-")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary queue directory: %w", err)
+		}
+		defer os.RemoveAll(tmp)
+		queueDir = tmp
+	} else {
+		disk, err := store.OpenDisk(*dataDir)
+		if err != nil {
+			return fmt.Errorf("failed to open store: %w", err)
+		}
+		db = disk
+		queueDir = filepath.Join(*dataDir, "queue")
+	}
+	defer db.Close()
+
+	emailQueue, err := queue.Open(queueDir, queue.Options{Workers: *queueWorkers})
+	if err != nil {
+		return fmt.Errorf("failed to open email queue: %w", err)
+	}
+	defer emailQueue.Close()
+
+	var inbound *inboundLog
+	if *inboundLogPath != "" {
+		if inbound, err = openInboundLog(*inboundLogPath); err != nil {
+			return err
+		}
+		defer inbound.Close()
+	}
+
+	claudeClient := claude.NewClient(*anthropicAPIKey)
+	claudeClient.MaxInputTokens = *maxInputTokens
+	claudeClient.MaxChunks = *maxChunks
+
 	handler := &Handler{
 		replyFromEmail:   *replyFromEmail,
-		anthropicAPIKey:  *anthropicAPIKey,
+		claudeClient:     claudeClient,
 		webarchiveClient: webarchiveClient,
 		rateLimiter:      rateLimiter,
+		store:            db,
+		reportTTL:        *reportTTL,
+		queue:            emailQueue,
+		adminToken:       *adminToken,
+		inboundLog:       inbound,
 
-		postmarkToken:           *postmarkToken,
+		mailer:                  postmark.NewClient(*postmarkToken),
 		postmarkWebhookUsername: *postmarkWebhookUsername,
 		postmarkWebhookPassword: *postmarkWebhookPassword,
 	}
 
+	emailQueue.Start(handler.handleJob)
+	if *watchInterval > 0 {
+		go handler.monitorPolicies(*watchInterval)
+	}
+
 	http.HandleFunc("/webhook", handler.handleInboundEmail)
+	http.HandleFunc("/admin/messages", handler.requireAdmin(handler.handleMessages))
+	http.HandleFunc("/admin/watches", handler.requireAdmin(handler.handleWatches))
+	http.HandleFunc("/admin/usage", handler.requireAdmin(handler.handleUsage))
 
 	log.Printf("Server starting on %s", *addr)
 	if err := http.ListenAndServe(*addr, nil); err != nil {
@@ -90,15 +167,32 @@ func run(args []string) error {
 
 type Handler struct {
 	replyFromEmail   string
-	anthropicAPIKey  string
+	claudeClient     *claude.Client
 	webarchiveClient *webarchive.Client
 	rateLimiter      *ratelimit.RateLimiter
-
-	postmarkToken           string
+	store            store.Store
+	reportTTL        time.Duration
+	queue            *queue.Queue
+	adminToken       string
+	inboundLog       *inboundLog
+	// policyClient fetches policies, or defaultPolicyClient if it's nil.
+	policyClient *http.Client
+
+	mailer                  postmark.Mailer
 	postmarkWebhookUsername string
 	postmarkWebhookPassword string
 }
 
+// allow reports whether we're under the given rate limit, and if so, counts
+// this request against it. See ratelimit.RateLimiter.IsAllowed. Without a rate
+// limiter, everything is allowed.
+func (h *Handler) allow(key string, limit int, window time.Duration) bool {
+	if h.rateLimiter == nil {
+		return true
+	}
+	return h.rateLimiter.IsAllowed(key, limit, window)
+}
+
 func textResponse(w http.ResponseWriter, msg string) {
 	if _, err := io.WriteString(w, msg); err != nil {
 		log.Printf("failed to write text response: %v", err)
@@ -151,51 +245,201 @@ func (h *Handler) handleInboundEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Received email from %s with subject: %s", email.From, email.Subject)
+	messageID := postmark.GetMessageIDFromHeaders(&email)
+	if messageID == "" {
+		log.Printf("Email from %s has no Message-ID", email.From)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
 
-	normalizedEmail := normalizeEmail(email.From)
+	h.inboundLog.record(&email)
 
-	if !h.rateLimiter.IsAllowed("classification:global", 250, time.Hour) {
-		log.Printf("Global classification rate limit exceeded")
-		http.Error(w, "Service temporarily unavailable - too many requests", http.StatusServiceUnavailable)
+	// Postmark retries webhooks that fail or don't answer quickly, so we may
+	// see the same email more than once. Acknowledge repeats without doing
+	// anything, as long as we managed to queue the first one.
+	msg, err := h.store.RecordDelivery(&store.Message{ID: messageID, From: email.From, Subject: email.Subject})
+	if err != nil {
+		// The queue ignores duplicates too, so we can carry on.
+		log.Printf("Failed to record delivery of email %q: %v", messageID, err)
+	} else if !msg.QueuedAt.IsZero() {
+		log.Printf("Ignoring delivery #%d of email %q, which we queued at %s", msg.Deliveries, messageID, msg.QueuedAt.Format(time.RFC3339))
+		textResponse(w, "Email already received")
 		return
 	}
 
-	classification, err := claude.ClassifyPolicyChange(h.anthropicAPIKey, email.Subject, email.TextBody, email.HtmlBody)
+	// Everything past this point is slow (LLM calls, fetching policies, etc), so
+	// we hand it off to the queue and let Postmark know we've got it.
+	queued, err := h.queue.Enqueue(messageID, &email)
 	if err != nil {
-		log.Printf("Error classifying email: %v", err)
-		textResponse(w, "Classification failed")
+		log.Printf("Failed to queue email %q: %v", messageID, err)
+		http.Error(w, "Failed to queue email", http.StatusInternalServerError)
+		return
+	}
+	if !queued {
+		log.Printf("Ignoring email %q, which is already queued", messageID)
+		textResponse(w, "Email already received")
 		return
 	}
+	h.updateMessage(messageID, func(m *store.Message) { m.QueuedAt = time.Now() })
+
+	log.Printf("Queued email from %s with subject: %s", email.From, email.Subject)
+	textResponse(w, "Email queued for processing")
+}
+
+// handleJob processes an email from the queue.
+func (h *Handler) handleJob(job *queue.Job) error {
+	var email postmark.InboundEmail
+	if err := job.Decode(&email); err != nil {
+		return queue.Permanent(err)
+	}
+	return h.processEmail(&email)
+}
+
+// processEmail analyzes a policy change email, and replies to the sender with
+// a summary. Errors are worth retrying (see queue.HandlerFunc); emails that we
+// can't do anything with, like ones that aren't about a policy change, are
+// recorded and otherwise dropped.
+func (h *Handler) processEmail(email *postmark.InboundEmail) error {
+	log.Printf("Processing email from %s with subject: %s", email.From, email.Subject)
+
+	messageID := postmark.GetMessageIDFromHeaders(email)
+	// The queue runs jobs at least once, so we could be retrying an email we
+	// already replied to but didn't get to mark as done.
+	var classification *claude.PolicyClassification
+	if msg, err := h.store.Message(messageID); err == nil {
+		if !msg.RepliedAt.IsZero() {
+			log.Printf("Already replied to email %q at %s, skipping", messageID, msg.RepliedAt.Format(time.RFC3339))
+			return nil
+		}
+		classification = msg.Classification
+	}
+
+	// People manage the policies they're watching by emailing us commands,
+	// which are cheap to spot, so check for those before classifying.
+	body := email.StrippedTextReply
+	if strings.TrimSpace(body) == "" {
+		body = email.TextBody
+	}
+	if cmd, ok := commands.Parse(email.Subject, body); ok {
+		return h.handleCommand(email, messageID, cmd)
+	}
+
+	// A retry already classified the email and counted it against the
+	// sender's limit on an earlier attempt, see store.Message.Classification.
+	retry := classification != nil
+	if !retry && !h.allow("classification:global", 250, time.Hour) {
+		return queue.Delay(errors.New("global classification rate limit exceeded"), rateLimitDelay)
+	}
+
+	// Keep a record of what we did with this email, and what we sent back.
+	analysis := &store.Analysis{
+		MessageID: messageID,
+		From:      email.From,
+		Subject:   email.Subject,
+	}
+	defer h.saveAnalysis(analysis)
+	// finish records how processing ended.
+	finish := func(outcome string, err error) error {
+		analysis.Outcome = outcome
+		return err
+	}
+
+	if retry {
+		log.Printf("Reusing the classification of email %q from an earlier attempt", messageID)
+	} else {
+		var err error
+		classification, err = h.claudeClient.ClassifyPolicyChange(email.Subject, email.TextBody, email.HtmlBody)
+		analysis.Usage.Add(classification.Usage)
+		if err != nil {
+			return finish("Classification failed", llmError(fmt.Errorf("failed to classify email: %w", err)))
+		}
+		h.updateMessage(messageID, func(m *store.Message) { m.Classification = classification })
+	}
+	analysis.Classification = classification
 
 	log.Printf("Classification result: isPolicyChange=%t, type=%s, company=%s, confidence=%s, policy_url=%s",
 		classification.IsPolicyChange, classification.PolicyType, classification.Company, classification.Confidence, classification.PolicyURL)
 
 	if !classification.IsPolicyChange {
 		log.Printf("Email is not a policy change notification, ignoring")
-		textResponse(w, "Email processed - not a policy change")
-		return
+		return finish("Email processed - not a policy change", nil)
 	}
 
-	if !h.rateLimiter.IsAllowed("user:"+normalizedEmail, 5, time.Hour) {
+	if normalizedEmail := ratelimit.NormalizeEmail(email.From); !retry && !h.allow("user:"+normalizedEmail, 5, time.Hour) {
 		log.Printf("Per-user rate limit exceeded for %s", normalizedEmail)
-		textResponse(w, "Rate limit exceeded - please try again later")
-		return
+		return finish("Rate limit exceeded - please try again later", nil)
 	}
 
-	if !h.rateLimiter.IsAllowed("analysis:global", 100, time.Hour) {
-		log.Printf("Global analysis rate limit exceeded")
-		textResponse(w, "Service temporarily unavailable - too many requests")
-		return
+	// Parse email date
+	emailDate, err := parseEmailDate(email.Date)
+	if err != nil {
+		log.Printf("Failed to parse email date %q, using current date: %v", email.Date, err)
+		emailDate = time.Now()
 	}
 
+	draft, err := h.analyzePolicyChange(classification, emailDate, analysis)
+	if errors.Is(err, errAnalysisRateLimited) {
+		return finish("Service temporarily unavailable - too many requests", queue.Delay(err, rateLimitDelay))
+	} else if err != nil {
+		return finish("Service temporarily unavailable - LLM request failed", err)
+	}
+	if draft == nil {
+		log.Printf("We couldn't figure out a policy URL, aborting")
+		return finish("Email processed - no policy documents found - probably our fault", nil)
+	}
+
+	emailContent, err := templates.GenerateEmail(draft.request)
+	if err != nil {
+		// This is a bug on our end, trying again won't help.
+		return finish("Failed to generate the summary email", queue.Permanent(fmt.Errorf("failed to generate email: %w", err)))
+	}
+	subject := fmt.Sprintf("Policy Change Summary: %s", classification.Company)
+	analysis.ReplySubject = subject
+	analysis.ReplyText, analysis.ReplyHTML = emailContent.TextBody, emailContent.HTMLBody
+
+	if !h.allow("email:global", 1000, time.Hour) {
+		return finish("Service temporarily unavailable - email sending limit reached", queue.Delay(errors.New("global email sending rate limit exceeded"), rateLimitDelay))
+	}
+
+	err = h.mailer.Send(postmark.NewEmail(h.replyFromEmail, email.From, subject, emailContent.TextBody, emailContent.HTMLBody, messageID, messageID, draft.attachments...))
+	if err != nil {
+		return finish("Failed to send the summary email", fmt.Errorf("failed to send summary email: %w", err))
+	}
+
+	log.Printf("Summary email sent to %s", email.From)
+	analysis.SentAt = time.Now()
+	h.updateMessage(messageID, func(m *store.Message) { m.RepliedAt = analysis.SentAt })
+
+	return finish("Policy change email processed successfully", nil)
+}
+
+// A draftReply is everything that goes into our reply to an email.
+type draftReply struct {
+	request     *templates.GenerateRequest
+	attachments []postmark.Attachment
+}
+
+// analyzePolicyChange finds the policy that changed, loads the version from
+// before the change (around changeDate), and summarizes what changed, or the
+// whole policy if there's no previous version to compare against. Everything
+// we learn along the way is recorded in analysis. It returns a nil draft if we
+// couldn't find the policy. It returns an error wrapping errAnalysisRateLimited
+// if we're over our LLM budget for now, or a temporary LLM error (see
+// claude.IsTemporary). Other LLM errors just leave out the report.
+func (h *Handler) analyzePolicyChange(classification *claude.PolicyClassification, changeDate time.Time, analysis *store.Analysis) (*draftReply, error) {
 	// Use heuristics and external APIs to come up with the policy we're looking at.
 	policyResult := h.comeUpWithAPolicyURL(classification)
 	if policyResult == nil {
-		log.Printf("We couldn't figure out a policy URL, aborting")
-		textResponse(w, "Email processed - no policy documents found - probably our fault")
-		return
+		return nil, nil
 	}
+	current := h.savePolicyVersion(&store.PolicyVersion{
+		URL:        policyResult.URL.String(),
+		Text:       policyResult.ResponseBody,
+		Source:     store.SourceLive,
+		FetchedURL: policyResult.URL.String(),
+		FetchedAt:  time.Now(),
+	})
+	analysis.PolicyURL, analysis.CurrentHash = current.URL, current.Hash
 
 	// After thinking about the email format a bit, there's only ~two sections we need to think about:
 	//
@@ -210,19 +454,14 @@ func (h *Handler) handleInboundEmail(w http.ResponseWriter, r *http.Request) {
 	// 2. If we got a policy URL, try to load that directly + via web archive
 	// 3. Load the previous version
 
-	var (
-		deltaReport   *templates.DeltaReport
-		summaryReport *templates.SummaryReport
-	)
-
-	// Parse email date
-	emailDate, err := parseEmailDate(email.Date)
-	if err != nil {
-		log.Printf("Failed to parse email date %q, using current date: %v", email.Date, err)
-		emailDate = time.Now()
+	draft := &draftReply{
+		request: &templates.GenerateRequest{
+			Classification: classification,
+			Service:        policyResult.Service,
+		},
 	}
 
-	previousVersion, previousDate, snapshotURL, err := h.loadPreviousLegalDocument(emailDate, policyResult.URL)
+	previousVersion, previousDate, snapshotURL, err := h.loadPreviousLegalDocument(changeDate, policyResult.URL)
 	if err != nil {
 		if errors.Is(err, errNoPreviousSnapshots) {
 			log.Printf("No previous snapshots found for %q", policyResult.URL.String())
@@ -231,80 +470,213 @@ func (h *Handler) handleInboundEmail(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// We have no previous version, populate the summary report
-		summaryRes, err := claude.GenerateSummaryReport(h.anthropicAPIKey, classification, policyResult.ResponseBody)
-		if err != nil {
+		summaryRes, err := h.reportPolicy(classification, analysis, policyResult.ResponseBody)
+		if retryLater(err) {
+			return nil, err
+		} else if err != nil {
 			log.Printf("Failed to generate summary report: %v", err)
 		} else {
-			summaryReport = &templates.SummaryReport{
-				Points:    policyHighlightToSummaryPoints(summaryRes.Highlights),
-				PolicyURL: policyResult.URL.String(),
-				Trimmed:   summaryRes.Trimmed,
+			draft.request.SummaryReport = &templates.SummaryReport{
+				Points:          policyHighlightToSummaryPoints(summaryRes.Highlights),
+				PolicyURL:       policyResult.URL.String(),
+				Trimmed:         summaryRes.Trimmed,
+				AnalyzedPercent: analyzedPercent(summaryRes.AnalyzedBytes, summaryRes.TotalBytes),
 			}
 		}
 	}
 
 	if previousVersion != "" {
-		edits := diff.Strings(previousVersion, policyResult.ResponseBody)
-		policyDiff, err := diff.ToUnified("previous-policy", "current-policy", previousVersion, edits, 20 /* context lines */)
-		if err != nil {
-			log.Printf("Failed to diff two policy versions (generally shouldn't happen!): %v", err)
-		}
+		previous := h.savePolicyVersion(&store.PolicyVersion{
+			URL:        policyResult.URL.String(),
+			Text:       previousVersion,
+			Source:     store.SourceWebArchive,
+			FetchedURL: snapshotURL,
+			FetchedAt:  previousDate,
+		})
+		analysis.PreviousHash = previous.Hash
+		analysis.PreviousURL, analysis.PreviousDate = snapshotURL, previousDate
 
 		// TODO: Consider loading an older policy if there's no diff here.
 		// Or TODO: Let the user know there was no diff
-		if policyDiff != "" {
-			diffSummary, err := claude.GenerateDiffReport(h.anthropicAPIKey, classification, policyDiff)
+		diffSummary, err := h.reportChanges(classification, analysis, previousVersion, policyResult.ResponseBody)
+		if retryLater(err) {
+			return nil, err
+		} else if err != nil {
+			log.Printf("Failed to generate diff report: %v", err)
+		} else if diffSummary != nil {
+			deltaReport := &templates.DeltaReport{
+				PrevDate:        previousDate.Format(time.DateOnly),
+				PrevURL:         snapshotURL,
+				YourDate:        changeDate.Format(time.DateOnly),
+				YourURL:         policyResult.URL.String(),
+				Points:          diffHighlightToSummaryPoints(diffSummary.Highlights),
+				Trimmed:         diffSummary.Trimmed,
+				AnalyzedPercent: analyzedPercent(diffSummary.AnalyzedBytes, diffSummary.TotalBytes),
+			}
+
+			// Include the actual changes, so people can check our summary against
+			// them.
+			redline, err := redlineAttachment(classification, previousVersion, policyResult.ResponseBody)
 			if err != nil {
-				log.Printf("Failed to generate diff report: %v", err)
+				log.Printf("Failed to render redline of policy changes: %v", err)
 			} else {
-				deltaReport = &templates.DeltaReport{
-					PrevDate: previousDate.Format(time.DateOnly),
-					PrevURL:  snapshotURL,
-					YourDate: emailDate.Format(time.DateOnly),
-					YourURL:  policyResult.URL.String(),
-					Points:   diffHighlightToSummaryPoints(diffSummary.Highlights),
-					Trimmed:  diffSummary.Trimmed,
-				}
+				draft.attachments = append(draft.attachments, redline)
+				deltaReport.HasRedline = true
 			}
+			draft.request.DeltaReport = deltaReport
 		}
+	}
 
+	return draft, nil
+}
+
+// reportChanges describes the changes between two versions of a policy, and
+// has the LLM summarize them, recording both in analysis. The versions are
+// identified by analysis.PolicyURL, PreviousHash and CurrentHash, which are
+// used to reuse earlier reports. It returns a nil summary if nothing changed.
+func (h *Handler) reportChanges(pc *claude.PolicyClassification, analysis *store.Analysis, previous, current string) (*claude.DiffSummary, error) {
+	changes, err := describeChanges(previous, current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff policy versions (generally shouldn't happen!): %w", err)
+	}
+	analysis.Changes = changes
+	if changes == "" {
+		return nil, nil
 	}
 
-	genReq := &templates.GenerateRequest{
-		Classification: classification,
-		Service:        policyResult.Service,
-		DeltaReport:    deltaReport,
-		SummaryReport:  summaryReport,
+	key := store.ReportKey{PolicyURL: analysis.PolicyURL, PreviousHash: analysis.PreviousHash, CurrentHash: analysis.CurrentHash}
+	report, reused, err := h.report(key, func(r *store.Report) (err error) {
+		r.Diff, err = h.claudeClient.GenerateDiffReport(pc, changes)
+		analysis.Usage.Add(r.Diff.Usage)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	analysis.ReusedReport = reused
+	analysis.DiffHighlights = report.Diff.Highlights
+	analysis.Trimmed = report.Diff.Trimmed
+	analysis.AnalyzedPercent = analyzedPercent(report.Diff.AnalyzedBytes, report.Diff.TotalBytes)
+	return report.Diff, nil
+}
 
-	emailContent, err := templates.GenerateEmail(genReq)
+// reportPolicy has the LLM summarize a policy, for when there's no previous
+// version to compare it to, recording the summary in analysis.
+func (h *Handler) reportPolicy(pc *claude.PolicyClassification, analysis *store.Analysis, current string) (*claude.PolicySummary, error) {
+	key := store.ReportKey{PolicyURL: analysis.PolicyURL, CurrentHash: analysis.CurrentHash}
+	report, reused, err := h.report(key, func(r *store.Report) (err error) {
+		r.Summary, err = h.claudeClient.GenerateSummaryReport(pc, current)
+		analysis.Usage.Add(r.Summary.Usage)
+		return err
+	})
 	if err != nil {
-		log.Printf("Error generating HTML email: %v", err)
-		textResponse(w, "Failed to generate the summary email")
-		return
+		return nil, err
 	}
-	subject := fmt.Sprintf("Policy Change Summary: %s", classification.Company)
+	analysis.ReusedReport = reused
+	analysis.SummaryHighlights = report.Summary.Highlights
+	analysis.Trimmed = report.Summary.Trimmed
+	analysis.AnalyzedPercent = analyzedPercent(report.Summary.AnalyzedBytes, report.Summary.TotalBytes)
+	return report.Summary, nil
+}
 
-	if !h.rateLimiter.IsAllowed("email:global", 1000, time.Hour) {
-		log.Printf("Global email sending rate limit exceeded")
-		textResponse(w, "Service temporarily unavailable - email sending limit reached")
-		return
+var errAnalysisRateLimited = errors.New("global analysis rate limit exceeded")
+
+// rateLimitDelay is how long an email waits in the queue when we're over one
+// of our global rate limits. Waiting doesn't use up any of the email's
+// attempts, so it can wait out an hourly limit a few minutes at a time.
+const rateLimitDelay = 5 * time.Minute
+
+// retryLater reports whether an error from generating a report means we
+// should put the email aside and try again later, rather than replying
+// without the report.
+func retryLater(err error) bool {
+	return errors.Is(err, errAnalysisRateLimited) || claude.IsTemporary(err)
+}
+
+// llmError marks errors from the LLM that won't go away on their own, like a
+// prompt that's too long, as not worth retrying.
+func llmError(err error) error {
+	if err == nil || claude.IsTemporary(err) {
+		return err
 	}
+	return queue.Permanent(err)
+}
 
-	messageID := postmark.GetMessageIDFromHeaders(&email)
-	err = postmark.SendEmailWithThreading(h.postmarkToken, h.replyFromEmail, email.From, subject, emailContent.TextBody, emailContent.HTMLBody, messageID, messageID)
-	if err != nil {
-		log.Printf("Error sending summary email: %v", err)
-		textResponse(w, "Failed to send the summary email")
+// report returns the stored report for key, if we generated one within the
+// last h.reportTTL. Big companies email all of their users about the same
+// change, so many people forward us the same policy, and there's no point
+// paying to analyze it again. Otherwise, report calls generate to fill in a new
+// report, subject to the global analysis rate limit, and stores the result.
+// The returned bool is true if the report was reused.
+func (h *Handler) report(key store.ReportKey, generate func(*store.Report) error) (*store.Report, bool, error) {
+	if h.reportTTL > 0 {
+		r, err := h.store.Report(key)
+		switch {
+		case err == nil && time.Since(r.CreatedAt) < h.reportTTL:
+			log.Printf("Reusing report from %s for %q", r.CreatedAt.Format(time.RFC3339), key.PolicyURL)
+			return r, true, nil
+		case err != nil && !errors.Is(err, store.ErrNotFound):
+			log.Printf("Failed to load stored report, generating a new one: %v", err)
+		}
+	}
+
+	if !h.allow("analysis:global", 100, time.Hour) {
+		return nil, false, errAnalysisRateLimited
+	}
+
+	r := &store.Report{Key: key}
+	if err := generate(r); err != nil {
+		return nil, false, err
+	}
+	if h.reportTTL > 0 {
+		if err := h.store.PutReport(r); err != nil {
+			log.Printf("Failed to store report for %q: %v", key.PolicyURL, err)
+		}
+	}
+	return r, false, nil
+}
+
+func (h *Handler) saveAnalysis(a *store.Analysis) {
+	if a.Usage != (claude.Usage{}) {
+		var company string
+		if a.Classification != nil {
+			company = a.Classification.Company
+		}
+		if err := h.store.AddUsage(ratelimit.NormalizeEmail(a.From), company, a.Usage); err != nil {
+			log.Printf("Failed to record LLM usage for %q: %v", a.Subject, err)
+		}
+	}
+	if err := h.store.PutAnalysis(a); err != nil {
+		log.Printf("Failed to save analysis of %q: %v", a.Subject, err)
+		return
+	}
+	if a.MessageID == "" {
+		// Not from an email, e.g. a scheduled check of a watched policy.
 		return
 	}
+	h.updateMessage(a.MessageID, func(m *store.Message) {
+		m.AnalysisIDs = append(m.AnalysisIDs, a.ID)
+		m.Outcome = a.Outcome
+	})
+}
 
-	log.Printf("Summary email sent to %s", email.From)
+// updateMessage updates our record of the inbound email with the given
+// Message-ID, logging any failure. The record is only used to spot duplicate
+// deliveries and for debugging, so it's not worth failing over.
+func (h *Handler) updateMessage(id string, fn func(*store.Message)) {
+	if err := h.store.UpdateMessage(id, fn); err != nil {
+		log.Printf("Failed to update record of email %q: %v", id, err)
+	}
+}
 
-	w.WriteHeader(http.StatusOK)
-	if _, err := fmt.Fprintf(w, "Policy change email processed successfully"); err != nil {
-		log.Printf("failed to write text response: %v", err)
+// savePolicyVersion records a version of a policy we fetched, and returns it
+// with its key fields filled in. A failure to save is logged, but otherwise
+// ignored, since it doesn't stop us from replying.
+func (h *Handler) savePolicyVersion(v *store.PolicyVersion) *store.PolicyVersion {
+	if err := h.store.PutPolicyVersion(v); err != nil {
+		log.Printf("Failed to save %s policy version of %q: %v", v.Source, v.URL, err)
 	}
+	return v
 }
 
 type PolicyLoadResult struct {
@@ -374,7 +746,7 @@ func (h *Handler) comeUpWithAPolicyURL(classification *claude.PolicyClassificati
 
 		// Now try to use the policy URL to get stuff
 		// We follow redirects (common in emails with trackers) to get the actual final URL
-		policyContents, finalPolicyURL, err := getBody(policyURL)
+		policyContents, finalPolicyURL, err := h.getBody(policyURL)
 		if err != nil {
 			log.Printf("Strategy %q gave us a URL (%q) that we couldn't load: %v", st.name, policyURL.String(), err)
 			continue
@@ -540,8 +912,15 @@ func (h *Handler) maybeGetSearchService(companyName string) (*tosdr.SearchServic
 	return &tosDRResults.Services[0], nil
 }
 
-func getBody(u *url.URL) (string, *url.URL, error) {
-	resp, err := http.Get(u.String())
+// defaultPolicyClient fetches policies, unless the Handler has its own
+// policyClient. The timeout covers reading the whole body, so a site that
+// never finishes responding can't hold up an analysis or a watch check
+// indefinitely. Policy URLs come from emails, so it only connects to public
+// addresses (see netguard), including when following redirects.
+var defaultPolicyClient = &http.Client{Timeout: 30 * time.Second, Transport: netguard.NewTransport()}
+
+func (h *Handler) getBody(u *url.URL) (string, *url.URL, error) {
+	resp, err := cmp.Or(h.policyClient, defaultPolicyClient).Get(u.String())
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to load %q: %w", u.String(), err)
 	}
@@ -551,14 +930,52 @@ func getBody(u *url.URL) (string, *url.URL, error) {
 		}
 	}()
 
-	body, err := htmlutil.ExtractText(resp.Body)
+	body, err := htmlutil.ExtractMainMarkdown(resp.Body, resp.Request.URL)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to extract text from HTML body: %w", err)
+		return "", nil, fmt.Errorf("failed to convert HTML body to Markdown: %w", err)
 	}
 
 	return body, resp.Request.URL, nil
 }
 
+// describeChanges summarizes the differences between two versions of a policy
+// for the LLM. When both versions are broken into sections, that's a
+// section-by-section change list, otherwise it's a unified diff.
+func describeChanges(previous, current string) (string, error) {
+	prevDoc, curDoc := htmlutil.DocumentFromMarkdown(previous), htmlutil.DocumentFromMarkdown(current)
+	if len(prevDoc.Sections) > 0 && len(curDoc.Sections) > 0 {
+		return sectiondiff.Format(sectiondiff.Compare(prevDoc, curDoc)), nil
+	}
+
+	// Diff by line so that reordered paragraphs show up as moves, rather than a
+	// big deletion and a big (and apparently new) insertion. The histogram
+	// algorithm keeps repeated boilerplate ("You agree...") from throwing off
+	// the alignment. Archived and live copies of a page often differ in quotes,
+	// spaces and the like, which aren't worth reporting.
+	lineEdits := diff.Normalized(previous, current, diff.DefaultNormalization, histogramDiff)
+	edits, err := diff.DetectMoves(previous, lineEdits)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect moved text: %w", err)
+	}
+	return diff.ToUnified("previous-policy", "current-policy", previous, edits, 20 /* context lines */)
+}
+
+func histogramDiff(before, after string) []diff.Edit {
+	return diff.StringsWithOptions(before, after, diff.Options{Algorithm: diff.Histogram})
+}
+
+// redlineAttachment renders the word-by-word changes between two versions of a
+// policy as an HTML redline, to attach to our reply.
+func redlineAttachment(pc *claude.PolicyClassification, previous, current string) (postmark.Attachment, error) {
+	edits := diff.Normalized(previous, current, diff.DefaultNormalization, diff.Words)
+	title := fmt.Sprintf("Changes to %s's %s", pc.Company, strings.ReplaceAll(pc.PolicyType, "_", " "))
+	page, err := diff.ToRedline(title, previous, edits, 3 /* context lines */)
+	if err != nil {
+		return postmark.Attachment{}, fmt.Errorf("failed to render redline: %w", err)
+	}
+	return postmark.NewAttachment("changes.html", "text/html", []byte(page)), nil
+}
+
 func policyHighlightToSummaryPoints(points []claude.PolicyHighlight) []templates.SummaryPoint {
 	out := make([]templates.SummaryPoint, 0, len(points))
 	for _, p := range points {
@@ -570,6 +987,16 @@ func policyHighlightToSummaryPoints(points []claude.PolicyHighlight) []templates
 	return out
 }
 
+// analyzedPercent returns how much of an LLM's input made it into a report,
+// as a whole percentage, rounded down but never to zero. It returns zero if we
+// don't know, e.g. for reports stored before we kept track.
+func analyzedPercent(analyzed, total int) int {
+	if total == 0 {
+		return 0
+	}
+	return max(analyzed*100/total, 1)
+}
+
 func diffHighlightToSummaryPoints(points []claude.DiffHighlight) []templates.SummaryPoint {
 	out := make([]templates.SummaryPoint, 0, len(points))
 	for _, p := range points {
@@ -600,25 +1027,3 @@ func parseEmailDate(dt string) (time.Time, error) {
 
 	return time.Time{}, rErr
 }
-
-func normalizeEmail(email string) string {
-	email = strings.ToLower(strings.TrimSpace(email))
-
-	parts := strings.Split(email, "@")
-	if len(parts) != 2 {
-		return email
-	}
-
-	localPart := parts[0]
-	domain := parts[1]
-
-	if domain == "gmail.com" || domain == "googlemail.com" {
-		localPart = strings.ReplaceAll(localPart, ".", "")
-		domain = "gmail.com"
-	}
-	if plusIdx := strings.Index(localPart, "+"); plusIdx != -1 {
-		localPart = localPart[:plusIdx]
-	}
-
-	return localPart + "@" + domain
-}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/bcspragu/fineprint/claude"
+	"github.com/bcspragu/fineprint/diff"
+	"github.com/bcspragu/fineprint/postmark"
+	"github.com/bcspragu/fineprint/store"
+	"github.com/bcspragu/fineprint/templates"
+)
+
+// monitorPolicies re-checks each watched policy every interval, emailing its
+// watchers when it changes. It runs forever, so call it in a goroutine.
+func (h *Handler) monitorPolicies(interval time.Duration) {
+	// Check more often than the interval, so that newly watched policies (and
+	// ones that failed last time) don't have to wait a whole interval.
+	ticker := time.NewTicker(min(interval, time.Hour))
+	defer ticker.Stop()
+	for {
+		h.checkWatchedPolicies(interval)
+		<-ticker.C
+	}
+}
+
+// checkWatchedPolicies checks every watched policy that hasn't been checked
+// within the last interval, or whose last check failed.
+func (h *Handler) checkWatchedPolicies(interval time.Duration) {
+	watched, err := h.store.WatchedPolicies()
+	if err != nil {
+		log.Printf("Failed to load watched policies: %v", err)
+		return
+	}
+	for _, wp := range watched {
+		if time.Since(wp.CheckedAt) < interval && wp.LastError == "" {
+			continue
+		}
+		err := h.checkWatchedPolicy(wp)
+		if err != nil {
+			log.Printf("Failed to check watched policy %q: %v", wp.URL, err)
+		}
+		h.updateWatchedPolicy(wp.URL, func(stored *store.WatchedPolicy) {
+			stored.CheckedAt = time.Now()
+			stored.LastError = ""
+			if err != nil {
+				stored.LastError = err.Error()
+			}
+		})
+	}
+}
+
+// checkWatchedPolicy fetches the current version of a watched policy, and if
+// it's materially different from the last version we saw, summarizes the
+// changes and emails them to everyone watching it. If that fails, even for
+// some of the watchers, the policy is left at the last version we saw, so the
+// change is picked up again on the next check, and sent to whoever we missed.
+func (h *Handler) checkWatchedPolicy(wp *store.WatchedPolicy) error {
+	// The canonical URL is only how we identify the policy, it may not be
+	// where the site serves it.
+	u, err := url.Parse(cmp.Or(wp.FetchURL, wp.URL))
+	if err != nil {
+		return fmt.Errorf("invalid policy URL: %w", err)
+	}
+	body, finalURL, err := h.getBody(u)
+	if err != nil {
+		return fmt.Errorf("failed to load policy: %w", err)
+	}
+	current := h.savePolicyVersion(&store.PolicyVersion{
+		URL:        wp.URL,
+		Text:       body,
+		Source:     store.SourceLive,
+		FetchedURL: finalURL.String(),
+		FetchedAt:  time.Now(),
+	})
+
+	if wp.Hash == current.Hash {
+		return nil
+	}
+	if wp.Hash == "" {
+		// First check, there's nothing to compare against yet.
+		log.Printf("Recorded initial version of watched policy %q", wp.URL)
+		h.updateWatchedPolicy(wp.URL, func(stored *store.WatchedPolicy) { stored.Hash = current.Hash })
+		return nil
+	}
+
+	previous, err := h.store.PolicyVersion(wp.URL, wp.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to load previous version of policy: %w", err)
+	}
+
+	// Pages change in ways that don't matter all the time, like a switch to
+	// curly quotes or a paragraph reflowed by a new CMS. Only bother people when
+	// the wording actually changed.
+	changes, err := describeChanges(previous.Text, current.Text)
+	if err != nil {
+		return fmt.Errorf("failed to diff policy versions: %w", err)
+	}
+	if !materiallyDifferent(previous.Text, current.Text) || changes == "" {
+		log.Printf("Watched policy %q changed, but not materially", wp.URL)
+		h.updateWatchedPolicy(wp.URL, func(stored *store.WatchedPolicy) { stored.Hash = current.Hash })
+		return nil
+	}
+	log.Printf("Watched policy %q changed, notifying %d watcher(s)", wp.URL, len(wp.Watchers))
+
+	pc := &claude.PolicyClassification{
+		IsPolicyChange: true,
+		PolicyType:     wp.PolicyType,
+		Company:        wp.Company,
+		PolicyURL:      wp.URL,
+	}
+	analysis := &store.Analysis{
+		Subject:        fmt.Sprintf("Scheduled check of %s", wp.URL),
+		Classification: pc,
+		PolicyURL:      wp.URL,
+		CurrentHash:    current.Hash,
+		PreviousHash:   previous.Hash,
+		Changes:        changes,
+	}
+	defer h.saveAnalysis(analysis)
+
+	key := store.ReportKey{PolicyURL: wp.URL, PreviousHash: previous.Hash, CurrentHash: current.Hash}
+	report, reused, err := h.report(key, func(r *store.Report) (err error) {
+		r.Diff, err = h.claudeClient.GenerateDiffReport(pc, changes)
+		analysis.Usage.Add(r.Diff.Usage)
+		return err
+	})
+	if err != nil {
+		analysis.Outcome = "Failed to generate diff report"
+		return fmt.Errorf("failed to generate diff report: %w", err)
+	}
+	analysis.ReusedReport = reused
+	analysis.DiffHighlights = report.Diff.Highlights
+	analysis.Trimmed = report.Diff.Trimmed
+	analysis.AnalyzedPercent = analyzedPercent(report.Diff.AnalyzedBytes, report.Diff.TotalBytes)
+
+	deltaReport := &templates.DeltaReport{
+		PrevDate:        previous.FetchedAt.Format(time.DateOnly),
+		PrevURL:         previous.FetchedURL,
+		YourDate:        current.FetchedAt.Format(time.DateOnly),
+		YourURL:         current.FetchedURL,
+		Points:          diffHighlightToSummaryPoints(report.Diff.Highlights),
+		Trimmed:         report.Diff.Trimmed,
+		AnalyzedPercent: analysis.AnalyzedPercent,
+	}
+	var attachments []postmark.Attachment
+	if redline, err := redlineAttachment(pc, previous.Text, current.Text); err != nil {
+		log.Printf("Failed to render redline of policy changes: %v", err)
+	} else {
+		attachments = append(attachments, redline)
+		deltaReport.HasRedline = true
+	}
+
+	emailContent, err := templates.GenerateEmail(&templates.GenerateRequest{
+		Classification: pc,
+		DeltaReport:    deltaReport,
+		WatchedURL:     wp.URL,
+	})
+	if err != nil {
+		analysis.Outcome = "Failed to generate the summary email"
+		return fmt.Errorf("failed to generate email: %w", err)
+	}
+	subject := fmt.Sprintf("Policy Change Summary: %s", wp.Company)
+	analysis.ReplySubject = subject
+	analysis.ReplyText, analysis.ReplyHTML = emailContent.TextBody, emailContent.HTMLBody
+
+	// Skip anyone we reached on an earlier check that failed for others.
+	unnotified := slices.DeleteFunc(slices.Clone(wp.Watchers), func(w store.Watcher) bool {
+		return w.NotifiedHash == current.Hash
+	})
+	var (
+		notified []string
+		errs     []error
+	)
+	for _, watcher := range unnotified {
+		if !h.allow("email:global", 1000, time.Hour) {
+			errs = append(errs, fmt.Errorf("global email sending rate limit exceeded, %d watcher(s) not notified", len(unnotified)-len(notified)-len(errs)))
+			break
+		}
+		err := h.mailer.Send(postmark.NewEmail(h.replyFromEmail, watcher.Email, subject, emailContent.TextBody, emailContent.HTMLBody, "", "", attachments...))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to email %s: %w", watcher.Email, err))
+			continue
+		}
+		notified = append(notified, watcher.Email)
+	}
+	if len(notified) > 0 {
+		analysis.SentAt = time.Now()
+	}
+	analysis.Outcome = fmt.Sprintf("Notified %d of %d watcher(s)", len(notified), len(unnotified))
+
+	// Only move on to the new version once everyone has heard about it.
+	h.updateWatchedPolicy(wp.URL, func(stored *store.WatchedPolicy) {
+		for _, email := range notified {
+			stored.MarkNotified(email, current.Hash)
+		}
+		if len(errs) == 0 {
+			stored.Hash = current.Hash
+			stored.ChangedAt = current.FetchedAt
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// materiallyDifferent reports whether two versions of a policy say different
+// things, ignoring typography and whitespace.
+func materiallyDifferent(previous, current string) bool {
+	p, _ := diff.Normalize(previous, diff.DefaultNormalization)
+	c, _ := diff.Normalize(current, diff.DefaultNormalization)
+	return p != c
+}
+
+func (h *Handler) updateWatchedPolicy(policyURL string, fn func(*store.WatchedPolicy)) {
+	if err := h.store.UpdateWatchedPolicy(policyURL, fn); err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Failed to update watched policy %q: %v", policyURL, err)
+	}
+}
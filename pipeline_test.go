@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bcspragu/fineprint/claude"
+	"github.com/bcspragu/fineprint/claude/claudetest"
+	"github.com/bcspragu/fineprint/internal/netguard"
+	"github.com/bcspragu/fineprint/postmark"
+	"github.com/bcspragu/fineprint/ratelimit"
+	"github.com/bcspragu/fineprint/store"
+	"github.com/bcspragu/fineprint/webarchive"
+)
+
+const (
+	previousPolicy = `<html><body><main>
+<h1>Privacy Policy</h1>
+<h2>1. Data We Collect</h2><p>We collect your email address.</p>
+<h2>2. Sharing</h2><p>We never sell your data.</p>
+</main></body></html>`
+	currentPolicy = `<html><body><main>
+<h1>Privacy Policy</h1>
+<h2>1. Data We Collect</h2><p>We collect your email address.</p>
+<h2>2. Sharing</h2><p>We sell your data to advertisers.</p>
+</main></body></html>`
+)
+
+func TestMain(m *testing.M) {
+	// The fake sites in these tests are on loopback addresses, which
+	// defaultPolicyClient otherwise refuses to connect to, and looking up the
+	// hosts in watch commands would need a network.
+	defaultPolicyClient = &http.Client{Timeout: defaultPolicyClient.Timeout}
+	netguard.Resolver = publicResolver{}
+	os.Exit(m.Run())
+}
+
+// publicResolver resolves every host to the same public address.
+type publicResolver struct{}
+
+func (publicResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	return []netip.Addr{netip.MustParseAddr("93.184.215.14")}, nil
+}
+
+// TestAnalyzePolicyChange runs an email through classification and analysis,
+// against fakes of the LLM, the company's site and the Wayback Machine.
+func TestAnalyzePolicyChange(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/privacy" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(currentPolicy))
+	}))
+	defer site.Close()
+	policyURL := site.URL + "/privacy"
+
+	// A plain handler rather than a ServeMux, which would "clean" the URL
+	// embedded in snapshot paths.
+	wayback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/cdx/search/cdx":
+			json.NewEncoder(w).Encode([][]string{
+				{"timestamp", "mimetype", "statuscode", "digest", "length"},
+				{"20240101000000", "text/html", "200", "ABC", "1234"},
+			})
+		case r.URL.Path == "/web/20240101000000/"+policyURL:
+			w.Write([]byte(previousPolicy))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer wayback.Close()
+	archive := webarchive.NewClient("", "")
+	archive.BaseURL = wayback.URL
+
+	llm := claudetest.NewServer()
+	defer llm.Close()
+	llm.Respond("classify_email", &claude.PolicyClassification{
+		IsPolicyChange: true,
+		PolicyType:     "privacy_policy",
+		Confidence:     "high",
+		PolicyURL:      policyURL,
+	})
+	llm.RespondFunc("extract_highlights", func(req *claude.Request) any {
+		if !strings.Contains(req.Messages[0].Content, "advertisers") {
+			t.Errorf("diff report prompt doesn't include the changes: %q", req.Messages[0].Content)
+		}
+		return &claude.DiffSummary{Highlights: []claude.DiffHighlight{
+			{Description: "The service now sells your data", Classification: "bad"},
+		}}
+	})
+
+	h := &Handler{
+		claudeClient:     llm.Client(),
+		webarchiveClient: archive,
+		store:            store.NewMemory(),
+		reportTTL:        time.Hour,
+	}
+
+	pc, err := h.claudeClient.ClassifyPolicyChange("We're updating our Privacy Policy", "See "+policyURL, "")
+	if err != nil {
+		t.Fatalf("ClassifyPolicyChange: %v", err)
+	}
+	analysis := &store.Analysis{Classification: pc}
+	draft, err := h.analyzePolicyChange(pc, time.Now(), analysis)
+	if err != nil {
+		t.Fatalf("analyzePolicyChange: %v", err)
+	}
+	if draft == nil {
+		t.Fatal("analyzePolicyChange didn't find the policy")
+	}
+
+	delta := draft.request.DeltaReport
+	if delta == nil {
+		t.Fatal("no delta report in the draft reply")
+	}
+	if len(delta.Points) != 1 || !delta.HasRedline || delta.PrevDate != "2024-01-01" {
+		t.Errorf("delta report = %+v, want one point, a redline and the snapshot date", delta)
+	}
+	if len(draft.attachments) != 1 {
+		t.Errorf("got %d attachments, want the redline", len(draft.attachments))
+	}
+	if !strings.HasPrefix(analysis.PreviousURL, wayback.URL+"/web/") {
+		t.Errorf("analysis.PreviousURL = %q, want a snapshot from the fake Wayback Machine", analysis.PreviousURL)
+	}
+	if !strings.Contains(analysis.Changes, "advertisers") || len(analysis.DiffHighlights) != 1 || analysis.ReusedReport {
+		t.Errorf("analysis = %+v, want the changes and a new report", analysis)
+	}
+	if analysis.Usage.InputTokens == 0 || analysis.Usage.OutputTokens == 0 {
+		t.Errorf("analysis.Usage = %+v, want the report's usage", analysis.Usage)
+	}
+
+	// The same change again reuses the report, rather than asking the LLM.
+	again := &store.Analysis{Classification: pc}
+	if _, err := h.analyzePolicyChange(pc, time.Now(), again); err != nil {
+		t.Fatalf("analyzePolicyChange: %v", err)
+	}
+	if !again.ReusedReport {
+		t.Error("second analysis of the same change didn't reuse the report")
+	}
+	if again.Usage != (claude.Usage{}) {
+		t.Errorf("reused report has usage %+v, want none", again.Usage)
+	}
+	if n := len(llm.Requests()); n != 2 {
+		t.Errorf("got %d LLM requests, want 2 (one classification, one report)", n)
+	}
+}
+
+// TestProcessEmail_RetryReusesClassification retries an email that got held up
+// by our analysis rate limit, which shouldn't classify it, or count it against
+// the sender's rate limit, a second time.
+func TestProcessEmail_RetryReusesClassification(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(currentPolicy))
+	}))
+	defer site.Close()
+	wayback := httptest.NewServer(http.NotFoundHandler())
+	defer wayback.Close()
+	archive := webarchive.NewClient("", "")
+	archive.BaseURL = wayback.URL
+
+	llm := claudetest.NewServer()
+	defer llm.Close()
+	llm.Respond("classify_email", &claude.PolicyClassification{
+		IsPolicyChange: true,
+		PolicyType:     "privacy_policy",
+		Confidence:     "high",
+		PolicyURL:      site.URL + "/privacy",
+	})
+
+	limiter := ratelimit.NewRateLimiter()
+	// Use up the analysis budget, so processing stops right after classifying.
+	for range 100 {
+		limiter.IsAllowed("analysis:global", 100, time.Hour)
+	}
+	h := &Handler{
+		claudeClient:     llm.Client(),
+		webarchiveClient: archive,
+		rateLimiter:      limiter,
+		store:            store.NewMemory(),
+	}
+
+	email := &postmark.InboundEmail{
+		From:      "someone@example.com",
+		Subject:   "We're updating our Privacy Policy",
+		TextBody:  "See " + site.URL + "/privacy",
+		MessageID: "<update@example.com>",
+	}
+	if _, err := h.store.RecordDelivery(&store.Message{ID: email.MessageID, From: email.From, Subject: email.Subject}); err != nil {
+		t.Fatalf("RecordDelivery: %v", err)
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := h.processEmail(email); !errors.Is(err, errAnalysisRateLimited) {
+			t.Fatalf("processEmail attempt %d = %v, want the analysis rate limit", attempt, err)
+		}
+	}
+
+	if n := len(llm.Requests()); n != 1 {
+		t.Errorf("got %d LLM requests, want just the first attempt's classification", n)
+	}
+	if n := limiter.GetCurrentCount("user:someone@example.com", 5, time.Hour); n != 1 {
+		t.Errorf("sender's rate limit count = %d, want 1", n)
+	}
+	analyses, err := h.store.RecentAnalyses(2)
+	if err != nil || len(analyses) != 2 {
+		t.Fatalf("RecentAnalyses = %d analyses, %v, want one per attempt", len(analyses), err)
+	}
+	// Both attempts have the classification, but only the first paid for it.
+	withUsage := 0
+	for _, a := range analyses {
+		if a.Classification == nil || !a.Classification.IsPolicyChange {
+			t.Errorf("analysis %+v doesn't have the classification", a)
+		}
+		if a.Usage != (claude.Usage{}) {
+			withUsage++
+		}
+	}
+	if withUsage != 1 {
+		t.Errorf("%d analyses used tokens, want just the first attempt's", withUsage)
+	}
+}
+
+func TestReportChanges_RecordsUsageOnFailure(t *testing.T) {
+	llm := claudetest.NewServer()
+	defer llm.Close()
+	// Invalid both times, so the report fails after a correction.
+	llm.Respond("extract_highlights", map[string]any{"highlights": "none"})
+	h := &Handler{
+		claudeClient: llm.Client(),
+		store:        store.NewMemory(),
+		reportTTL:    time.Hour,
+	}
+
+	analysis := &store.Analysis{PolicyURL: "https://example.com/privacy", PreviousHash: "old", CurrentHash: "new"}
+	if _, err := h.reportChanges(&claude.PolicyClassification{Company: "Example"}, analysis, "We never sell your data.", "We sell your data."); !errors.Is(err, claude.ErrInvalidOutput) {
+		t.Fatalf("reportChanges error = %v, want %v", err, claude.ErrInvalidOutput)
+	}
+	if n := len(llm.Requests()); n != 2 {
+		t.Errorf("got %d LLM requests, want the report and its correction", n)
+	}
+	if analysis.Usage.InputTokens == 0 || analysis.Usage.OutputTokens == 0 {
+		t.Errorf("analysis.Usage = %+v, want the failed requests' usage", analysis.Usage)
+	}
+}
@@ -0,0 +1,77 @@
+package postmark
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DirMailer is a Mailer that writes emails to a directory instead of sending
+// them, so we can see what we would have sent. Emails are numbered in the
+// order they're sent, and email number n is written as:
+//
+//   - n.json: the request, without its bodies or attachments
+//   - n.txt and n.html: the text and HTML bodies
+//   - n-<name>: each attachment
+type DirMailer struct {
+	dir string
+
+	mu sync.Mutex
+	n  int
+}
+
+// NewDirMailer returns a DirMailer that writes to dir, creating it if needed.
+func NewDirMailer(dir string) (*DirMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &DirMailer{dir: dir}, nil
+}
+
+func (d *DirMailer) Send(email *EmailRequest) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.n++
+	prefix := filepath.Join(d.dir, fmt.Sprint(d.n))
+
+	meta := *email
+	meta.TextBody, meta.HtmlBody, meta.Attachments = "", "", nil
+	for _, a := range email.Attachments {
+		// Keep track of what was attached, without the content.
+		meta.Attachments = append(meta.Attachments, Attachment{Name: a.Name, ContentType: a.ContentType})
+	}
+	metaJSON, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal email: %w", err)
+	}
+
+	files := map[string][]byte{
+		prefix + ".json": append(metaJSON, '\n'),
+	}
+	if email.TextBody != "" {
+		files[prefix+".txt"] = []byte(email.TextBody)
+	}
+	if email.HtmlBody != "" {
+		files[prefix+".html"] = []byte(email.HtmlBody)
+	}
+	for _, a := range email.Attachments {
+		data, err := base64.StdEncoding.DecodeString(a.Content)
+		if err != nil {
+			return fmt.Errorf("failed to decode attachment %q: %w", a.Name, err)
+		}
+		// Attachment names come from us, but don't let one escape the directory.
+		name := strings.NewReplacer("/", "_", `\`, "_").Replace(a.Name)
+		files[prefix+"-"+name] = data
+	}
+
+	for path, data := range files {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return fmt.Errorf("failed to write email: %w", err)
+		}
+	}
+	return nil
+}
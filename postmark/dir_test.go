@@ -0,0 +1,62 @@
+package postmark
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	m, err := NewDirMailer(dir)
+	if err != nil {
+		t.Fatalf("NewDirMailer: %v", err)
+	}
+
+	emails := []*EmailRequest{
+		NewEmail("us@example.com", "you@example.com", "Summary", "text", "<p>html</p>", "<id@example.com>", "<id@example.com>",
+			NewAttachment("changes.html", "text/html", []byte("<del>old</del>"))),
+		NewEmail("us@example.com", "you@example.com", "Re: list", "You aren't watching any policies.", "", "", ""),
+	}
+	for _, e := range emails {
+		if err := m.Send(e); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	want := map[string]string{
+		"1.txt":          "text",
+		"1.html":         "<p>html</p>",
+		"1-changes.html": "<del>old</del>",
+		"2.txt":          "You aren't watching any policies.",
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("failed to read %s: %v", name, err)
+			continue
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2.html")); !os.IsNotExist(err) {
+		t.Errorf("2.html exists for an email without an HTML body, err = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "1.json"))
+	if err != nil {
+		t.Fatalf("failed to read 1.json: %v", err)
+	}
+	var meta EmailRequest
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("failed to parse 1.json: %v", err)
+	}
+	if meta.To != "you@example.com" || meta.Subject != "Summary" || len(meta.Headers) != 2 {
+		t.Errorf("1.json = %+v, want the recipient, subject and threading headers", meta)
+	}
+	if len(meta.Attachments) != 1 || meta.Attachments[0].Name != "changes.html" || meta.Attachments[0].Content != "" {
+		t.Errorf("1.json attachments = %+v, want changes.html without content", meta.Attachments)
+	}
+}
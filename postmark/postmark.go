@@ -2,6 +2,7 @@ package postmark
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -39,13 +40,14 @@ type InboundEmail struct {
 }
 
 type EmailRequest struct {
-	From          string   `json:"From"`
-	To            string   `json:"To"`
-	Subject       string   `json:"Subject"`
-	TextBody      string   `json:"TextBody,omitempty"`
-	HtmlBody      string   `json:"HtmlBody,omitempty"`
-	MessageStream string   `json:"MessageStream,omitempty"`
-	Headers       []Header `json:"Headers,omitempty"`
+	From          string       `json:"From"`
+	To            string       `json:"To"`
+	Subject       string       `json:"Subject"`
+	TextBody      string       `json:"TextBody,omitempty"`
+	HtmlBody      string       `json:"HtmlBody,omitempty"`
+	MessageStream string       `json:"MessageStream,omitempty"`
+	Headers       []Header     `json:"Headers,omitempty"`
+	Attachments   []Attachment `json:"Attachments,omitempty"`
 }
 
 type Header struct {
@@ -53,6 +55,23 @@ type Header struct {
 	Value string `json:"Value"`
 }
 
+// Attachment is a file attached to an outbound email.
+type Attachment struct {
+	Name string `json:"Name"`
+	// Content is the base64-encoded file content.
+	Content     string `json:"Content"`
+	ContentType string `json:"ContentType"`
+}
+
+// NewAttachment returns an Attachment holding data.
+func NewAttachment(name, contentType string, data []byte) Attachment {
+	return Attachment{
+		Name:        name,
+		Content:     base64.StdEncoding.EncodeToString(data),
+		ContentType: contentType,
+	}
+}
+
 type EmailResponse struct {
 	MessageID   string `json:"MessageID"`
 	SubmittedAt string `json:"SubmittedAt"`
@@ -74,22 +93,51 @@ func SendEmail(serverToken, from, to, subject, textBody, htmlBody string) error
 	return SendEmailWithThreading(serverToken, from, to, subject, textBody, htmlBody, "", "")
 }
 
-func SendEmailWithThreading(serverToken, from, to, subject, textBody, htmlBody, inReplyTo, references string) error {
-	if serverToken == "" {
-		return fmt.Errorf("POSTMARK_SERVER_TOKEN not provided")
-	}
+// SendEmailWithThreading sends an email through the Postmark API, threaded as a
+// reply if inReplyTo and references are set.
+func SendEmailWithThreading(serverToken, from, to, subject, textBody, htmlBody, inReplyTo, references string, attachments ...Attachment) error {
+	return NewClient(serverToken).Send(NewEmail(from, to, subject, textBody, htmlBody, inReplyTo, references, attachments...))
+}
 
-	emailReq := EmailRequest{
+// NewEmail returns a request to send an email. If inReplyTo and references
+// are set, it's threaded as a reply to that Message-ID.
+func NewEmail(from, to, subject, textBody, htmlBody, inReplyTo, references string, attachments ...Attachment) *EmailRequest {
+	emailReq := &EmailRequest{
 		From:          from,
 		To:            to,
 		Subject:       subject,
 		TextBody:      textBody,
 		HtmlBody:      htmlBody,
 		MessageStream: "outbound",
-		Headers: []Header{
-			{Name: "In-Reply-To", Value: inReplyTo},
-			{Name: "References", Value: references},
-		},
+		Attachments:   attachments,
+	}
+	// Emails that aren't replies (like SendEmail) don't get threading headers.
+	if inReplyTo != "" {
+		emailReq.Headers = append(emailReq.Headers, Header{Name: "In-Reply-To", Value: inReplyTo})
+	}
+	if references != "" {
+		emailReq.Headers = append(emailReq.Headers, Header{Name: "References", Value: references})
+	}
+	return emailReq
+}
+
+// A Mailer sends emails.
+type Mailer interface {
+	Send(email *EmailRequest) error
+}
+
+// Client is a Mailer that sends emails through the Postmark API.
+type Client struct {
+	serverToken string
+}
+
+func NewClient(serverToken string) *Client {
+	return &Client{serverToken: serverToken}
+}
+
+func (c *Client) Send(emailReq *EmailRequest) error {
+	if c.serverToken == "" {
+		return fmt.Errorf("POSTMARK_SERVER_TOKEN not provided")
 	}
 
 	jsonData, err := json.Marshal(emailReq)
@@ -103,7 +151,7 @@ func SendEmailWithThreading(serverToken, from, to, subject, textBody, htmlBody,
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Postmark-Server-Token", serverToken)
+	req.Header.Set("X-Postmark-Server-Token", c.serverToken)
 	req.Header.Set("Accept", "application/json")
 
 	client := &http.Client{}
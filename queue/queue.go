@@ -0,0 +1,450 @@
+// Package queue implements a small, durable job queue backed by a directory of
+// JSON files, so that work accepted over a webhook survives restarts and can be
+// retried without the sender having to resend it.
+//
+// Jobs are identified by a caller-provided ID (e.g. an email's Message-ID),
+// and a job is only ever accepted once: enqueueing an ID that's pending,
+// finished or dead-lettered is a no-op. Jobs are processed at least once; a
+// crash in the middle of a job means it runs again on the next start.
+package queue
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bcspragu/fineprint/internal/jsonfile"
+)
+
+// A Job is a unit of work in the queue.
+type Job struct {
+	// ID identifies the job, see Enqueue.
+	ID string `json:"id"`
+	// Payload is the job's data, as JSON.
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	// Attempts is the number of times the job has been tried so far.
+	Attempts int `json:"attempts"`
+	// NextAttempt is the earliest time the job should be tried again.
+	NextAttempt time.Time `json:"next_attempt,omitzero"`
+	// LastError is the error from the last failed attempt.
+	LastError string `json:"last_error,omitempty"`
+	// FinishedAt is when the job succeeded or was dead-lettered.
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+}
+
+// Decode unmarshals the job's payload into v.
+func (j *Job) Decode(v any) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return fmt.Errorf("failed to decode payload of job %q: %w", j.ID, err)
+	}
+	return nil
+}
+
+// A HandlerFunc processes a job. Returning an error retries the job later,
+// unless the error is wrapped with Permanent or the job is out of attempts, in
+// which case it's dead-lettered. Errors wrapped with Delay are retried without
+// using up an attempt.
+type HandlerFunc func(job *Job) error
+
+type permanentError struct{ err error }
+
+func (p permanentError) Error() string { return p.err.Error() }
+func (p permanentError) Unwrap() error { return p.err }
+
+// Permanent wraps err to mark it as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+type delayError struct {
+	err   error
+	delay time.Duration
+}
+
+func (d delayError) Error() string { return d.err.Error() }
+func (d delayError) Unwrap() error { return d.err }
+
+// Delay wraps err to retry the job after d, without counting it as an attempt.
+// It's for failures that say nothing about the job itself, like being over a
+// rate limit, which would otherwise use up a job's attempts before the limit
+// resets.
+func Delay(err error, d time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return delayError{err: err, delay: d}
+}
+
+// Options configure a Queue. Zero values get sensible defaults.
+type Options struct {
+	// Workers is the number of jobs processed concurrently, default 4.
+	Workers int
+	// MaxAttempts is the number of times a job is tried before it's
+	// dead-lettered, default 5.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the delay before retrying a failed job,
+	// which doubles with each attempt. They default to 30 seconds and 30
+	// minutes.
+	MinBackoff, MaxBackoff time.Duration
+	// Retention is how long finished jobs are remembered, to reject duplicates,
+	// default 30 days. Dead-lettered jobs are kept until they're removed by
+	// hand.
+	Retention time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 30 * time.Second
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = max(30*time.Minute, o.MinBackoff)
+	}
+	if o.Retention <= 0 {
+		o.Retention = 30 * 24 * time.Hour
+	}
+}
+
+// Queue is a durable job queue, stored in a directory with a subdirectory for
+// each state a job can be in:
+//
+//	<dir>/pending/  jobs waiting to run, or to be retried
+//	<dir>/done/     finished jobs, without their payloads
+//	<dir>/dead/     jobs that failed permanently, or ran out of attempts
+//
+// To retry a dead-lettered job, move its file back to pending/ and restart.
+type Queue struct {
+	dir  string
+	opts Options
+
+	mu      sync.Mutex
+	pending map[string]*Job // by file name
+	running map[string]bool // by file name
+	// wake is closed (and replaced) whenever there might be new work.
+	wake chan struct{}
+
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Open opens the queue stored in dir, creating it if needed. Call Start to
+// begin processing jobs.
+func Open(dir string, opts Options) (*Queue, error) {
+	opts.setDefaults()
+	for _, sub := range []string{"pending", "done", "dead"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create queue directory: %w", err)
+		}
+	}
+
+	q := &Queue{
+		dir:     dir,
+		opts:    opts,
+		pending: make(map[string]*Job),
+		running: make(map[string]bool),
+		wake:    make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "pending"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending jobs: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		var job Job
+		if err := jsonfile.Read(filepath.Join(dir, "pending", e.Name()), &job); err != nil {
+			return nil, fmt.Errorf("failed to load pending job: %w", err)
+		}
+		q.pending[e.Name()] = &job
+	}
+	if len(q.pending) > 0 {
+		log.Printf("Loaded %d pending jobs from %s", len(q.pending), dir)
+	}
+
+	if err := q.prune(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// prune forgets finished jobs that are older than the retention period.
+func (q *Queue) prune() error {
+	doneDir := filepath.Join(q.dir, "done")
+	entries, err := os.ReadDir(doneDir)
+	if err != nil {
+		return fmt.Errorf("failed to list finished jobs: %w", err)
+	}
+	cutoff := time.Now().Add(-q.opts.Retention)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(doneDir, e.Name())); err != nil {
+			log.Printf("Failed to prune finished job %s: %v", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+// fileName returns the name of the file for the job with the given ID. IDs
+// like Message-IDs aren't safe to use as file names, so we hash them.
+func fileName(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
+
+// Enqueue adds a job with the given ID and payload, which is encoded as JSON.
+// It returns false, and doesn't add anything, if a job with the same ID is
+// already pending, finished or dead-lettered. Once Enqueue returns, the job is
+// on disk.
+func (q *Queue) Enqueue(id string, payload any) (bool, error) {
+	if id == "" {
+		return false, errors.New("job ID is required")
+	}
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	name := fileName(id)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.pending[name]; ok {
+		return false, nil
+	}
+	for _, sub := range []string{"done", "dead"} {
+		if _, err := os.Stat(filepath.Join(q.dir, sub, name)); err == nil {
+			return false, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return false, fmt.Errorf("failed to check for existing job: %w", err)
+		}
+	}
+
+	job := &Job{ID: id, Payload: dat, EnqueuedAt: time.Now()}
+	if err := jsonfile.Write(filepath.Join(q.dir, "pending", name), job); err != nil {
+		return false, fmt.Errorf("failed to write job: %w", err)
+	}
+	q.pending[name] = job
+	q.notify()
+	return true, nil
+}
+
+// Len returns the number of pending jobs, including ones that are running.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// notify wakes up idle workers. q.mu must be held.
+func (q *Queue) notify() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// Start starts the workers, which call handle for each job until Close is
+// called.
+func (q *Queue) Start(handle HandlerFunc) {
+	for range q.opts.Workers {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			q.work(handle)
+		}()
+	}
+}
+
+// Close stops the workers, waiting for any running jobs to finish. Pending
+// jobs stay on disk, and are picked up the next time the queue is opened.
+func (q *Queue) Close() error {
+	select {
+	case <-q.closed:
+	default:
+		close(q.closed)
+	}
+	q.wg.Wait()
+	return nil
+}
+
+func (q *Queue) work(handle HandlerFunc) {
+	for {
+		name, job, wait, wake := q.next()
+		if job == nil {
+			timer := time.NewTimer(wait)
+			select {
+			case <-q.closed:
+				timer.Stop()
+				return
+			case <-wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+
+		err := run(handle, job)
+		q.finish(name, job, err)
+
+		select {
+		case <-q.closed:
+			return
+		default:
+		}
+	}
+}
+
+// next claims the oldest job that's ready to run. If there isn't one, it
+// returns how long to wait until one might be, and a channel that's closed if
+// that changes sooner.
+func (q *Queue) next() (string, *Job, time.Duration, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var (
+		bestName string
+		best     *Job
+		wait     = time.Minute
+	)
+	for name, job := range q.pending {
+		if q.running[name] {
+			continue
+		}
+		if job.NextAttempt.After(now) {
+			wait = min(wait, job.NextAttempt.Sub(now))
+			continue
+		}
+		if best == nil || job.EnqueuedAt.Before(best.EnqueuedAt) {
+			bestName, best = name, job
+		}
+	}
+	if best == nil {
+		return "", nil, wait, q.wake
+	}
+
+	q.running[bestName] = true
+	// Hand out a copy, so the handler can't race with Enqueue's lookups.
+	cp := *best
+	return bestName, &cp, 0, nil
+}
+
+// run calls handle, turning panics into errors so that one bad job doesn't
+// take down the process.
+func run(handle HandlerFunc, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handle(job)
+}
+
+// finish records the result of an attempt at a job.
+func (q *Queue) finish(name string, job *Job, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.notify()
+	delete(q.running, name)
+
+	now := time.Now()
+	pendingPath := filepath.Join(q.dir, "pending", name)
+
+	var delay delayError
+	if errors.As(err, &delay) {
+		job.LastError = err.Error()
+		job.NextAttempt = now.Add(delay.delay)
+		log.Printf("Job %q delayed until %s: %v", job.ID, job.NextAttempt.Format(time.RFC3339), err)
+		q.update(name, pendingPath, job)
+		return
+	}
+
+	job.Attempts++
+
+	if err == nil {
+		job.FinishedAt = now
+		job.Payload = nil
+		job.LastError = ""
+		if err := jsonfile.Write(filepath.Join(q.dir, "done", name), job); err != nil {
+			log.Printf("Failed to record job %q as done, it may run again: %v", job.ID, err)
+			return
+		}
+		q.remove(name, pendingPath)
+		return
+	}
+
+	job.LastError = err.Error()
+	if IsPermanent(err) || job.Attempts >= q.opts.MaxAttempts {
+		log.Printf("Job %q failed after %d attempt(s), dead-lettering it: %v", job.ID, job.Attempts, err)
+		job.FinishedAt = now
+		if err := jsonfile.Write(filepath.Join(q.dir, "dead", name), job); err != nil {
+			log.Printf("Failed to dead-letter job %q: %v", job.ID, err)
+			return
+		}
+		q.remove(name, pendingPath)
+		return
+	}
+
+	job.NextAttempt = now.Add(q.backoff(job.Attempts))
+	log.Printf("Job %q failed on attempt %d, retrying at %s: %v", job.ID, job.Attempts, job.NextAttempt.Format(time.RFC3339), err)
+	q.update(name, pendingPath, job)
+}
+
+// update records a pending job's new state. q.mu must be held.
+func (q *Queue) update(name, pendingPath string, job *Job) {
+	if err := jsonfile.Write(pendingPath, job); err != nil {
+		// We'll still retry it, we just won't remember the attempt if we
+		// restart.
+		log.Printf("Failed to update job %q: %v", job.ID, err)
+	}
+	q.pending[name] = job
+}
+
+func (q *Queue) remove(name, pendingPath string) {
+	delete(q.pending, name)
+	if err := os.Remove(pendingPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to remove pending job %s: %v", name, err)
+	}
+}
+
+// backoff returns the delay before the given attempt, with some jitter so
+// that jobs that failed together don't all retry together.
+func (q *Queue) backoff(attempt int) time.Duration {
+	d := q.opts.MinBackoff
+	for i := 1; i < attempt && d < q.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, q.opts.MaxBackoff)
+	jitter := time.Duration(rand.Int64N(int64(d)/5 + 1))
+	return d - d/10 + jitter
+}
@@ -0,0 +1,215 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bcspragu/fineprint/internal/jsonfile"
+)
+
+var fastRetries = Options{Workers: 2, MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+// waitFor polls until cond is true, or fails the test after a few seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func countFiles(t *testing.T, dir string) int {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	return len(matches)
+}
+
+func TestQueue_ProcessesJobs(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, fastRetries)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	q.Start(func(job *Job) error {
+		var payload struct{ Subject string }
+		if err := job.Decode(&payload); err != nil {
+			return Permanent(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, payload.Subject)
+		return nil
+	})
+	defer q.Close()
+
+	for _, id := range []string{"<a@example.com>", "<b@example.com>"} {
+		ok, err := q.Enqueue(id, map[string]string{"Subject": id})
+		if err != nil || !ok {
+			t.Fatalf("Enqueue(%q) = %t, %v, want true, nil", id, ok, err)
+		}
+	}
+	waitFor(t, func() bool { return q.Len() == 0 })
+
+	mu.Lock()
+	if len(got) != 2 {
+		t.Errorf("processed %v, want both jobs", got)
+	}
+	mu.Unlock()
+
+	if n := countFiles(t, filepath.Join(dir, "done")); n != 2 {
+		t.Errorf("%d finished jobs on disk, want 2", n)
+	}
+
+	// Finished jobs can't be enqueued again.
+	if ok, err := q.Enqueue("<a@example.com>", nil); err != nil || ok {
+		t.Errorf("Enqueue(duplicate) = %t, %v, want false, nil", ok, err)
+	}
+}
+
+func TestQueue_RetriesAndDeadLetters(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int // Number of times the job fails before succeeding.
+		err          error
+		wantAttempts int
+		wantDead     bool
+	}{
+		{"succeeds after retries", 2, errors.New("temporary"), 3, false},
+		{"out of attempts", 10, errors.New("temporary"), 3, true},
+		{"permanent", 10, Permanent(errors.New("bad payload")), 1, true},
+		{"delays don't use up attempts", 10, Delay(errors.New("rate limited"), time.Millisecond), 11, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q, err := Open(dir, fastRetries)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+
+			var (
+				mu       sync.Mutex
+				attempts int
+			)
+			q.Start(func(job *Job) error {
+				mu.Lock()
+				defer mu.Unlock()
+				attempts++
+				if attempts <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			defer q.Close()
+
+			if _, err := q.Enqueue("job", "payload"); err != nil {
+				t.Fatalf("Enqueue: %v", err)
+			}
+			waitFor(t, func() bool { return q.Len() == 0 })
+
+			mu.Lock()
+			if attempts != tt.wantAttempts {
+				t.Errorf("job attempted %d times, want %d", attempts, tt.wantAttempts)
+			}
+			mu.Unlock()
+
+			dead := countFiles(t, filepath.Join(dir, "dead"))
+			if tt.wantDead && dead != 1 {
+				t.Errorf("%d dead-lettered jobs, want 1", dead)
+			} else if !tt.wantDead && dead != 0 {
+				t.Errorf("%d dead-lettered jobs, want none", dead)
+			}
+
+			// Dead-lettered jobs aren't accepted again either.
+			if ok, err := q.Enqueue("job", "payload"); err != nil || ok {
+				t.Errorf("Enqueue(duplicate) = %t, %v, want false, nil", ok, err)
+			}
+		})
+	}
+}
+
+func TestQueue_Panics(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, Options{MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	q.Start(func(job *Job) error { panic("oops") })
+	defer q.Close()
+
+	if _, err := q.Enqueue("job", nil); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	waitFor(t, func() bool { return q.Len() == 0 })
+
+	var job Job
+	if err := jsonfile.Read(filepath.Join(dir, "dead", fileName("job")), &job); err != nil {
+		t.Fatalf("reading dead-lettered job: %v", err)
+	}
+	if job.LastError != "job panicked: oops" {
+		t.Errorf("LastError = %q, want the panic", job.LastError)
+	}
+}
+
+func TestQueue_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, fastRetries)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	// Not started, so the job stays pending.
+	if _, err := q.Enqueue("job", "payload"); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	q.Close()
+
+	q, err = Open(dir, fastRetries)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if q.Len() != 1 {
+		t.Fatalf("Len() = %d after reopening, want 1", q.Len())
+	}
+	if ok, _ := q.Enqueue("job", "payload"); ok {
+		t.Error("Enqueue accepted a duplicate of a pending job")
+	}
+
+	done := make(chan string, 1)
+	q.Start(func(job *Job) error {
+		var payload string
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+		done <- payload
+		return nil
+	})
+	defer q.Close()
+
+	select {
+	case got := <-done:
+		if got != "payload" {
+			t.Errorf("payload = %q, want %q", got, "payload")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job wasn't processed after restart")
+	}
+	waitFor(t, func() bool { return q.Len() == 0 })
+	if _, err := os.Stat(filepath.Join(dir, "pending", fileName("job"))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("pending job file still exists: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package ratelimit
 
 import (
+	"strings"
 	"sync"
 	"time"
 )
@@ -123,3 +124,27 @@ func (rl *RateLimiter) GetCurrentCount(key string, limit int, window time.Durati
 
 	return count
 }
+
+// NormalizeEmail canonicalizes an email address for use as a rate limit key,
+// so that trivial variations (case, Gmail dots, +tags) share a bucket.
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	parts := strings.Split(email, "@")
+	if len(parts) != 2 {
+		return email
+	}
+
+	localPart := parts[0]
+	domain := parts[1]
+
+	if domain == "gmail.com" || domain == "googlemail.com" {
+		localPart = strings.ReplaceAll(localPart, ".", "")
+		domain = "gmail.com"
+	}
+	if plusIdx := strings.Index(localPart, "+"); plusIdx != -1 {
+		localPart = localPart[:plusIdx]
+	}
+
+	return localPart + "@" + domain
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bcspragu/fineprint/claude"
+	"github.com/bcspragu/fineprint/postmark"
+	"github.com/bcspragu/fineprint/store"
+	"github.com/bcspragu/fineprint/webarchive"
+	"github.com/peterbourgon/ff/v3"
+)
+
+const replayUsage = `Usage: fineprint replay [flags] <inbound.jsonl>
+
+Runs recorded inbound emails (see --inbound-log) through the same processing
+as the webhook, one at a time, and writes the emails we would have sent to
+--out instead of sending them. Email number n in the input gets its own
+directory, <out>/<n>, holding our replies (see postmark.DirMailer) and the
+analyses we recorded along the way, so two runs can be compared with diff -r.
+
+Flags:
+`
+
+// runReplay implements "fineprint replay", for regression testing changes to
+// prompts and templates against real emails.
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), replayUsage)
+		fs.PrintDefaults()
+	}
+	var (
+		anthropicAPIKey  = fs.String("anthropic-api-key", "", "Anthropic API key")
+		archiveAccessKey = fs.String("archive-access-key", "", "Internet Archive access key")
+		archiveSecretKey = fs.String("archive-secret-key", "", "Internet Archive secret key")
+		replyFromEmail   = fs.String("reply-from-email", "replay@fineprint.invalid", "Email address replies would be sent from")
+
+		out       = fs.String("out", "", "Directory to write the emails we would have sent to")
+		reportTTL = fs.Duration("report-ttl", 0, "How long to reuse an LLM report for the same policy change within the replay. Zero, the default, generates a new report for every email")
+	)
+	if err := ff.Parse(fs, args, ff.WithEnvVars()); err != nil {
+		return fmt.Errorf("failed to parse flags: %w", err)
+	}
+	if *out == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected --out and a file of recorded emails")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to open recorded emails: %w", err)
+	}
+	defer f.Close()
+
+	h := &Handler{
+		replyFromEmail:   *replyFromEmail,
+		claudeClient:     claude.NewClient(*anthropicAPIKey),
+		webarchiveClient: webarchive.NewClient(*archiveAccessKey, *archiveSecretKey),
+		// Real traffic can easily exceed the per-user limits when replayed all at
+		// once, which would make for an unhelpful comparison.
+		rateLimiter: nil,
+		store:       store.NewMemory(),
+		reportTTL:   *reportTTL,
+	}
+	defer h.store.Close()
+
+	var failed int
+	dec := json.NewDecoder(f)
+	for n := 1; ; n++ {
+		var email postmark.InboundEmail
+		if err := dec.Decode(&email); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read email #%d: %w", n, err)
+		}
+		outcome, err := h.replayEmail(&email, filepath.Join(*out, fmt.Sprint(n)))
+		if err != nil {
+			failed++
+			outcome = fmt.Sprintf("error: %v", err)
+		}
+		fmt.Printf("%d\t%s\t%s\n", n, email.Subject, outcome)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d email(s) failed", failed)
+	}
+	return nil
+}
+
+// replayEmail processes an email as if it came in through the webhook, with
+// any replies written to dir. It returns the outcome recorded for the email.
+func (h *Handler) replayEmail(email *postmark.InboundEmail, dir string) (string, error) {
+	messageID := postmark.GetMessageIDFromHeaders(email)
+	if messageID == "" {
+		return "skipped, no Message-ID", nil
+	}
+
+	// Duplicate deliveries are part of real traffic, so handle them like the
+	// webhook does.
+	msg, err := h.store.RecordDelivery(&store.Message{ID: messageID, From: email.From, Subject: email.Subject})
+	if err != nil {
+		return "", fmt.Errorf("failed to record delivery: %w", err)
+	}
+	if !msg.QueuedAt.IsZero() {
+		return fmt.Sprintf("skipped, delivery #%d of an email we already processed", msg.Deliveries), nil
+	}
+	h.updateMessage(messageID, func(m *store.Message) { m.QueuedAt = time.Now() })
+
+	mailer, err := postmark.NewDirMailer(dir)
+	if err != nil {
+		return "", err
+	}
+	h.mailer = mailer
+	// Unlike the queue, don't retry failures, they'd likely just fail again.
+	procErr := h.processEmail(email)
+
+	msg, err = h.store.Message(messageID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load record of email: %w", err)
+	}
+	var analyses []*store.Analysis
+	for _, id := range msg.AnalysisIDs {
+		a, err := h.store.Analysis(id)
+		if err != nil {
+			return "", fmt.Errorf("failed to load analysis %q: %w", id, err)
+		}
+		analyses = append(analyses, a)
+	}
+	data, err := json.MarshalIndent(analyses, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal analyses: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "analyses.json"), append(data, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write analyses: %w", err)
+	}
+
+	if procErr != nil {
+		return "", procErr
+	}
+	return msg.Outcome, nil
+}
+
+// An inboundLog records inbound emails to a file, one JSON object per line,
+// for replaying later with "fineprint replay". A nil *inboundLog records
+// nothing.
+type inboundLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openInboundLog(path string) (*inboundLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open inbound email log: %w", err)
+	}
+	return &inboundLog{f: f}, nil
+}
+
+func (l *inboundLog) record(email *postmark.InboundEmail) {
+	if l == nil {
+		return
+	}
+	data, err := json.Marshal(email)
+	if err != nil {
+		log.Printf("Failed to marshal email for the inbound log: %v", err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write email to the inbound log: %v", err)
+	}
+}
+
+func (l *inboundLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,373 @@
+// Package sectiondiff compares two versions of a legal document section by
+// section, rather than line by line. It matches up sections between versions
+// by heading, number and content, and reports which sections were added,
+// removed, renamed, moved or modified, along with word-level edits for
+// modified sections.
+package sectiondiff
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/bcspragu/fineprint/diff"
+	"github.com/bcspragu/fineprint/htmlutil"
+)
+
+// ChangeKind describes how a section changed between versions. A single
+// section can be changed in several ways at once (e.g. renamed and modified),
+// so kinds are bit flags.
+type ChangeKind uint8
+
+const (
+	// Added sections only exist in the new version.
+	Added ChangeKind = 1 << iota
+	// Removed sections only exist in the old version.
+	Removed
+	// Renamed sections have a different title (not just a different number).
+	Renamed
+	// Moved sections appear in a different position or under a different parent.
+	Moved
+	// Modified sections have different body text.
+	Modified
+)
+
+var kindNames = []struct {
+	kind ChangeKind
+	name string
+}{
+	{Added, "added"},
+	{Removed, "removed"},
+	{Renamed, "renamed"},
+	{Moved, "moved"},
+	{Modified, "modified"},
+}
+
+func (k ChangeKind) String() string {
+	var names []string
+	for _, kn := range kindNames {
+		if k&kn.kind != 0 {
+			names = append(names, kn.name)
+		}
+	}
+	if len(names) == 0 {
+		return "unchanged"
+	}
+	return strings.Join(names, ", ")
+}
+
+// A Change describes what happened to a single section.
+type Change struct {
+	Kind ChangeKind
+	// Old is the section in the old version, or nil if it was added.
+	Old *htmlutil.Section
+	// New is the section in the new version, or nil if it was removed.
+	New *htmlutil.Section
+	// Edits transform Old.Body into New.Body, ignoring typographic differences
+	// like curly quotes (see diff.Normalized), and are only set for modified
+	// sections. Edits start and end on word boundaries (see diff.Words).
+	Edits []diff.Edit
+}
+
+// preambleID is the ID given to the pseudo-section representing the text
+// before a document's first heading.
+const preambleID = "preamble"
+
+// Compare returns the changes needed to turn old into new, in the order the
+// affected sections appear in new (with removed sections last). Unchanged
+// sections aren't included.
+func Compare(old, new *htmlutil.Document) []Change {
+	oldSecs, newSecs := flatten(old), flatten(new)
+	pairs := match(oldSecs, newSecs)
+
+	moved := movedPairs(oldSecs, newSecs, pairs)
+
+	var changes []Change
+	for ni, newSec := range newSecs {
+		oi, ok := pairs[ni]
+		if !ok {
+			changes = append(changes, Change{Kind: Added, New: newSec})
+			continue
+		}
+		oldSec := oldSecs[oi]
+
+		var c Change
+		if normalize(oldSec.Title) != normalize(newSec.Title) {
+			c.Kind |= Renamed
+		}
+		if moved[ni] {
+			c.Kind |= Moved
+		}
+		if normalize(oldSec.Body) != normalize(newSec.Body) {
+			c.Kind |= Modified
+			c.Edits = diff.Normalized(oldSec.Body, newSec.Body, diff.DefaultNormalization, diff.Words)
+		}
+		if c.Kind != 0 {
+			c.Old, c.New = oldSec, newSec
+			changes = append(changes, c)
+		}
+	}
+
+	matchedOld := make(map[int]bool)
+	for _, oi := range pairs {
+		matchedOld[oi] = true
+	}
+	for oi, oldSec := range oldSecs {
+		if !matchedOld[oi] {
+			changes = append(changes, Change{Kind: Removed, Old: oldSec})
+		}
+	}
+
+	return changes
+}
+
+// flatten returns the sections of doc in document order, with the preamble
+// (if any) as a pseudo-section at the front.
+func flatten(doc *htmlutil.Document) []*htmlutil.Section {
+	var out []*htmlutil.Section
+	if doc.Preamble != "" {
+		out = append(out, &htmlutil.Section{ID: preambleID, Body: doc.Preamble})
+	}
+	return append(out, doc.AllSections()...)
+}
+
+// Thresholds for matching sections by content when their headings differ.
+const (
+	minContentScore  = 0.5
+	minNumberedScore = 0.3
+)
+
+// match pairs up sections between versions, returning a map from indexes in
+// newSecs to indexes in oldSecs. It matches in passes, from most to least
+// certain: identical headings, identical titles (ignoring renumbering), and
+// finally similar content.
+func match(oldSecs, newSecs []*htmlutil.Section) map[int]int {
+	pairs := make(map[int]int)
+	usedOld := make(map[int]bool)
+
+	matchUnique := func(key func(*htmlutil.Section) string) {
+		oldByKey := make(map[string][]int)
+		for oi, s := range oldSecs {
+			if !usedOld[oi] {
+				k := key(s)
+				oldByKey[k] = append(oldByKey[k], oi)
+			}
+		}
+		newByKey := make(map[string][]int)
+		for ni, s := range newSecs {
+			if _, ok := pairs[ni]; !ok {
+				k := key(s)
+				newByKey[k] = append(newByKey[k], ni)
+			}
+		}
+		for k, nis := range newByKey {
+			ois := oldByKey[k]
+			if k == "" || len(nis) != 1 || len(ois) != 1 {
+				continue
+			}
+			pairs[nis[0]] = ois[0]
+			usedOld[ois[0]] = true
+		}
+	}
+
+	matchUnique(func(s *htmlutil.Section) string {
+		if s.ID == preambleID {
+			return preambleID
+		}
+		return normalize(s.Heading())
+	})
+	matchUnique(func(s *htmlutil.Section) string { return normalize(s.Title) })
+
+	type candidate struct {
+		oi, ni int
+		score  float64
+	}
+	var cands []candidate
+	for ni, newSec := range newSecs {
+		if _, ok := pairs[ni]; ok {
+			continue
+		}
+		for oi, oldSec := range oldSecs {
+			if usedOld[oi] {
+				continue
+			}
+			score := 0.7*similarity(oldSec.Body, newSec.Body) + 0.3*similarity(oldSec.Title, newSec.Title)
+			threshold := minContentScore
+			if oldSec.Number != "" && oldSec.Number == newSec.Number {
+				threshold = minNumberedScore
+			}
+			if score >= threshold {
+				cands = append(cands, candidate{oi, ni, score})
+			}
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].score > cands[j].score })
+	for _, c := range cands {
+		if _, ok := pairs[c.ni]; ok || usedOld[c.oi] {
+			continue
+		}
+		pairs[c.ni] = c.oi
+		usedOld[c.oi] = true
+	}
+
+	return pairs
+}
+
+// movedPairs returns the indexes (in newSecs) of matched sections that moved,
+// either because their parent section changed, or because they're out of
+// order relative to their siblings.
+func movedPairs(oldSecs, newSecs []*htmlutil.Section, pairs map[int]int) map[int]bool {
+	oldParents, newParents := parents(oldSecs), parents(newSecs)
+
+	moved := make(map[int]bool)
+	siblings := make(map[int][]int) // new parent index -> new indexes
+	for ni, oi := range pairs {
+		oldParent, newParent := oldParents[oi], newParents[ni]
+		sameParent := oldParent < 0 && newParent < 0
+		if newParent >= 0 {
+			if op, ok := pairs[newParent]; ok && op == oldParent {
+				sameParent = true
+			}
+		}
+		if !sameParent {
+			moved[ni] = true
+			continue
+		}
+		siblings[newParent] = append(siblings[newParent], ni)
+	}
+
+	// Among sections that kept their parent, the longest run that kept their
+	// relative order stayed put, and everything else moved.
+	for _, nis := range siblings {
+		slices.Sort(nis)
+		ois := make([]int, len(nis))
+		for i, ni := range nis {
+			ois[i] = pairs[ni]
+		}
+		for i, inOrder := range diff.LongestIncreasing(ois) {
+			if !inOrder {
+				moved[nis[i]] = true
+			}
+		}
+	}
+	return moved
+}
+
+// parents returns, for each section in secs, the index of its parent section,
+// or -1 for top-level sections.
+func parents(secs []*htmlutil.Section) []int {
+	index := make(map[*htmlutil.Section]int, len(secs))
+	for i, s := range secs {
+		index[s] = i
+	}
+	out := make([]int, len(secs))
+	for i := range out {
+		out[i] = -1
+	}
+	for i, s := range secs {
+		for _, c := range s.Children {
+			out[index[c]] = i
+		}
+	}
+	return out
+}
+
+// similarity returns the Dice coefficient of the words in a and b, between 0
+// (nothing in common) and 1 (the same words, possibly reordered).
+func similarity(a, b string) float64 {
+	wa, wb := words(a), words(b)
+	if len(wa) == 0 && len(wb) == 0 {
+		return 1
+	}
+	counts := make(map[string]int)
+	for _, w := range wa {
+		counts[w]++
+	}
+	common := 0
+	for _, w := range wb {
+		if counts[w] > 0 {
+			counts[w]--
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(wa)+len(wb))
+}
+
+// words returns the lowercased words of s, ignoring punctuation.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// normalize lowercases s, folds typographic differences (see
+// diff.Normalize) and collapses whitespace, for comparisons that shouldn't be
+// sensitive to formatting.
+func normalize(s string) string {
+	s, _ = diff.Normalize(s, diff.DefaultNormalization)
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// Format renders changes as a compact, human (and LLM) readable change list.
+// Edits inside modified sections are shown inline, as [-removed-]{+added+}.
+func Format(changes []Change) string {
+	var sb strings.Builder
+	for i, c := range changes {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		writeChange(&sb, c)
+	}
+	return sb.String()
+}
+
+func writeChange(sb *strings.Builder, c Change) {
+	switch {
+	case c.Kind&Added != 0:
+		fmt.Fprintf(sb, "=== %s: added\n%s\n", label(c.New), sectionText(c.New))
+		return
+	case c.Kind&Removed != 0:
+		fmt.Fprintf(sb, "=== %s: removed\n%s\n", label(c.Old), sectionText(c.Old))
+		return
+	}
+
+	fmt.Fprintf(sb, "=== %s: %s", label(c.New), c.Kind)
+	if c.Kind&Renamed != 0 || (c.Old.Number != c.New.Number && c.Old.Number != "") {
+		fmt.Fprintf(sb, " (was %s)", label(c.Old))
+	}
+	sb.WriteString("\n")
+	if c.Kind&Modified == 0 {
+		sb.WriteString("(text unchanged)\n")
+		return
+	}
+	inline, err := diff.ToWordDiff(c.Old.Body, c.Edits, inlineContext)
+	if err != nil {
+		// Only possible if the caller modified the change, so just show the new
+		// text instead.
+		inline = c.New.Body
+	}
+	sb.WriteString(inline)
+	sb.WriteString("\n")
+}
+
+// inlineContext is the number of words of unchanged context shown on either
+// side of an edit.
+const inlineContext = 8
+
+// label returns a short description of s for use in Format.
+func label(s *htmlutil.Section) string {
+	if s.ID == preambleID {
+		return "Introduction (text before the first section)"
+	}
+	return fmt.Sprintf("Section %q", s.Heading())
+}
+
+// sectionText returns the body of s, or a placeholder if it's empty.
+func sectionText(s *htmlutil.Section) string {
+	if s.Body == "" {
+		return "(no text)"
+	}
+	return s.Body
+}
@@ -0,0 +1,146 @@
+package sectiondiff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bcspragu/fineprint/htmlutil"
+)
+
+const oldPolicy = `# Acme Terms
+
+Last updated: January 1, 2024
+
+## 1. Accounts
+
+You must be at least 13 years old to create an account.
+
+## 2. Payment
+
+Fees are billed monthly and are non-refundable.
+
+## 3. Privacy
+
+We collect your email address and usage data to operate the service.
+
+## 4. Termination
+
+We may suspend your account if you violate these terms.
+`
+
+const newPolicy = `# Acme Terms
+
+Last updated: March 1, 2025
+
+## 1. Accounts
+
+You must be at least 13 years old to create an account.
+
+## 2. Termination
+
+We may suspend your account if you violate these terms.
+
+## 3. Billing
+
+Fees are billed monthly and are non-refundable.
+
+## 4. Privacy
+
+We collect your email address, location and usage data to operate and improve the service.
+
+## 5. Arbitration
+
+Disputes will be resolved by binding arbitration.
+`
+
+func TestCompare(t *testing.T) {
+	changes := Compare(htmlutil.DocumentFromMarkdown(oldPolicy), htmlutil.DocumentFromMarkdown(newPolicy))
+
+	type summary struct {
+		kind     ChangeKind
+		old, new string
+	}
+	var got []summary
+	for _, c := range changes {
+		var s summary
+		s.kind = c.Kind
+		if c.Old != nil {
+			s.old = c.Old.Heading()
+		}
+		if c.New != nil {
+			s.new = c.New.Heading()
+		}
+		got = append(got, s)
+	}
+
+	want := []summary{
+		{Modified, "", ""}, // The preamble.
+		{Moved, "4 Termination", "2 Termination"},
+		{Renamed, "2 Payment", "3 Billing"},
+		{Modified, "3 Privacy", "4 Privacy"},
+		{Added, "", "5 Arbitration"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestCompare_Removed(t *testing.T) {
+	old := htmlutil.DocumentFromMarkdown("## 1. Data\n\nWe collect data.\n\n## 2. Ads\n\nWe show ads.")
+	new := htmlutil.DocumentFromMarkdown("## 1. Data\n\nWe collect data.")
+
+	changes := Compare(old, new)
+	if len(changes) != 1 {
+		t.Fatalf("got %d changes, want 1: %+v", len(changes), changes)
+	}
+	if c := changes[0]; c.Kind != Removed || c.Old.Heading() != "2 Ads" || c.New != nil {
+		t.Errorf("unexpected change %+v", c)
+	}
+}
+
+func TestCompare_MovedToNewParent(t *testing.T) {
+	old := htmlutil.DocumentFromMarkdown("## 1. Data\n\nAbout data.\n\n### Cookies\n\nWe use cookies.\n\n## 2. Sharing\n\nAbout sharing.")
+	new := htmlutil.DocumentFromMarkdown("## 1. Data\n\nAbout data.\n\n## 2. Sharing\n\nAbout sharing.\n\n### Cookies\n\nWe use cookies.")
+
+	changes := Compare(old, new)
+	if len(changes) != 1 {
+		t.Fatalf("got %d changes, want 1: %+v", len(changes), changes)
+	}
+	if c := changes[0]; c.Kind != Moved || c.New.Title != "Cookies" {
+		t.Errorf("unexpected change %+v", c)
+	}
+}
+
+func TestCompare_Identical(t *testing.T) {
+	doc := htmlutil.DocumentFromMarkdown(oldPolicy)
+	if changes := Compare(doc, doc); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
+func TestCompare_IgnoresTypography(t *testing.T) {
+	old := htmlutil.DocumentFromMarkdown("## 1. Data\n\nWe don't sell \"your\" data - ever.")
+	new := htmlutil.DocumentFromMarkdown("## 1. Data\n\nWe don’t sell “your”\u00a0data — ever.")
+
+	if changes := Compare(old, new); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	changes := Compare(htmlutil.DocumentFromMarkdown(oldPolicy), htmlutil.DocumentFromMarkdown(newPolicy))
+	got := Format(changes)
+
+	for _, want := range []string{
+		`=== Introduction (text before the first section): modified` + "\nLast updated: [-January-]{+March+} 1, [-2024-]{+2025+}\n",
+		`=== Section "2 Termination": moved (was Section "4 Termination")` + "\n(text unchanged)\n",
+		`=== Section "3 Billing": renamed (was Section "2 Payment")`,
+		`=== Section "4 Privacy": modified (was Section "3 Privacy")` + "\nWe collect your email [-address and-]{+address, location and+} usage data to operate{+ and improve+} the service.\n",
+		`=== Section "5 Arbitration": added` + "\nDisputes will be resolved by binding arbitration.\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Format() output is missing %q, got:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bcspragu/fineprint/claude"
+	"github.com/bcspragu/fineprint/internal/jsonfile"
+)
+
+// Disk is a Store that keeps each record in its own JSON file under a
+// directory:
+//
+//	<dir>/policies/<HashText(url)>/<hash>.json
+//	<dir>/analyses/<id>.json
+//	<dir>/reports/<HashText(url)>/<previous hash>-<current hash>.json
+//	<dir>/messages/<HashText(Message-ID)>.json
+//	<dir>/watches/<HashText(url)>.json
+//	<dir>/pending/<token>.json
+//	<dir>/usage.json
+//
+// Files are written atomically (to a temporary file that's then renamed), so a
+// crash never leaves a partial record behind. The layout is simple enough to
+// inspect, back up or prune with standard tools.
+type Disk struct {
+	dir string
+
+	// mu serializes writes, so that concurrent read-modify-write updates (and
+	// existence checks) don't race.
+	mu sync.Mutex
+}
+
+// OpenDisk returns a Store backed by dir, creating it if needed.
+func OpenDisk(dir string) (*Disk, error) {
+	for _, sub := range []string{"policies", "analyses", "reports", "messages", "watches", "pending"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create store directory: %w", err)
+		}
+	}
+	return &Disk{dir: dir}, nil
+}
+
+func (d *Disk) policyDir(policyURL string) string {
+	// URLs make poor file names, so we use their hash instead. The canonical
+	// URL is stored in each file.
+	return filepath.Join(d.dir, "policies", HashText(CanonicalURL(policyURL)))
+}
+
+func (d *Disk) reportPath(key ReportKey) (string, error) {
+	for _, hash := range []string{key.PreviousHash, key.CurrentHash} {
+		if strings.ContainsAny(hash, `/\.`) {
+			return "", fmt.Errorf("invalid hash %q", hash)
+		}
+	}
+	name := key.PreviousHash + "-" + key.CurrentHash + ".json"
+	return filepath.Join(d.dir, "reports", HashText(CanonicalURL(key.PolicyURL)), name), nil
+}
+
+func (d *Disk) analysisPath(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid analysis ID %q", id)
+	}
+	return filepath.Join(d.dir, "analyses", id+".json"), nil
+}
+
+func (d *Disk) PutPolicyVersion(v *PolicyVersion) error {
+	prepareVersion(v)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dir := d.policyDir(v.URL)
+	path := filepath.Join(dir, v.Hash+".json")
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create policy directory: %w", err)
+	}
+	if err := jsonfile.Write(path, v); err != nil {
+		return fmt.Errorf("failed to write policy version: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) PolicyVersion(policyURL, hash string) (*PolicyVersion, error) {
+	if hash == "" || strings.ContainsAny(hash, `/\.`) {
+		return nil, ErrNotFound
+	}
+	var v PolicyVersion
+	if err := readJSON(filepath.Join(d.policyDir(policyURL), hash+".json"), &v); err != nil {
+		return nil, fmt.Errorf("failed to read policy version: %w", err)
+	}
+	return &v, nil
+}
+
+func (d *Disk) PolicyVersions(policyURL string) ([]*PolicyVersion, error) {
+	dir := d.policyDir(policyURL)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list policy versions: %w", err)
+	}
+
+	var out []*PolicyVersion
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		var v PolicyVersion
+		if err := readJSON(filepath.Join(dir, e.Name()), &v); err != nil {
+			return nil, fmt.Errorf("failed to read policy version: %w", err)
+		}
+		out = append(out, &v)
+	}
+	sortVersions(out)
+	return out, nil
+}
+
+func (d *Disk) PutAnalysis(a *Analysis) error {
+	prepareAnalysis(a, time.Now())
+
+	path, err := d.analysisPath(a.ID)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := jsonfile.Write(path, a); err != nil {
+		return fmt.Errorf("failed to write analysis: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) Analysis(id string) (*Analysis, error) {
+	path, err := d.analysisPath(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var a Analysis
+	if err := readJSON(path, &a); err != nil {
+		return nil, fmt.Errorf("failed to read analysis: %w", err)
+	}
+	return &a, nil
+}
+
+func (d *Disk) RecentAnalyses(limit int) ([]*Analysis, error) {
+	entries, err := os.ReadDir(filepath.Join(d.dir, "analyses"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list analyses: %w", err)
+	}
+
+	// ReadDir sorts by name, and IDs sort chronologically, see newID.
+	var out []*Analysis
+	for _, e := range slices.Backward(entries) {
+		if len(out) >= limit {
+			break
+		}
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok {
+			continue
+		}
+		a, err := d.Analysis(id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+func (d *Disk) messagePath(id string) string {
+	// Message-IDs are full of characters that don't belong in file names.
+	return filepath.Join(d.dir, "messages", HashText(id)+".json")
+}
+
+func (d *Disk) RecordDelivery(m *Message) (*Message, error) {
+	now := time.Now()
+	path := d.messagePath(m.ID)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var stored Message
+	err := readJSON(path, &stored)
+	switch {
+	case err == nil:
+		stored.LastSeen = now
+		stored.Deliveries++
+	case errors.Is(err, ErrNotFound):
+		stored = *m
+		stored.FirstSeen, stored.LastSeen, stored.Deliveries = now, now, 1
+	default:
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	if err := jsonfile.Write(path, &stored); err != nil {
+		return nil, fmt.Errorf("failed to write message: %w", err)
+	}
+	return &stored, nil
+}
+
+func (d *Disk) UpdateMessage(id string, fn func(*Message)) error {
+	path := d.messagePath(id)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var m Message
+	if err := readJSON(path, &m); err != nil {
+		return fmt.Errorf("failed to read message: %w", err)
+	}
+	fn(&m)
+	if err := jsonfile.Write(path, &m); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) Message(id string) (*Message, error) {
+	var m Message
+	if err := readJSON(d.messagePath(id), &m); err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	return &m, nil
+}
+
+func (d *Disk) RecentMessages(limit int) ([]*Message, error) {
+	dir := filepath.Join(d.dir, "messages")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	// File names are hashes, so there's no way around reading all of them to
+	// find the most recent. This is only for debugging, so that's fine.
+	var out []*Message
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		var m Message
+		if err := readJSON(filepath.Join(dir, e.Name()), &m); err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		out = append(out, &m)
+	}
+	sortMessages(out)
+	return out[:min(limit, len(out))], nil
+}
+
+func (d *Disk) watchPath(policyURL string) string {
+	return filepath.Join(d.dir, "watches", HashText(CanonicalURL(policyURL))+".json")
+}
+
+func (d *Disk) AddWatcher(wp *WatchedPolicy, email string) (*WatchedPolicy, error) {
+	path := d.watchPath(wp.URL)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var stored WatchedPolicy
+	switch err := readJSON(path, &stored); {
+	case errors.Is(err, ErrNotFound):
+		stored = *wp.clone()
+		stored.URL = CanonicalURL(wp.URL)
+		stored.FetchURL = cmp.Or(wp.FetchURL, wp.URL)
+	case err != nil:
+		return nil, fmt.Errorf("failed to read watched policy: %w", err)
+	}
+
+	stored.addWatcher(email, time.Now())
+	if err := jsonfile.Write(path, &stored); err != nil {
+		return nil, fmt.Errorf("failed to write watched policy: %w", err)
+	}
+	return &stored, nil
+}
+
+func (d *Disk) RemoveWatcher(policyURL, email string) error {
+	path := d.watchPath(policyURL)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var wp WatchedPolicy
+	if err := readJSON(path, &wp); err != nil {
+		return fmt.Errorf("failed to read watched policy: %w", err)
+	}
+	if !wp.removeWatcher(email) {
+		return ErrNotFound
+	}
+	if len(wp.Watchers) == 0 {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove watched policy: %w", err)
+		}
+		return nil
+	}
+	if err := jsonfile.Write(path, &wp); err != nil {
+		return fmt.Errorf("failed to write watched policy: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) UpdateWatchedPolicy(policyURL string, fn func(*WatchedPolicy)) error {
+	path := d.watchPath(policyURL)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var wp WatchedPolicy
+	if err := readJSON(path, &wp); err != nil {
+		return fmt.Errorf("failed to read watched policy: %w", err)
+	}
+	fn(&wp)
+	if err := jsonfile.Write(path, &wp); err != nil {
+		return fmt.Errorf("failed to write watched policy: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) WatchedPolicies() ([]*WatchedPolicy, error) {
+	dir := filepath.Join(d.dir, "watches")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list watched policies: %w", err)
+	}
+
+	var out []*WatchedPolicy
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		var wp WatchedPolicy
+		if err := readJSON(filepath.Join(dir, e.Name()), &wp); err != nil {
+			return nil, fmt.Errorf("failed to read watched policy: %w", err)
+		}
+		out = append(out, &wp)
+	}
+	return out, nil
+}
+
+func (d *Disk) pendingPath(token string) (string, error) {
+	if !validToken(token) {
+		return "", fmt.Errorf("invalid pending watch token %q", token)
+	}
+	return filepath.Join(d.dir, "pending", token+".json"), nil
+}
+
+func (d *Disk) AddPendingWatch(pw *PendingWatch) error {
+	preparePendingWatch(pw, time.Now())
+
+	path, err := d.pendingPath(pw.Token)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := jsonfile.Write(path, pw); err != nil {
+		return fmt.Errorf("failed to write pending watch: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) PendingWatch(token string) (*PendingWatch, error) {
+	path, err := d.pendingPath(token)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var pw PendingWatch
+	if err := readJSON(path, &pw); err != nil {
+		return nil, fmt.Errorf("failed to read pending watch: %w", err)
+	}
+	return &pw, nil
+}
+
+func (d *Disk) RemovePendingWatch(token string) error {
+	path, err := d.pendingPath(token)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := os.Remove(path); errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		return fmt.Errorf("failed to remove pending watch: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) PutReport(r *Report) error {
+	prepareReport(r, time.Now())
+
+	path, err := d.reportPath(r.Key)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	if err := jsonfile.Write(path, r); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) Report(key ReportKey) (*Report, error) {
+	path, err := d.reportPath(key)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var r Report
+	if err := readJSON(path, &r); err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+	return &r, nil
+}
+
+func (d *Disk) usagePath() string {
+	return filepath.Join(d.dir, "usage.json")
+}
+
+func (d *Disk) AddUsage(email, company string, u claude.Usage) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	totals, err := d.readUsage()
+	if err != nil {
+		return err
+	}
+	totals.add(email, company, u)
+	if err := jsonfile.Write(d.usagePath(), totals); err != nil {
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) Usage() (*UsageTotals, error) {
+	return d.readUsage()
+}
+
+// readUsage reads the usage totals, which start out empty.
+func (d *Disk) readUsage() (*UsageTotals, error) {
+	totals := newUsageTotals()
+	if err := readJSON(d.usagePath(), totals); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+	return totals, nil
+}
+
+func (d *Disk) Close() error {
+	return nil
+}
+
+// readJSON decodes the file at path into v, returning ErrNotFound if it
+// doesn't exist.
+func readJSON(path string, v any) error {
+	if err := jsonfile.Read(path, v); errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
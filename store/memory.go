@@ -0,0 +1,319 @@
+package store
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bcspragu/fineprint/claude"
+)
+
+// Memory is a Store that keeps everything in memory, for tests and for
+// running without a data directory.
+type Memory struct {
+	mu       sync.RWMutex
+	versions map[string]map[string]*PolicyVersion // url -> hash -> version
+	analyses map[string]*Analysis
+	reports  map[ReportKey]*Report
+	messages map[string]*Message
+	watches  map[string]*WatchedPolicy // by canonical URL
+	pending  map[string]*PendingWatch  // by token
+	usage    *UsageTotals
+}
+
+// NewMemory returns an empty in-memory Store.
+func NewMemory() *Memory {
+	return &Memory{
+		versions: make(map[string]map[string]*PolicyVersion),
+		analyses: make(map[string]*Analysis),
+		reports:  make(map[ReportKey]*Report),
+		messages: make(map[string]*Message),
+		watches:  make(map[string]*WatchedPolicy),
+		pending:  make(map[string]*PendingWatch),
+		usage:    newUsageTotals(),
+	}
+}
+
+func (m *Memory) PutPolicyVersion(v *PolicyVersion) error {
+	prepareVersion(v)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byHash, ok := m.versions[v.URL]
+	if !ok {
+		byHash = make(map[string]*PolicyVersion)
+		m.versions[v.URL] = byHash
+	}
+	if _, ok := byHash[v.Hash]; !ok {
+		cp := *v
+		byHash[v.Hash] = &cp
+	}
+	return nil
+}
+
+func (m *Memory) PolicyVersion(policyURL, hash string) (*PolicyVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	v, ok := m.versions[CanonicalURL(policyURL)][hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *v
+	return &cp, nil
+}
+
+func (m *Memory) PolicyVersions(policyURL string) ([]*PolicyVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var out []*PolicyVersion
+	for _, v := range m.versions[CanonicalURL(policyURL)] {
+		cp := *v
+		out = append(out, &cp)
+	}
+	sortVersions(out)
+	return out, nil
+}
+
+func (m *Memory) PutAnalysis(a *Analysis) error {
+	prepareAnalysis(a, time.Now())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cp := *a
+	m.analyses[a.ID] = &cp
+	return nil
+}
+
+func (m *Memory) Analysis(id string) (*Analysis, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a, ok := m.analyses[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *a
+	return &cp, nil
+}
+
+func (m *Memory) RecentAnalyses(limit int) ([]*Analysis, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.analyses))
+	for id := range m.analyses {
+		ids = append(ids, id)
+	}
+	// IDs sort chronologically, see newID.
+	slices.SortFunc(ids, func(a, b string) int { return strings.Compare(b, a) })
+
+	var out []*Analysis
+	for _, id := range ids[:min(limit, len(ids))] {
+		cp := *m.analyses[id]
+		out = append(out, &cp)
+	}
+	return out, nil
+}
+
+func (m *Memory) RecordDelivery(msg *Message) (*Message, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.messages[msg.ID]
+	if ok {
+		stored.LastSeen = now
+		stored.Deliveries++
+	} else {
+		cp := *msg
+		cp.FirstSeen, cp.LastSeen, cp.Deliveries = now, now, 1
+		stored = &cp
+		m.messages[msg.ID] = stored
+	}
+	out := *stored
+	out.AnalysisIDs = slices.Clone(stored.AnalysisIDs)
+	return &out, nil
+}
+
+func (m *Memory) UpdateMessage(id string, fn func(*Message)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.messages[id]
+	if !ok {
+		return ErrNotFound
+	}
+	cp := *msg
+	cp.AnalysisIDs = slices.Clone(msg.AnalysisIDs)
+	fn(&cp)
+	m.messages[id] = &cp
+	return nil
+}
+
+func (m *Memory) Message(id string) (*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	msg, ok := m.messages[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *msg
+	cp.AnalysisIDs = slices.Clone(msg.AnalysisIDs)
+	return &cp, nil
+}
+
+func (m *Memory) RecentMessages(limit int) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]*Message, 0, len(m.messages))
+	for _, msg := range m.messages {
+		cp := *msg
+		cp.AnalysisIDs = slices.Clone(msg.AnalysisIDs)
+		out = append(out, &cp)
+	}
+	sortMessages(out)
+	return out[:min(limit, len(out))], nil
+}
+
+func (m *Memory) AddWatcher(wp *WatchedPolicy, email string) (*WatchedPolicy, error) {
+	policyURL := CanonicalURL(wp.URL)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.watches[policyURL]
+	if !ok {
+		stored = wp.clone()
+		stored.URL = policyURL
+		stored.FetchURL = cmp.Or(wp.FetchURL, wp.URL)
+		m.watches[policyURL] = stored
+	}
+	stored.addWatcher(email, time.Now())
+	return stored.clone(), nil
+}
+
+func (m *Memory) RemoveWatcher(policyURL, email string) error {
+	policyURL = CanonicalURL(policyURL)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wp, ok := m.watches[policyURL]
+	if !ok || !wp.removeWatcher(email) {
+		return ErrNotFound
+	}
+	if len(wp.Watchers) == 0 {
+		delete(m.watches, policyURL)
+	}
+	return nil
+}
+
+func (m *Memory) UpdateWatchedPolicy(policyURL string, fn func(*WatchedPolicy)) error {
+	policyURL = CanonicalURL(policyURL)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wp, ok := m.watches[policyURL]
+	if !ok {
+		return ErrNotFound
+	}
+	cp := wp.clone()
+	fn(cp)
+	m.watches[policyURL] = cp
+	return nil
+}
+
+func (m *Memory) WatchedPolicies() ([]*WatchedPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]*WatchedPolicy, 0, len(m.watches))
+	for _, wp := range m.watches {
+		out = append(out, wp.clone())
+	}
+	return out, nil
+}
+
+func (m *Memory) AddPendingWatch(pw *PendingWatch) error {
+	preparePendingWatch(pw, time.Now())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending[pw.Token] = pw.clone()
+	return nil
+}
+
+func (m *Memory) PendingWatch(token string) (*PendingWatch, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pw, ok := m.pending[token]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return pw.clone(), nil
+}
+
+func (m *Memory) RemovePendingWatch(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pending[token]; !ok {
+		return ErrNotFound
+	}
+	delete(m.pending, token)
+	return nil
+}
+
+func (m *Memory) PutReport(r *Report) error {
+	prepareReport(r, time.Now())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cp := *r
+	m.reports[r.Key] = &cp
+	return nil
+}
+
+func (m *Memory) Report(key ReportKey) (*Report, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.reports[key.canonical()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *r
+	return &cp, nil
+}
+
+func (m *Memory) AddUsage(email, company string, u claude.Usage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.usage.add(email, company, u)
+	return nil
+}
+
+func (m *Memory) Usage() (*UsageTotals, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.usage.clone(), nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
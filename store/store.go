@@ -0,0 +1,503 @@
+// Package store persists what we learn while handling emails: the policy
+// documents we fetch (keyed by canonical URL and content hash) and a record of
+// each analysis we run, including what we sent back. That gives us an audit
+// trail of every reply, and lets us reuse work across requests.
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bcspragu/fineprint/claude"
+)
+
+// ErrNotFound is returned when a requested record doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// Store is a persistent store of policy versions and analyses. Stores are safe
+// for concurrent use.
+type Store interface {
+	// PutPolicyVersion records v. Versions are keyed by URL (which is
+	// canonicalized, see CanonicalURL) and Hash (which is computed from Text if
+	// empty), and storing a version that already exists is a no-op, so the
+	// original FetchedAt is kept.
+	PutPolicyVersion(v *PolicyVersion) error
+	// PolicyVersion returns the version of the policy at policyURL with the
+	// given hash, or ErrNotFound.
+	PolicyVersion(policyURL, hash string) (*PolicyVersion, error)
+	// PolicyVersions returns every stored version of the policy at policyURL,
+	// oldest first.
+	PolicyVersions(policyURL string) ([]*PolicyVersion, error)
+
+	// PutAnalysis creates or replaces a, assigning it an ID and CreatedAt time
+	// if it doesn't have them yet.
+	PutAnalysis(a *Analysis) error
+	// Analysis returns the analysis with the given ID, or ErrNotFound.
+	Analysis(id string) (*Analysis, error)
+	// RecentAnalyses returns up to limit analyses, most recent first.
+	RecentAnalyses(limit int) ([]*Analysis, error)
+
+	// RecordDelivery records that we received the inbound email m.ID, and
+	// returns the updated record. The first time, it stores m with Deliveries
+	// set to 1. After that, it only bumps the stored record's LastSeen and
+	// Deliveries.
+	RecordDelivery(m *Message) (*Message, error)
+	// UpdateMessage calls fn with the stored record for the email with the
+	// given Message-ID, and stores the result. It returns ErrNotFound if there's
+	// no such record.
+	UpdateMessage(id string, fn func(m *Message)) error
+	// Message returns the record for the email with the given Message-ID, or
+	// ErrNotFound.
+	Message(id string) (*Message, error)
+	// RecentMessages returns up to limit message records, most recently
+	// delivered first.
+	RecentMessages(limit int) ([]*Message, error)
+
+	// AddWatcher adds email to the watchers of the policy at wp.URL, starting
+	// to watch it (with the rest of wp's fields) if nobody was yet. Adding an
+	// existing watcher is a no-op. It returns the updated watched policy.
+	AddWatcher(wp *WatchedPolicy, email string) (*WatchedPolicy, error)
+	// RemoveWatcher removes email from the watchers of the policy at
+	// policyURL, and stops watching the policy if that was the last watcher.
+	// It returns ErrNotFound if email wasn't watching the policy.
+	RemoveWatcher(policyURL, email string) error
+	// UpdateWatchedPolicy calls fn with the watched policy at policyURL, and
+	// stores the result. It returns ErrNotFound if nobody is watching the
+	// policy.
+	UpdateWatchedPolicy(policyURL string, fn func(wp *WatchedPolicy)) error
+	// WatchedPolicies returns every watched policy, in no particular order.
+	WatchedPolicies() ([]*WatchedPolicy, error)
+
+	// AddPendingWatch stores pw until it's confirmed, assigning it a Token
+	// and CreatedAt time.
+	AddPendingWatch(pw *PendingWatch) error
+	// PendingWatch returns the pending watch with the given token, or
+	// ErrNotFound.
+	PendingWatch(token string) (*PendingWatch, error)
+	// RemovePendingWatch removes the pending watch with the given token. It
+	// returns ErrNotFound if there isn't one.
+	RemovePendingWatch(token string) error
+
+	// PutReport creates or replaces the report stored under r.Key, setting its
+	// CreatedAt time if it doesn't have one yet.
+	PutReport(r *Report) error
+	// Report returns the report stored under key, or ErrNotFound.
+	Report(key ReportKey) (*Report, error)
+
+	// AddUsage adds u to the running totals of LLM usage, overall and for the
+	// user with the given email and for company. Either can be empty, e.g. for
+	// scheduled checks that aren't on anyone's behalf.
+	AddUsage(email, company string, u claude.Usage) error
+	// Usage returns the running totals of LLM usage.
+	Usage() (*UsageTotals, error)
+
+	Close() error
+}
+
+// Sources of policy versions.
+const (
+	SourceLive       = "live"
+	SourceWebArchive = "webarchive"
+)
+
+// A PolicyVersion is the text of a policy document, as fetched from a
+// particular place at a particular time.
+type PolicyVersion struct {
+	// URL is the canonical URL of the policy, see CanonicalURL.
+	URL string `json:"url"`
+	// Hash is the hex-encoded SHA-256 hash of Text.
+	Hash string `json:"hash"`
+	// Text is the document, as extracted Markdown.
+	Text string `json:"text"`
+	// Source is where the text came from, one of the Source* constants.
+	Source string `json:"source"`
+	// FetchedURL is the URL the text was actually loaded from, e.g. a Web
+	// Archive snapshot.
+	FetchedURL string `json:"fetched_url"`
+	// FetchedAt is when the text was captured. For archived versions, that's
+	// the snapshot time.
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// An Analysis records a single run of our pipeline on an inbound email.
+type Analysis struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// MessageID, From and Subject describe the email we received.
+	MessageID string `json:"message_id"`
+	From      string `json:"from"`
+	Subject   string `json:"subject"`
+
+	Classification *claude.PolicyClassification `json:"classification,omitempty"`
+
+	// PolicyURL is the canonical URL of the policy we analyzed, and
+	// CurrentHash and PreviousHash identify the versions of it we compared. See
+	// PolicyVersion.
+	PolicyURL    string `json:"policy_url,omitempty"`
+	CurrentHash  string `json:"current_hash,omitempty"`
+	PreviousHash string `json:"previous_hash,omitempty"`
+	// PreviousURL and PreviousDate are where the previous version came from,
+	// e.g. a Web Archive snapshot, and when it was captured.
+	PreviousURL  string    `json:"previous_url,omitempty"`
+	PreviousDate time.Time `json:"previous_date,omitzero"`
+
+	// Changes is the description of the changes we gave the LLM, and
+	// DiffHighlights are what it made of them.
+	Changes        string                 `json:"changes,omitempty"`
+	DiffHighlights []claude.DiffHighlight `json:"diff_highlights,omitempty"`
+	// SummaryHighlights summarize the policy, when we couldn't find a previous
+	// version to compare against.
+	SummaryHighlights []claude.PolicyHighlight `json:"summary_highlights,omitempty"`
+	// Trimmed is true if the input to the LLM was cut short, in which case
+	// AnalyzedPercent is how much of it the LLM saw, if we know.
+	Trimmed         bool `json:"trimmed,omitempty"`
+	AnalyzedPercent int  `json:"analyzed_percent,omitempty"`
+	// ReusedReport is true if the highlights came from a stored Report,
+	// rather than a new request to the LLM.
+	ReusedReport bool `json:"reused_report,omitempty"`
+	// Usage is the tokens used by the LLM requests we made, which doesn't
+	// include reused reports.
+	Usage claude.Usage `json:"usage,omitzero"`
+
+	// ReplySubject, ReplyText and ReplyHTML are the email we sent back, and
+	// SentAt is when we sent it, or zero if we didn't.
+	ReplySubject string    `json:"reply_subject,omitempty"`
+	ReplyText    string    `json:"reply_text,omitempty"`
+	ReplyHTML    string    `json:"reply_html,omitempty"`
+	SentAt       time.Time `json:"sent_at,omitzero"`
+
+	// Outcome is a short description of how processing ended, e.g. "Email
+	// processed - not a policy change".
+	Outcome string `json:"outcome"`
+}
+
+// A Message tracks an inbound email across deliveries, so that retried
+// webhooks don't get processed (and replied to) twice.
+type Message struct {
+	// ID is the email's Message-ID.
+	ID      string `json:"id"`
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+
+	// FirstSeen and LastSeen are when we first and last received the email,
+	// and Deliveries is the number of times we did.
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	Deliveries int       `json:"deliveries"`
+
+	// QueuedAt is when we queued the email for processing, or zero if we
+	// haven't (yet).
+	QueuedAt time.Time `json:"queued_at,omitzero"`
+	// AnalysisIDs are the analyses we ran on the email, one per attempt.
+	AnalysisIDs []string `json:"analysis_ids,omitempty"`
+	// Outcome is the outcome of the latest analysis, see Analysis.Outcome.
+	Outcome string `json:"outcome,omitempty"`
+	// Classification is how we classified the email, kept so that retrying it
+	// doesn't pay to classify it again, or count against the sender's rate
+	// limit again.
+	Classification *claude.PolicyClassification `json:"classification,omitempty"`
+	// RepliedAt is when we sent our reply, or zero if we haven't.
+	RepliedAt time.Time `json:"replied_at,omitzero"`
+}
+
+// A WatchedPolicy is a policy that we periodically re-check for changes, on
+// behalf of the people watching it.
+type WatchedPolicy struct {
+	// URL is the canonical URL of the policy, see CanonicalURL.
+	URL string `json:"url"`
+	// FetchURL is the URL the policy was first watched at, which we fetch it
+	// from, since not every site serves the canonical URL (say, without
+	// "www."). It's set from URL when the policy is first watched.
+	FetchURL string `json:"fetch_url,omitempty"`
+	// Company and PolicyType describe the policy, as in
+	// claude.PolicyClassification.
+	Company    string `json:"company,omitempty"`
+	PolicyType string `json:"policy_type,omitempty"`
+
+	Watchers []Watcher `json:"watchers"`
+
+	// Hash is the hash of the version of the policy we last saw, or empty if
+	// we haven't checked it yet. See PolicyVersion.
+	Hash string `json:"hash,omitempty"`
+	// CheckedAt is when we last checked the policy, and ChangedAt is when we
+	// last saw it change.
+	CheckedAt time.Time `json:"checked_at,omitzero"`
+	ChangedAt time.Time `json:"changed_at,omitzero"`
+	// LastError is the error from the last check, if it failed.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// A Watcher is someone watching a policy.
+type Watcher struct {
+	Email string    `json:"email"`
+	Since time.Time `json:"since"`
+	// NotifiedHash is the hash of the last version of the policy we emailed
+	// them about, so that when a change only reaches some watchers, we can
+	// retry the rest without emailing the others again.
+	NotifiedHash string `json:"notified_hash,omitempty"`
+}
+
+// HasWatcher reports whether email is watching the policy. Emails are
+// compared case-insensitively.
+func (wp *WatchedPolicy) HasWatcher(email string) bool {
+	return wp.watcherIndex(email) >= 0
+}
+
+func (wp *WatchedPolicy) watcherIndex(email string) int {
+	email = strings.TrimSpace(email)
+	return slices.IndexFunc(wp.Watchers, func(w Watcher) bool {
+		return strings.EqualFold(w.Email, email)
+	})
+}
+
+// MarkNotified records that email was notified about the version of the
+// policy with the given hash, see Watcher.NotifiedHash.
+func (wp *WatchedPolicy) MarkNotified(email, hash string) {
+	if i := wp.watcherIndex(email); i >= 0 {
+		wp.Watchers[i].NotifiedHash = hash
+	}
+}
+
+// addWatcher adds email to wp's watchers, if it isn't one already.
+func (wp *WatchedPolicy) addWatcher(email string, now time.Time) {
+	if !wp.HasWatcher(email) {
+		wp.Watchers = append(wp.Watchers, Watcher{Email: strings.TrimSpace(email), Since: now})
+	}
+}
+
+// removeWatcher removes email from wp's watchers, reporting whether it was
+// one.
+func (wp *WatchedPolicy) removeWatcher(email string) bool {
+	i := wp.watcherIndex(email)
+	if i < 0 {
+		return false
+	}
+	wp.Watchers = slices.Delete(wp.Watchers, i, i+1)
+	return true
+}
+
+func (wp *WatchedPolicy) clone() *WatchedPolicy {
+	cp := *wp
+	cp.Watchers = slices.Clone(wp.Watchers)
+	return &cp
+}
+
+// A PendingWatch is a watch command waiting for confirmation. Anyone can put
+// any address in an email's From header, so rather than start emailing an
+// address about a policy as soon as someone asks, we email it a token, and
+// only start watching once it's sent back.
+type PendingWatch struct {
+	// Token identifies the pending watch, and is only sent to Email.
+	Token string `json:"token"`
+	// Email is who asked to watch Policy.
+	Email     string         `json:"email"`
+	Policy    *WatchedPolicy `json:"policy"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+func (pw *PendingWatch) clone() *PendingWatch {
+	cp := *pw
+	if pw.Policy != nil {
+		cp.Policy = pw.Policy.clone()
+	}
+	return &cp
+}
+
+// A ReportKey identifies the input to an LLM-generated report, so that
+// reports can be reused when many people forward us the same policy change.
+type ReportKey struct {
+	// PolicyURL is the canonical URL of the policy, see CanonicalURL.
+	PolicyURL string `json:"policy_url"`
+	// PreviousHash is the hash of the version the report compares against, or
+	// empty for a summary of a single version.
+	PreviousHash string `json:"previous_hash,omitempty"`
+	// CurrentHash is the hash of the current version of the policy.
+	CurrentHash string `json:"current_hash"`
+}
+
+func (k ReportKey) canonical() ReportKey {
+	k.PolicyURL = CanonicalURL(k.PolicyURL)
+	return k
+}
+
+// A Report is the result of asking the LLM about a policy, either a diff
+// report (when Key.PreviousHash is set) or a summary.
+type Report struct {
+	Key       ReportKey `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Diff    *claude.DiffSummary   `json:"diff,omitempty"`
+	Summary *claude.PolicySummary `json:"summary,omitempty"`
+}
+
+// UsageTotals are the tokens we've used on LLM requests, so we know what
+// analyzing emails costs, and who and what it's costing us for.
+type UsageTotals struct {
+	Total claude.Usage `json:"total"`
+	// Users are keyed by normalized email address, see
+	// ratelimit.NormalizeEmail.
+	Users map[string]claude.Usage `json:"users"`
+	// Companies are keyed by the company name the LLM gave for the policy.
+	Companies map[string]claude.Usage `json:"companies"`
+}
+
+func newUsageTotals() *UsageTotals {
+	return &UsageTotals{
+		Users:     make(map[string]claude.Usage),
+		Companies: make(map[string]claude.Usage),
+	}
+}
+
+func (t *UsageTotals) add(email, company string, u claude.Usage) {
+	t.Total.Add(u)
+	addTo := func(m map[string]claude.Usage, key string) {
+		if key == "" {
+			return
+		}
+		total := m[key]
+		total.Add(u)
+		m[key] = total
+	}
+	addTo(t.Users, email)
+	addTo(t.Companies, strings.TrimSpace(company))
+}
+
+func (t *UsageTotals) clone() *UsageTotals {
+	return &UsageTotals{
+		Total:     t.Total,
+		Users:     maps.Clone(t.Users),
+		Companies: maps.Clone(t.Companies),
+	}
+}
+
+// HashText returns the hex-encoded SHA-256 hash of text, as used for
+// PolicyVersion.Hash.
+func HashText(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// CanonicalURL normalizes a policy URL, so that trivially different links to
+// the same document share an identity: the scheme and host are lowercased,
+// "www." and default ports are dropped, trailing slashes and fragments are
+// removed, tracking parameters (utm_*) are removed and the remaining query
+// parameters are sorted. URLs that can't be parsed are returned as-is, minus
+// surrounding whitespace.
+func CanonicalURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme == "http" {
+		// Almost every site redirects to HTTPS these days, and the content is
+		// the same either way.
+		u.Scheme = "https"
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	u.Host = host
+	u.User = nil
+	u.Fragment, u.RawFragment = "", ""
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+
+	q := u.Query()
+	for k := range q {
+		if strings.HasPrefix(strings.ToLower(k), "utm_") {
+			q.Del(k)
+		}
+	}
+	// Encode sorts by key.
+	u.RawQuery = q.Encode()
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+// newID returns a new analysis ID. IDs start with the creation time, so they
+// sort chronologically.
+func newID(t time.Time) string {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand doesn't fail on any platform we run on.
+		panic(err)
+	}
+	return t.UTC().Format("20060102T150405.000Z") + "-" + hex.EncodeToString(b[:])
+}
+
+// prepareVersion canonicalizes v's key fields in place.
+func prepareVersion(v *PolicyVersion) {
+	v.URL = CanonicalURL(v.URL)
+	if v.Hash == "" {
+		v.Hash = HashText(v.Text)
+	}
+}
+
+// prepareAnalysis fills in a's ID and CreatedAt, if they aren't set.
+func prepareAnalysis(a *Analysis, now time.Time) {
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = now
+	}
+	if a.ID == "" {
+		a.ID = newID(a.CreatedAt)
+	}
+	if a.PolicyURL != "" {
+		a.PolicyURL = CanonicalURL(a.PolicyURL)
+	}
+}
+
+// prepareReport canonicalizes r's key and fills in its CreatedAt, if it isn't
+// set.
+func prepareReport(r *Report, now time.Time) {
+	r.Key = r.Key.canonical()
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now
+	}
+}
+
+// preparePendingWatch gives pw a new random token, and sets its CreatedAt.
+func preparePendingWatch(pw *PendingWatch, now time.Time) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand doesn't fail on any platform we run on.
+		panic(err)
+	}
+	pw.Token = hex.EncodeToString(b[:])
+	pw.CreatedAt = now
+}
+
+// validToken reports whether token could have come from preparePendingWatch,
+// so that it's safe to use in a file name.
+func validToken(token string) bool {
+	_, err := hex.DecodeString(token)
+	return token != "" && err == nil
+}
+
+// sortMessages sorts messages by LastSeen, most recent first.
+func sortMessages(messages []*Message) {
+	slices.SortStableFunc(messages, func(a, b *Message) int {
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+}
+
+// sortVersions sorts versions oldest first.
+func sortVersions(versions []*PolicyVersion) {
+	slices.SortStableFunc(versions, func(a, b *PolicyVersion) int {
+		return a.FetchedAt.Compare(b.FetchedAt)
+	})
+}
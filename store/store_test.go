@@ -0,0 +1,452 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bcspragu/fineprint/claude"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"https://example.com/privacy", "https://example.com/privacy"},
+		{"  HTTP://WWW.Example.COM/privacy/  ", "https://example.com/privacy"},
+		{"https://example.com:443/privacy#data-we-collect", "https://example.com/privacy"},
+		{"https://example.com:8443/privacy", "https://example.com:8443/privacy"},
+		{"https://example.com/privacy?utm_source=email&lang=en&b=2", "https://example.com/privacy?b=2&lang=en"},
+		{"https://example.com/Privacy", "https://example.com/Privacy"},
+		{"https://example.com/", "https://example.com"},
+		{"not a url", "not a url"},
+	}
+	for _, tt := range tests {
+		if got := CanonicalURL(tt.in); got != tt.want {
+			t.Errorf("CanonicalURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func testStores(t *testing.T) map[string]Store {
+	disk, err := OpenDisk(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenDisk: %v", err)
+	}
+	return map[string]Store{
+		"memory": NewMemory(),
+		"disk":   disk,
+	}
+}
+
+func TestStore_PolicyVersions(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer s.Close()
+
+			t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			t2 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			newer := &PolicyVersion{URL: "https://www.example.com/privacy/", Text: "v2", Source: SourceLive, FetchedAt: t2}
+			older := &PolicyVersion{URL: "https://example.com/privacy", Text: "v1", Source: SourceWebArchive, FetchedAt: t1}
+			for _, v := range []*PolicyVersion{newer, older} {
+				if err := s.PutPolicyVersion(v); err != nil {
+					t.Fatalf("PutPolicyVersion: %v", err)
+				}
+			}
+			if newer.URL != "https://example.com/privacy" || newer.Hash != HashText("v2") {
+				t.Errorf("PutPolicyVersion didn't fill in key fields: %+v", newer)
+			}
+
+			// Storing the same text again keeps the original record.
+			dup := &PolicyVersion{URL: "https://example.com/privacy", Text: "v2", FetchedAt: t2.Add(time.Hour)}
+			if err := s.PutPolicyVersion(dup); err != nil {
+				t.Fatalf("PutPolicyVersion: %v", err)
+			}
+
+			got, err := s.PolicyVersion("http://example.com/privacy", HashText("v2"))
+			if err != nil {
+				t.Fatalf("PolicyVersion: %v", err)
+			}
+			if !got.FetchedAt.Equal(t2) || got.Source != SourceLive {
+				t.Errorf("PolicyVersion() = %+v, want the original record", got)
+			}
+
+			versions, err := s.PolicyVersions("https://example.com/privacy")
+			if err != nil {
+				t.Fatalf("PolicyVersions: %v", err)
+			}
+			if len(versions) != 2 || versions[0].Text != "v1" || versions[1].Text != "v2" {
+				t.Errorf("PolicyVersions() = %+v, want v1 then v2", versions)
+			}
+
+			if _, err := s.PolicyVersion("https://example.com/privacy", HashText("v3")); !errors.Is(err, ErrNotFound) {
+				t.Errorf("PolicyVersion(missing) error = %v, want ErrNotFound", err)
+			}
+			if versions, err := s.PolicyVersions("https://example.com/terms"); err != nil || len(versions) != 0 {
+				t.Errorf("PolicyVersions(missing) = %v, %v, want none", versions, err)
+			}
+		})
+	}
+}
+
+func TestStore_Analyses(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer s.Close()
+
+			base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+			var ids []string
+			for i, subject := range []string{"first", "second", "third"} {
+				a := &Analysis{Subject: subject, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+				if err := s.PutAnalysis(a); err != nil {
+					t.Fatalf("PutAnalysis: %v", err)
+				}
+				if a.ID == "" {
+					t.Fatal("PutAnalysis didn't assign an ID")
+				}
+				ids = append(ids, a.ID)
+			}
+
+			// Updating an analysis replaces it.
+			a, err := s.Analysis(ids[1])
+			if err != nil {
+				t.Fatalf("Analysis: %v", err)
+			}
+			a.Outcome = "sent"
+			a.SentAt = base.Add(time.Hour)
+			if err := s.PutAnalysis(a); err != nil {
+				t.Fatalf("PutAnalysis: %v", err)
+			}
+			if got, err := s.Analysis(ids[1]); err != nil || got.Outcome != "sent" || !got.SentAt.Equal(a.SentAt) {
+				t.Errorf("Analysis() = %+v, %v, want the updated record", got, err)
+			}
+
+			recent, err := s.RecentAnalyses(2)
+			if err != nil {
+				t.Fatalf("RecentAnalyses: %v", err)
+			}
+			if len(recent) != 2 || recent[0].Subject != "third" || recent[1].Subject != "second" {
+				t.Errorf("RecentAnalyses(2) = %+v, want third then second", recent)
+			}
+
+			if _, err := s.Analysis("nope"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Analysis(missing) error = %v, want ErrNotFound", err)
+			}
+			if _, err := s.Analysis("../nope"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Analysis(../nope) error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestStore_Reports(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer s.Close()
+
+			diffKey := ReportKey{PolicyURL: "https://www.example.com/privacy", PreviousHash: HashText("v1"), CurrentHash: HashText("v2")}
+			summaryKey := ReportKey{PolicyURL: "https://example.com/privacy", CurrentHash: HashText("v2")}
+
+			if _, err := s.Report(diffKey); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Report(missing) error = %v, want ErrNotFound", err)
+			}
+
+			diffReport := &Report{
+				Key:  diffKey,
+				Diff: &claude.DiffSummary{Highlights: []claude.DiffHighlight{{Description: "We now sell your data", Classification: "bad"}}},
+			}
+			summaryReport := &Report{
+				Key:     summaryKey,
+				Summary: &claude.PolicySummary{Highlights: []claude.PolicyHighlight{{Description: "We collect your email", Classification: "neutral"}}, Trimmed: true},
+			}
+			for _, r := range []*Report{diffReport, summaryReport} {
+				if err := s.PutReport(r); err != nil {
+					t.Fatalf("PutReport: %v", err)
+				}
+			}
+			if diffReport.CreatedAt.IsZero() {
+				t.Error("PutReport didn't set CreatedAt")
+			}
+
+			// Lookups use the canonical URL.
+			got, err := s.Report(ReportKey{PolicyURL: "http://example.com/privacy/", PreviousHash: HashText("v1"), CurrentHash: HashText("v2")})
+			if err != nil {
+				t.Fatalf("Report: %v", err)
+			}
+			if got.Diff == nil || len(got.Diff.Highlights) != 1 || got.Diff.Highlights[0].Description != "We now sell your data" || got.Summary != nil {
+				t.Errorf("Report(diff) = %+v, want the diff report", got)
+			}
+
+			got, err = s.Report(summaryKey)
+			if err != nil {
+				t.Fatalf("Report: %v", err)
+			}
+			if got.Summary == nil || !got.Summary.Trimmed || got.Diff != nil {
+				t.Errorf("Report(summary) = %+v, want the summary report", got)
+			}
+		})
+	}
+}
+
+func TestStore_Messages(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer s.Close()
+
+			const id = "<CAB123@mail.example.com>"
+			for want := 1; want <= 3; want++ {
+				m, err := s.RecordDelivery(&Message{ID: id, From: "alice@example.com", Subject: "Privacy update"})
+				if err != nil {
+					t.Fatalf("RecordDelivery: %v", err)
+				}
+				if m.Deliveries != want {
+					t.Errorf("RecordDelivery() #%d has %d deliveries", want, m.Deliveries)
+				}
+			}
+
+			err := s.UpdateMessage(id, func(m *Message) {
+				m.AnalysisIDs = append(m.AnalysisIDs, "analysis-1")
+				m.Outcome = "done"
+			})
+			if err != nil {
+				t.Fatalf("UpdateMessage: %v", err)
+			}
+
+			m, err := s.Message(id)
+			if err != nil {
+				t.Fatalf("Message: %v", err)
+			}
+			if m.Deliveries != 3 || m.Subject != "Privacy update" || m.Outcome != "done" || len(m.AnalysisIDs) != 1 {
+				t.Errorf("Message() = %+v, want 3 deliveries and the update", m)
+			}
+			if m.FirstSeen.IsZero() || m.LastSeen.Before(m.FirstSeen) {
+				t.Errorf("Message() has bad timestamps: %+v", m)
+			}
+
+			if _, err := s.RecordDelivery(&Message{ID: "<other@example.com>"}); err != nil {
+				t.Fatalf("RecordDelivery: %v", err)
+			}
+			recent, err := s.RecentMessages(1)
+			if err != nil {
+				t.Fatalf("RecentMessages: %v", err)
+			}
+			if len(recent) != 1 || recent[0].ID != "<other@example.com>" {
+				t.Errorf("RecentMessages(1) = %+v, want the latest message", recent)
+			}
+
+			if _, err := s.Message("<missing@example.com>"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Message(missing) error = %v, want ErrNotFound", err)
+			}
+			if err := s.UpdateMessage("<missing@example.com>", func(*Message) {}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("UpdateMessage(missing) error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestStore_Watches(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer s.Close()
+
+			policy := &WatchedPolicy{URL: "https://www.example.com/privacy/", Company: "Example", PolicyType: "privacy_policy"}
+			for _, email := range []string{"alice@example.com", "bob@example.com", "ALICE@example.com"} {
+				if _, err := s.AddWatcher(policy, email); err != nil {
+					t.Fatalf("AddWatcher(%q): %v", email, err)
+				}
+			}
+
+			err := s.UpdateWatchedPolicy("https://example.com/privacy", func(wp *WatchedPolicy) {
+				wp.Hash = HashText("v1")
+				wp.MarkNotified("Bob@example.com", HashText("v1"))
+			})
+			if err != nil {
+				t.Fatalf("UpdateWatchedPolicy: %v", err)
+			}
+
+			watched, err := s.WatchedPolicies()
+			if err != nil {
+				t.Fatalf("WatchedPolicies: %v", err)
+			}
+			if len(watched) != 1 {
+				t.Fatalf("WatchedPolicies() = %+v, want one policy", watched)
+			}
+			wp := watched[0]
+			if wp.URL != "https://example.com/privacy" || wp.FetchURL != "https://www.example.com/privacy/" || wp.Company != "Example" || wp.Hash != HashText("v1") {
+				t.Errorf("WatchedPolicies()[0] = %+v", wp)
+			}
+			if len(wp.Watchers) != 2 || !wp.HasWatcher("alice@example.com") || !wp.HasWatcher("bob@example.com") {
+				t.Errorf("watchers = %+v, want alice and bob", wp.Watchers)
+			}
+			for _, w := range wp.Watchers {
+				if want := map[string]string{"bob@example.com": HashText("v1")}[w.Email]; w.NotifiedHash != want {
+					t.Errorf("%s was notified about %q, want %q", w.Email, w.NotifiedHash, want)
+				}
+			}
+
+			if err := s.RemoveWatcher("https://example.com/privacy", "carol@example.com"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("RemoveWatcher(non-watcher) error = %v, want ErrNotFound", err)
+			}
+			for _, email := range []string{"Alice@example.com", "bob@example.com"} {
+				if err := s.RemoveWatcher("https://example.com/privacy", email); err != nil {
+					t.Fatalf("RemoveWatcher(%q): %v", email, err)
+				}
+			}
+			// Nobody's left, so the policy isn't watched anymore.
+			if watched, err := s.WatchedPolicies(); err != nil || len(watched) != 0 {
+				t.Errorf("WatchedPolicies() = %+v, %v, want none", watched, err)
+			}
+			if err := s.UpdateWatchedPolicy("https://example.com/privacy", func(*WatchedPolicy) {}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("UpdateWatchedPolicy(unwatched) error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestStore_PendingWatches(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer s.Close()
+
+			pw := &PendingWatch{Email: "alice@example.com", Policy: &WatchedPolicy{URL: "https://example.com/privacy", Company: "Example"}}
+			if err := s.AddPendingWatch(pw); err != nil {
+				t.Fatalf("AddPendingWatch: %v", err)
+			}
+			if pw.Token == "" || pw.CreatedAt.IsZero() {
+				t.Fatalf("AddPendingWatch left Token = %q, CreatedAt = %v, want them set", pw.Token, pw.CreatedAt)
+			}
+			other := &PendingWatch{Email: "alice@example.com", Policy: pw.Policy}
+			if err := s.AddPendingWatch(other); err != nil {
+				t.Fatalf("AddPendingWatch: %v", err)
+			}
+			if other.Token == pw.Token {
+				t.Errorf("two pending watches got the same token %q", pw.Token)
+			}
+
+			got, err := s.PendingWatch(pw.Token)
+			if err != nil {
+				t.Fatalf("PendingWatch: %v", err)
+			}
+			if got.Email != pw.Email || got.Policy.URL != pw.Policy.URL || !got.CreatedAt.Equal(pw.CreatedAt) {
+				t.Errorf("PendingWatch = %+v, want %+v", got, pw)
+			}
+
+			if err := s.RemovePendingWatch(pw.Token); err != nil {
+				t.Fatalf("RemovePendingWatch: %v", err)
+			}
+			for _, token := range []string{pw.Token, "0123456789abcdef", "../watches/x", ""} {
+				if _, err := s.PendingWatch(token); !errors.Is(err, ErrNotFound) {
+					t.Errorf("PendingWatch(%q) error = %v, want ErrNotFound", token, err)
+				}
+				if err := s.RemovePendingWatch(token); !errors.Is(err, ErrNotFound) {
+					t.Errorf("RemovePendingWatch(%q) error = %v, want ErrNotFound", token, err)
+				}
+			}
+			if _, err := s.PendingWatch(other.Token); err != nil {
+				t.Errorf("PendingWatch(other) error = %v, want it to still be pending", err)
+			}
+		})
+	}
+}
+
+func TestStore_Usage(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer s.Close()
+
+			totals, err := s.Usage()
+			if err != nil {
+				t.Fatalf("Usage: %v", err)
+			}
+			if totals.Total != (claude.Usage{}) || len(totals.Users) != 0 || len(totals.Companies) != 0 {
+				t.Errorf("Usage() = %+v, want nothing yet", totals)
+			}
+
+			classify := claude.Usage{InputTokens: 500, OutputTokens: 50}
+			report := claude.Usage{InputTokens: 2000, OutputTokens: 800, CacheCreationInputTokens: 1500}
+			cachedReport := claude.Usage{InputTokens: 2000, OutputTokens: 700, CacheReadInputTokens: 1500}
+			adds := []struct {
+				email, company string
+				u              claude.Usage
+			}{
+				{"alice@example.com", "Example", classify},
+				{"alice@example.com", "Example", report},
+				{"bob@example.com", "Other", classify},
+				{"bob@example.com", "Other", cachedReport},
+				// A scheduled check, on nobody's behalf.
+				{"", "Example", cachedReport},
+			}
+			for _, a := range adds {
+				if err := s.AddUsage(a.email, a.company, a.u); err != nil {
+					t.Fatalf("AddUsage: %v", err)
+				}
+			}
+
+			totals, err = s.Usage()
+			if err != nil {
+				t.Fatalf("Usage: %v", err)
+			}
+			want := claude.Usage{InputTokens: 7000, OutputTokens: 2300, CacheCreationInputTokens: 1500, CacheReadInputTokens: 3000}
+			if totals.Total != want {
+				t.Errorf("Total = %+v, want %+v", totals.Total, want)
+			}
+			if len(totals.Users) != 2 || totals.Users["alice@example.com"] != (claude.Usage{InputTokens: 2500, OutputTokens: 850, CacheCreationInputTokens: 1500}) {
+				t.Errorf("Users = %+v, want alice's classification and report, and bob", totals.Users)
+			}
+			if len(totals.Companies) != 2 || totals.Companies["Example"].CacheReadInputTokens != 1500 || totals.Companies["Other"].OutputTokens != 750 {
+				t.Errorf("Companies = %+v, want Example and Other", totals.Companies)
+			}
+
+			// The returned totals are a copy.
+			totals.Users["mallory@example.com"] = want
+			if again, _ := s.Usage(); len(again.Users) != 2 {
+				t.Errorf("modifying the returned totals changed the store: %+v", again.Users)
+			}
+		})
+	}
+}
+
+func TestDisk_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenDisk(dir)
+	if err != nil {
+		t.Fatalf("OpenDisk: %v", err)
+	}
+	v := &PolicyVersion{URL: "https://example.com/terms", Text: "Be nice."}
+	if err := s.PutPolicyVersion(v); err != nil {
+		t.Fatalf("PutPolicyVersion: %v", err)
+	}
+	a := &Analysis{Subject: "Terms update", PolicyURL: v.URL, CurrentHash: v.Hash}
+	if err := s.PutAnalysis(a); err != nil {
+		t.Fatalf("PutAnalysis: %v", err)
+	}
+	s.Close()
+
+	// No temporary files are left behind.
+	tmps, _ := filepath.Glob(filepath.Join(dir, "*", ".tmp-*"))
+	if len(tmps) > 0 {
+		t.Errorf("leftover temporary files: %v", tmps)
+	}
+
+	s, err = OpenDisk(dir)
+	if err != nil {
+		t.Fatalf("OpenDisk: %v", err)
+	}
+	got, err := s.Analysis(a.ID)
+	if err != nil {
+		t.Fatalf("Analysis: %v", err)
+	}
+	if got.Subject != a.Subject || !got.CreatedAt.Equal(a.CreatedAt) {
+		t.Errorf("Analysis() = %+v, want %+v", got, a)
+	}
+	if pv, err := s.PolicyVersion(got.PolicyURL, got.CurrentHash); err != nil || pv.Text != "Be nice." {
+		t.Errorf("PolicyVersion() = %+v, %v", pv, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "analyses", a.ID+".json")); err != nil {
+		t.Errorf("analysis file missing: %v", err)
+	}
+}
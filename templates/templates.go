@@ -26,6 +26,10 @@ type EmailTemplateData struct {
 	DeltaReport   *DeltaReport
 	SummaryReport *SummaryReport
 	ToSDR         *ToSDR
+
+	// WatchedURL is set when the email is about a policy the recipient is
+	// watching, rather than a reply to an email they sent us.
+	WatchedURL string
 }
 
 type DeltaReport struct {
@@ -37,12 +41,21 @@ type DeltaReport struct {
 
 	Points  []SummaryPoint
 	Trimmed bool
+	// AnalyzedPercent is how much of the changes we analyzed when Trimmed, or
+	// zero if we don't know.
+	AnalyzedPercent int
+
+	// HasRedline is true if the email has the full set of changes attached.
+	HasRedline bool
 }
 
 type SummaryReport struct {
 	Points    []SummaryPoint
 	PolicyURL string
 	Trimmed   bool
+	// AnalyzedPercent is how much of the policy we analyzed when Trimmed, or
+	// zero if we don't know.
+	AnalyzedPercent int
 }
 
 type SummaryPoint struct {
@@ -65,6 +78,7 @@ type GenerateRequest struct {
 	Service        *tosdr.Service
 	DeltaReport    *DeltaReport
 	SummaryReport  *SummaryReport
+	WatchedURL     string
 }
 
 var title = cases.Title(language.English)
@@ -77,6 +91,7 @@ func (gr *GenerateRequest) toEmailTemplateData() *EmailTemplateData {
 		DeltaReport:   gr.DeltaReport,
 		SummaryReport: gr.SummaryReport,
 		ToSDR:         toToSDR(gr.Service),
+		WatchedURL:    gr.WatchedURL,
 	}
 }
 
@@ -8,13 +8,21 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bcspragu/fineprint/htmlutil"
 )
 
+// DefaultBaseURL is where the Wayback Machine lives.
+const DefaultBaseURL = "https://web.archive.org"
+
 type Client struct {
+	// BaseURL is the root of the Wayback Machine, DefaultBaseURL outside of
+	// tests.
+	BaseURL    string
 	AccessKey  string
 	SecretKey  string
 	HTTPClient *http.Client
@@ -22,6 +30,7 @@ type Client struct {
 
 func NewClient(accessKey, secretKey string) *Client {
 	return &Client{
+		BaseURL:   DefaultBaseURL,
 		AccessKey: accessKey,
 		SecretKey: secretKey,
 		HTTPClient: &http.Client{
@@ -46,12 +55,11 @@ func (c *Client) GetSnapshots(targetURL string) ([]Snapshot, error) {
 		"fastLatest": {"true"},
 		"limit":      {"-10"},
 	}
-	u := url.URL{
-		Scheme:   "https",
-		Host:     "web.archive.org",
-		Path:     "/cdx/search/cdx",
-		RawQuery: v.Encode(),
+	u, err := url.Parse(strings.TrimSuffix(c.BaseURL, "/") + "/cdx/search/cdx")
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
 	}
+	u.RawQuery = v.Encode()
 	log.Printf("Getting snapshots via %q", u.String())
 	resp, err := c.HTTPClient.Get(u.String())
 	if err != nil {
@@ -127,7 +135,11 @@ func formatTimestamp(ts time.Time) string {
 }
 
 func (c *Client) LoadSnapshot(originalURL string, timestamp time.Time) (string, string, error) {
-	snapshotURL := fmt.Sprintf("https://web.archive.org/web/%s/%s", formatTimestamp(timestamp), originalURL)
+	snapshotURL := fmt.Sprintf("%s/web/%s/%s", strings.TrimSuffix(c.BaseURL, "/"), formatTimestamp(timestamp), originalURL)
+	base, err := url.Parse(snapshotURL)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse snapshot URL: %w", err)
+	}
 
 	log.Printf("Snapshot url %q", snapshotURL)
 
@@ -145,12 +157,23 @@ func (c *Client) LoadSnapshot(originalURL string, timestamp time.Time) (string,
 		return "", "", fmt.Errorf("snapshot request failed with status: %d", resp.StatusCode)
 	}
 
-	textContent, err := htmlutil.ExtractText(newWaybackToolbarStripper(resp.Body))
+	textContent, err := htmlutil.ExtractMainMarkdown(newWaybackToolbarStripper(resp.Body), base)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to extract text from HTML: %w", err)
+		return "", "", fmt.Errorf("failed to convert HTML to Markdown: %w", err)
 	}
 
-	return textContent, snapshotURL, nil
+	return unwrapArchiveLinks(textContent, base.Host), snapshotURL, nil
+}
+
+// unwrapArchiveLinks rewrites links in content archived by the Wayback Machine
+// at host back to their original URLs, so they don't show up as changes when
+// compared with the live version of the page. The Wayback Machine prefixes
+// every link in an archived page, e.g. with
+// "https://web.archive.org/web/20240101000000/" or
+// ".../web/20240101000000im_/".
+func unwrapArchiveLinks(content, host string) string {
+	prefix := regexp.MustCompile(`https?://` + regexp.QuoteMeta(host) + `/web/\d{1,14}[a-z_]*/`)
+	return prefix.ReplaceAllString(content, "")
 }
 
 func parseTimestamp(ts string) (time.Time, error) {
@@ -254,4 +254,52 @@ func TestWaybackToolbarStripper_NestedComments(t *testing.T) {
 	if string(result) != expected {
 		t.Errorf("Expected:\n%s\n\nGot:\n%s", expected, string(result))
 	}
-}
\ No newline at end of file
+}
+
+func TestUnwrapArchiveLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "page link",
+			input:    "See [our terms](https://web.archive.org/web/20240101120000/https://example.com/terms).",
+			expected: "See [our terms](https://example.com/terms).",
+		},
+		{
+			name:     "link with modifier",
+			input:    "[logo](https://web.archive.org/web/20240101120000im_/https://example.com/logo.png)",
+			expected: "[logo](https://example.com/logo.png)",
+		},
+		{
+			name:     "multiple links",
+			input:    "[a](http://web.archive.org/web/2024/http://a.example/) and [b](https://web.archive.org/web/20240101120000/https://b.example/x?y=1)",
+			expected: "[a](http://a.example/) and [b](https://b.example/x?y=1)",
+		},
+		{
+			name:     "unrelated links",
+			input:    "[archive](https://web.archive.org/) and [site](https://example.com/web/20240101/)",
+			expected: "[archive](https://web.archive.org/) and [site](https://example.com/web/20240101/)",
+		},
+		{
+			name:     "other host",
+			host:     "127.0.0.1:8080",
+			input:    "[a](http://127.0.0.1:8080/web/20240101120000/https://example.com/terms) and [b](https://web.archive.org/web/20240101120000/https://example.com/)",
+			expected: "[a](https://example.com/terms) and [b](https://web.archive.org/web/20240101120000/https://example.com/)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := tt.host
+			if host == "" {
+				host = "web.archive.org"
+			}
+			if got := unwrapArchiveLinks(tt.input, host); got != tt.expected {
+				t.Errorf("unwrapArchiveLinks() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}